@@ -1,15 +1,17 @@
 package main
 
 import (
+	"context"
 	"crypto/ed25519"
 	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"net"
+	"net/netip"
 	"os"
 	"os/signal"
-	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -26,8 +28,11 @@ import (
 	"github.com/RiV-chain/RiV-mesh/src/defaults"
 
 	"github.com/RiV-chain/RiV-mesh/src/core"
+	"github.com/RiV-chain/RiV-mesh/src/dns"
+	"github.com/RiV-chain/RiV-mesh/src/forward"
 	//"github.com/RiV-chain/RiV-mesh/src/ipv6rwc"
 	"github.com/RiV-chain/RiV-mesh/src/multicast"
+	"github.com/RiV-chain/RiV-mesh/src/proxy"
 	"github.com/RiV-chain/RiV-mesh/src/restapi"
 	"github.com/RiV-chain/RiV-mesh/src/tun"
 	"github.com/RiV-chain/RiV-mesh/src/version"
@@ -36,8 +41,17 @@ import (
 type node struct {
 	core        *core.Core
 	tun         *tun.TunAdapter
+	netstack    userspaceStack // instead of tun, see IfName
+	proxy       *proxy.Proxy
+	forwarder   *forward.Forwarder
+	dns         *dns.Server
 	multicast   *multicast.Multicast
 	rest_server *restapi.RestServer
+	logger      *log.Logger
+	logLevel    string             // from -loglevel, used when the config doesn't set one
+	configFile  string             // empty unless the config was read from a file
+	config      *config.NodeConfig // as last read, see reload
+	reloadMutex sync.Mutex
 }
 
 func setLogLevel(loglevel string, logger *log.Logger) {
@@ -58,10 +72,17 @@ func setLogLevel(loglevel string, logger *log.Logger) {
 		loglevel = "info"
 	}
 
+	// Disable the levels above the chosen one, as they may have been enabled
+	// before the configuration was reloaded.
+	enabled := true
 	for _, l := range levels {
-		logger.EnableLevel(l)
+		if enabled {
+			logger.EnableLevel(l)
+		} else {
+			logger.DisableLevel(l)
+		}
 		if l == loglevel {
-			break
+			enabled = false
 		}
 	}
 }
@@ -201,7 +222,15 @@ func run(args rivArgs, sigCh chan os.Signal) {
 	if cfg == nil {
 		return
 	}
-	n := &node{}
+	if cfg.LogLevel != "" {
+		setLogLevel(cfg.LogLevel, logger)
+	}
+	n := &node{
+		logger:     logger,
+		logLevel:   args.loglevel,
+		configFile: args.useconffile,
+		config:     cfg,
+	}
 	// Have we been asked for the node address yet? If so, print it and then stop.
 	getNodeKey := func() ed25519.PublicKey {
 		if pubkey, err := hex.DecodeString(cfg.PrivateKey); err == nil {
@@ -258,6 +287,36 @@ func run(args rivArgs, sigCh chan os.Signal) {
 			}
 			options = append(options, core.AllowedPublicKey(k[:]))
 		}
+		for _, denied := range cfg.DeniedPublicKeys {
+			k, err := hex.DecodeString(denied)
+			if err != nil {
+				panic(err)
+			}
+			options = append(options, core.DeniedPublicKey(k[:]))
+		}
+		for _, denied := range cfg.DeniedNetworks {
+			prefix, err := netip.ParsePrefix(denied)
+			if err != nil {
+				panic(err)
+			}
+			options = append(options, core.DeniedNetwork(prefix))
+		}
+		minInterval, maxInterval, err := cfg.PeerReconnect.Intervals()
+		if err != nil {
+			panic(err)
+		}
+		options = append(options, core.PeerReconnect{
+			MinInterval: minInterval,
+			MaxInterval: maxInterval,
+			Jitter:      cfg.PeerReconnect.Jitter,
+		})
+		if cfg.LinkTimeout != "" {
+			timeout, err := time.ParseDuration(cfg.LinkTimeout)
+			if err != nil {
+				panic(err)
+			}
+			options = append(options, core.LinkTimeout(timeout))
+		}
 		if n.core, err = core.New(sk[:], logger, options...); err != nil {
 			panic(err)
 		}
@@ -265,50 +324,118 @@ func run(args rivArgs, sigCh chan os.Signal) {
 
 	// Setup the multicast module.
 	{
+		intfs, err := multicastInterfacesFor(cfg)
+		if err != nil {
+			panic(err)
+		}
 		options := []multicast.SetupOption{}
-		for _, intf := range cfg.MulticastInterfaces {
-			options = append(options, multicast.MulticastInterface{
-				Regex:    regexp.MustCompile(intf.Regex),
-				Beacon:   intf.Beacon,
-				Listen:   intf.Listen,
-				Port:     intf.Port,
-				Priority: uint8(intf.Priority),
-			})
+		for _, intf := range intfs {
+			options = append(options, intf)
 		}
 		if n.multicast, err = multicast.New(n.core, logger, options...); err != nil {
 			fmt.Println("Multicast module fail:", err)
 		}
 	}
 
-	// Setup the TUN module.
-	{
+	// Setup the TUN module. The proxy and forwards reach the mesh through it,
+	// or through a userspace TCP/IP stack if there is no TUN interface. The
+	// stack is only started for them, so a node without TUN that doesn't use
+	// them just discards its traffic as before.
+	var network forward.Network
+	noTUN := cfg.IfName == "none" || cfg.IfName == "dummy"
+	needNetwork := len(cfg.ProxyListen) > 0 || len(cfg.Forwards) > 0
+	if !noTUN || !needNetwork {
 		options := []tun.SetupOption{
 			tun.InterfaceName(cfg.IfName),
 			tun.InterfaceMTU(cfg.IfMTU),
 		}
-		if n.tun, err = tun.New(n.core, logger, options...); err != nil {
+		if n.tun, err = tun.New(n.core, logger, options...); err == nil {
+			if !noTUN {
+				network = tunNetwork{n.core.Address()}
+			}
+		} else if needNetwork {
+			n.tun = nil
+			logger.Errorln("Failed to start TUN, using a userspace TCP/IP stack instead:", err)
+		} else {
+			panic(err)
+		}
+	}
+	if network == nil && needNetwork {
+		if n.netstack, err = newUserspaceStack(n.core, logger, cfg.IfMTU); err != nil {
+			panic(err)
+		}
+		network = n.netstack
+	}
+
+	// Setup the proxy.
+	if len(cfg.ProxyListen) > 0 {
+		options := []proxy.SetupOption{}
+		if cfg.DNSDomain != "" {
+			options = append(options, proxy.Domain(cfg.DNSDomain))
+		}
+		for _, addr := range cfg.ProxyListen {
+			options = append(options, proxy.ListenAddress(addr))
+		}
+		if n.proxy, err = proxy.New(n.core, network, logger, options...); err != nil {
+			panic(err)
+		}
+	}
+
+	// Setup the forwards.
+	{
+		options := []forward.SetupOption{}
+		for _, fw := range forwardsFor(cfg) {
+			options = append(options, fw)
+		}
+		if n.forwarder, err = forward.New(n.core, network, logger, options...); err != nil {
+			panic(err)
+		}
+	}
+
+	// Setup the DNS server, which finds the keys of addresses from the traffic
+	// that passes through TUN or the userspace stack.
+	if len(cfg.DNSListen) > 0 {
+		options := []dns.SetupOption{}
+		for _, addr := range cfg.DNSListen {
+			addr = strings.Replace(addr, "<tun>", n.core.Address().String(), 1)
+			options = append(options, dns.ListenAddress(addr))
+		}
+		if cfg.DNSDomain != "" {
+			options = append(options, dns.Domain(cfg.DNSDomain))
+		}
+		var keys dns.KeyFinder
+		if n.tun != nil {
+			keys = n.tun
+		} else if n.netstack != nil {
+			keys = n.netstack
+		}
+		if n.dns, err = dns.New(n.core, keys, logger, options...); err != nil {
 			panic(err)
 		}
 	}
 
 	// Setup the REST socket.
 	{
-		//override httpaddress and wwwroot parameters in cfg
-		if len(cfg.HttpAddress) == 0 {
-			cfg.HttpAddress = args.httpaddress
+		//override httpaddress and wwwroot parameters, leaving cfg as it was
+		//read so that reloading it doesn't see them as changed
+		httpAddress, wwwRoot := cfg.HttpAddress, cfg.WwwRoot
+		if len(httpAddress) == 0 {
+			httpAddress = args.httpaddress
 		}
-		if len(cfg.WwwRoot) == 0 {
-			cfg.WwwRoot = args.wwwroot
+		if len(wwwRoot) == 0 {
+			wwwRoot = args.wwwroot
 		}
-		cfg.HttpAddress = strings.Replace(cfg.HttpAddress, "<tun>", "["+n.core.Address().String()+"]", 1)
+		httpAddress = strings.Replace(httpAddress, "<tun>", "["+n.core.Address().String()+"]", 1)
 
 		if n.rest_server, err = restapi.NewRestServer(restapi.RestServerCfg{
 			Core:          n.core,
 			Multicast:     n.multicast,
 			Log:           logger,
-			ListenAddress: cfg.HttpAddress,
-			WwwRoot:       cfg.WwwRoot,
+			ListenAddress: httpAddress,
+			WwwRoot:       wwwRoot,
 			ConfigFn:      args.useconffile,
+			Reload:        n.reload,
+			Forwarder:     n.forwarder,
 			Features:      []string{},
 		}); err != nil {
 			logger.Errorln(err)
@@ -337,14 +464,76 @@ func run(args rivArgs, sigCh chan os.Signal) {
 		//Slee code gives a chance to run Stop methods.
 		time.Sleep(10 * time.Second)
 	})
-	// Block until we are told to shut down.
-	<-sigCh
+	// Block until we are told to shut down, reloading the configuration each
+	// time we get a SIGHUP.
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	defer signal.Stop(hupCh)
+wait:
+	for {
+		select {
+		case <-sigCh:
+			break wait
+		case <-hupCh:
+			if _, _, err := n.reload(); err != nil {
+				logger.Errorln("Failed to reload configuration:", err)
+			}
+		}
+	}
 	_ = n.multicast.Stop()
-	_ = n.tun.Stop()
-	n.core.Stop()
+	if n.dns != nil {
+		_ = n.dns.Stop()
+	}
+	if n.proxy != nil {
+		_ = n.proxy.Stop()
+	}
+	_ = n.forwarder.Stop()
+	if n.netstack != nil {
+		_ = n.netstack.Stop()
+	}
+	if n.tun != nil {
+		_ = n.tun.Stop()
+	}
+	// Give peers a chance to hear that we are going before the links close.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := n.core.Shutdown(ctx); err != nil {
+		logger.Warnln("Shutdown did not finish cleanly:", err)
+	}
 	n.rest_server.Shutdown()
 }
 
+// userspaceStack is a TCP/IP stack that runs in the process instead of TUN,
+// see newUserspaceStack. Builds with the nonetstack tag leave it out.
+type userspaceStack interface {
+	forward.Network
+	dns.KeyFinder
+	Stop() error
+}
+
+// tunNetwork reaches the mesh through the TUN adapter, using the operating
+// system's TCP/IP stack.
+type tunNetwork struct {
+	addr net.IP // the node's mesh address
+}
+
+func (t tunNetwork) DialTCP(ctx context.Context, raddr *net.TCPAddr) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", raddr.String())
+}
+
+func (t tunNetwork) ListenTCP(port uint16) (net.Listener, error) {
+	return net.Listen("tcp", net.JoinHostPort(t.addr.String(), strconv.Itoa(int(port))))
+}
+
+func (t tunNetwork) DialUDP(raddr *net.UDPAddr) (net.Conn, error) {
+	return net.Dial("udp", raddr.String())
+}
+
+func (t tunNetwork) ListenUDP(port uint16) (net.PacketConn, error) {
+	return net.ListenPacket("udp", net.JoinHostPort(t.addr.String(), strconv.Itoa(int(port))))
+}
+
 func main() {
 	args := getArgs()
 
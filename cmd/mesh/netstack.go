@@ -0,0 +1,16 @@
+//go:build !nonetstack
+
+package main
+
+import (
+	"github.com/gologme/log"
+
+	"github.com/RiV-chain/RiV-mesh/src/core"
+	"github.com/RiV-chain/RiV-mesh/src/netstack"
+)
+
+// newUserspaceStack starts a userspace TCP/IP stack on the node, for when
+// there is no TUN adapter.
+func newUserspaceStack(c *core.Core, logger *log.Logger, mtu uint64) (userspaceStack, error) {
+	return netstack.New(c, logger, netstack.MTU(mtu))
+}
@@ -0,0 +1,17 @@
+//go:build nonetstack
+
+package main
+
+import (
+	"errors"
+
+	"github.com/gologme/log"
+
+	"github.com/RiV-chain/RiV-mesh/src/core"
+)
+
+// newUserspaceStack fails, as this build leaves out the userspace TCP/IP
+// stack, so that the node only reaches the mesh through TUN.
+func newUserspaceStack(c *core.Core, logger *log.Logger, mtu uint64) (userspaceStack, error) {
+	return nil, errors.New("built without a userspace TCP/IP stack, set IfName to use TUN")
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"reflect"
+	"regexp"
+
+	"github.com/RiV-chain/RiV-mesh/src/config"
+	"github.com/RiV-chain/RiV-mesh/src/core"
+	"github.com/RiV-chain/RiV-mesh/src/defaults"
+	"github.com/RiV-chain/RiV-mesh/src/forward"
+	"github.com/RiV-chain/RiV-mesh/src/multicast"
+)
+
+// reload re-reads the configuration file and applies whatever has changed to
+// the running node. Peers, listeners, forwards and the allowed and denied keys
+// are compared against the node itself, so that changes made through the REST
+// API without saving them are also brought back in line with the file. It
+// returns the names of the settings that were changed, and of those that
+// differ but only take effect after a restart.
+func (n *node) reload() (applied, restart []string, err error) {
+	n.reloadMutex.Lock()
+	defer n.reloadMutex.Unlock()
+	if n.configFile == "" {
+		return nil, nil, errors.New("configuration was not read from a file")
+	}
+	cfg, err := defaults.ReadConfig(n.configFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("configuration file load error: %w", err)
+	}
+	// Check everything that might not parse before changing anything.
+	intfs, err := multicastInterfacesFor(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	allowed, err := keySet(cfg.AllowedPublicKeys)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid AllowedPublicKeys: %w", err)
+	}
+	denied, err := keySet(cfg.DeniedPublicKeys)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid DeniedPublicKeys: %w", err)
+	}
+	networks := map[string]struct{}{}
+	for _, network := range cfg.DeniedNetworks {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid DeniedNetworks: %w", err)
+		}
+		networks[prefix.Masked().String()] = struct{}{}
+	}
+
+	var errs []error
+	apply := func(setting string, changed bool, err error) {
+		if changed {
+			applied = append(applied, setting)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", setting, err))
+		}
+	}
+	changed, err := n.reloadPeers(cfg)
+	apply("Peers", changed, err)
+	changed, err = n.reloadListeners(cfg)
+	apply("Listen", changed, err)
+	changed, err = n.reloadAllowedKeys(allowed)
+	apply("AllowedPublicKeys", changed, err)
+	changed, err = n.reloadDeniedKeys(denied)
+	apply("DeniedPublicKeys", changed, err)
+	changed, err = n.reloadDeniedNetworks(networks)
+	apply("DeniedNetworks", changed, err)
+	changed, err = n.forwarder.SetForwards(forwardsFor(cfg))
+	apply("Forwards", changed, err)
+	if !reflect.DeepEqual(n.config.NodeInfo, cfg.NodeInfo) {
+		apply("NodeInfo", true, n.core.SetThisNodeInfo(core.NodeInfo(cfg.NodeInfo)))
+	}
+	if !reflect.DeepEqual(n.config.MulticastInterfaces, cfg.MulticastInterfaces) {
+		apply("MulticastInterfaces", true, n.multicast.SetInterfaces(intfs))
+	}
+	if n.config.LogLevel != cfg.LogLevel {
+		loglevel := cfg.LogLevel
+		if loglevel == "" {
+			loglevel = n.logLevel
+		}
+		setLogLevel(loglevel, n.logger)
+		apply("LogLevel", true, nil)
+	}
+
+	for _, setting := range []struct {
+		name     string
+		old, new any
+	}{
+		{"PrivateKey", n.config.PrivateKey, cfg.PrivateKey},
+		{"NetworkDomain", n.config.NetworkDomain, cfg.NetworkDomain},
+		{"IfName", n.config.IfName, cfg.IfName},
+		{"IfMTU", n.config.IfMTU, cfg.IfMTU},
+		{"NodeInfoPrivacy", n.config.NodeInfoPrivacy, cfg.NodeInfoPrivacy},
+		{"AdminListen", n.config.AdminListen, cfg.AdminListen},
+		{"HttpAddress", n.config.HttpAddress, cfg.HttpAddress},
+		{"WwwRoot", n.config.WwwRoot, cfg.WwwRoot},
+		{"PeerReconnect", n.config.PeerReconnect, cfg.PeerReconnect},
+		{"LinkTimeout", n.config.LinkTimeout, cfg.LinkTimeout},
+		{"FeaturesConfig", n.config.FeaturesConfig, cfg.FeaturesConfig},
+		{"ProxyListen", n.config.ProxyListen, cfg.ProxyListen},
+		{"DNSListen", n.config.DNSListen, cfg.DNSListen},
+		{"DNSDomain", n.config.DNSDomain, cfg.DNSDomain},
+	} {
+		if !reflect.DeepEqual(setting.old, setting.new) {
+			restart = append(restart, setting.name)
+		}
+	}
+
+	// The settings applied above are copied over, so that the config matches
+	// what the node is running with. Those needing a restart are left alone,
+	// so that they are still reported until there is one.
+	n.config.Peers = cfg.Peers
+	n.config.InterfacePeers = cfg.InterfacePeers
+	n.config.Listen = cfg.Listen
+	n.config.AllowedPublicKeys = cfg.AllowedPublicKeys
+	n.config.DeniedPublicKeys = cfg.DeniedPublicKeys
+	n.config.DeniedNetworks = cfg.DeniedNetworks
+	n.config.Forwards = cfg.Forwards
+	n.config.NodeInfo = cfg.NodeInfo
+	n.config.MulticastInterfaces = cfg.MulticastInterfaces
+	n.config.LogLevel = cfg.LogLevel
+	if len(applied) > 0 {
+		n.logger.Infoln("Configuration reloaded, changed:", applied)
+	} else {
+		n.logger.Infoln("Configuration reloaded, nothing to change")
+	}
+	if len(restart) > 0 {
+		n.logger.Warnln("Configuration changes that need a restart:", restart)
+	}
+	return applied, restart, errors.Join(errs...)
+}
+
+func (n *node) reloadPeers(cfg *config.NodeConfig) (changed bool, err error) {
+	wanted := map[core.Peer]struct{}{}
+	for _, peer := range cfg.Peers {
+		wanted[core.Peer{URI: peer}] = struct{}{}
+	}
+	for intf, peers := range cfg.InterfacePeers {
+		for _, peer := range peers {
+			wanted[core.Peer{URI: peer, SourceInterface: intf}] = struct{}{}
+		}
+	}
+	var errs []error
+	for _, info := range n.core.GetConfiguredPeers() {
+		peer := core.Peer{URI: info.URI, SourceInterface: info.SourceInterface}
+		if _, ok := wanted[peer]; ok {
+			delete(wanted, peer)
+			continue
+		}
+		changed = true
+		errs = append(errs, n.core.RemovePeer(peer.URI, peer.SourceInterface))
+	}
+	for peer := range wanted {
+		changed = true
+		errs = append(errs, n.core.AddPeer(peer.URI, peer.SourceInterface))
+	}
+	return changed, errors.Join(errs...)
+}
+
+func (n *node) reloadListeners(cfg *config.NodeConfig) (changed bool, err error) {
+	wanted := map[string]struct{}{}
+	for _, listener := range cfg.Listen {
+		wanted[listener] = struct{}{}
+	}
+	var errs []error
+	for _, info := range n.core.GetListeners() {
+		if _, ok := wanted[info.URI]; ok {
+			delete(wanted, info.URI)
+			continue
+		}
+		changed = true
+		errs = append(errs, n.core.RemoveListener(info.URI))
+	}
+	for listener := range wanted {
+		changed = true
+		errs = append(errs, n.core.AddListener(listener))
+	}
+	return changed, errors.Join(errs...)
+}
+
+func (n *node) reloadAllowedKeys(wanted map[string]struct{}) (changed bool, err error) {
+	var errs []error
+	for _, key := range n.core.GetAllowedPublicKeys() {
+		if _, ok := wanted[hex.EncodeToString(key)]; ok {
+			delete(wanted, hex.EncodeToString(key))
+			continue
+		}
+		changed = true
+		errs = append(errs, n.core.RemoveAllowedPublicKey(key, true))
+	}
+	for key := range wanted {
+		changed = true
+		k, _ := hex.DecodeString(key)
+		errs = append(errs, n.core.AddAllowedPublicKey(k))
+	}
+	return changed, errors.Join(errs...)
+}
+
+func (n *node) reloadDeniedKeys(wanted map[string]struct{}) (changed bool, err error) {
+	var errs []error
+	for _, entry := range n.core.GetDenylist() {
+		if entry.Key == nil {
+			continue
+		}
+		if _, ok := wanted[hex.EncodeToString(entry.Key)]; ok {
+			delete(wanted, hex.EncodeToString(entry.Key))
+			continue
+		}
+		changed = true
+		errs = append(errs, n.core.RemoveDeniedPublicKey(entry.Key))
+	}
+	for key := range wanted {
+		changed = true
+		k, _ := hex.DecodeString(key)
+		errs = append(errs, n.core.AddDeniedPublicKey(k))
+	}
+	return changed, errors.Join(errs...)
+}
+
+func (n *node) reloadDeniedNetworks(wanted map[string]struct{}) (changed bool, err error) {
+	var errs []error
+	for _, entry := range n.core.GetDenylist() {
+		if entry.Key != nil {
+			continue
+		}
+		if _, ok := wanted[entry.Network]; ok {
+			delete(wanted, entry.Network)
+			continue
+		}
+		changed = true
+		errs = append(errs, n.core.RemoveDeniedNetwork(entry.Network))
+	}
+	for network := range wanted {
+		changed = true
+		errs = append(errs, n.core.AddDeniedNetwork(network))
+	}
+	return changed, errors.Join(errs...)
+}
+
+// keySet decodes hex public keys, returning them re-encoded so that they can
+// be compared with the keys reported by the node.
+func keySet(keys []string) (map[string]struct{}, error) {
+	set := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		k, err := hex.DecodeString(key)
+		if err != nil {
+			return nil, err
+		}
+		set[hex.EncodeToString(k)] = struct{}{}
+	}
+	return set, nil
+}
+
+func multicastInterfacesFor(cfg *config.NodeConfig) ([]multicast.MulticastInterface, error) {
+	intfs := make([]multicast.MulticastInterface, 0, len(cfg.MulticastInterfaces))
+	for _, intf := range cfg.MulticastInterfaces {
+		regex, err := regexp.Compile(intf.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MulticastInterfaces regex: %w", err)
+		}
+		intfs = append(intfs, multicast.MulticastInterface{
+			Regex:    regex,
+			Beacon:   intf.Beacon,
+			Listen:   intf.Listen,
+			Port:     intf.Port,
+			Priority: uint8(intf.Priority),
+		})
+	}
+	return intfs, nil
+}
+
+func forwardsFor(cfg *config.NodeConfig) []forward.Forward {
+	forwards := make([]forward.Forward, 0, len(cfg.Forwards))
+	for _, fw := range cfg.Forwards {
+		forwards = append(forwards, forward.Forward{
+			Protocol: fw.Protocol,
+			Local:    fw.Local,
+			Mesh:     fw.Mesh,
+			Reverse:  fw.Reverse,
+		})
+	}
+	return forwards
+}
@@ -34,12 +34,16 @@ func (cmdLineEnv *CmdLineEnv) parseFlagsAndArgs() {
 		fmt.Println("Please note that options must always specified BEFORE the command\non the command line or they will be ignored.")
 		fmt.Println()
 		fmt.Println("Commands:\n  - Use \"list\" for a list of available commands")
+		fmt.Println("  - Use \"crawl\" to map the whole reachable mesh, with options format=json|dot|gexf,\n    concurrency=16, rate=30 and depth=0 (no limit)")
+		fmt.Println("  - Use \"ping <key|address>\" to ping a node through the mesh, with options count=4 and size=56")
 		fmt.Println()
 		fmt.Println("Examples:")
 		fmt.Println("  - ", os.Args[0], "list")
 		fmt.Println("  - ", os.Args[0], "peers")
 		fmt.Println("  - ", os.Args[0], "-v self")
 		fmt.Println("  - ", os.Args[0], "-endpoint=http://localhost:19019 DHT")
+		fmt.Println("  - ", os.Args[0], "crawl format=dot depth=3 > mesh.dot")
+		fmt.Println("  - ", os.Args[0], "ping fc00::1 count=10")
 	}
 
 	server := flag.String("endpoint", cmdLineEnv.endpoint, "Admin socket endpoint")
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+)
+
+// crawlProgress is the data of the progress events from /api/crawl/events.
+type crawlProgress struct {
+	Depth  int `json:"depth"`
+	Found  int `json:"found"`
+	Asked  int `json:"asked"`
+	Failed int `json:"failed"`
+	Queued int `json:"queued"`
+	Edges  int `json:"edges"`
+}
+
+// crawl maps the mesh through the node, or follows the crawl that it is
+// already running, showing the progress on stderr and then printing the map.
+// The arguments are key=value pairs: format is json, dot or gexf, and
+// concurrency, rate and depth are passed on to the node. Interrupting it
+// cancels the crawl and prints what was found until then.
+func crawl(endpoint string, args []string) error {
+	format := "json"
+	options := map[string]int{}
+	for _, arg := range args {
+		key, value, _ := strings.Cut(arg, "=")
+		switch key {
+		case "format":
+			format = value
+		case "concurrency", "rate", "depth":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q", key, value)
+			}
+			options[key] = n
+		default:
+			return fmt.Errorf("unknown crawl option %q", arg)
+		}
+	}
+
+	body, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+	response, err := http.Post(endpoint+"/api/crawl", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	_ = response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusAccepted:
+	case http.StatusConflict:
+		fmt.Fprintln(os.Stderr, "A crawl is already running, following it")
+	default:
+		return fmt.Errorf("failed to start crawl: %s", response.Status)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	defer signal.Stop(sigCh)
+	go func() {
+		<-sigCh
+		fmt.Fprintln(os.Stderr, "\nCancelling crawl")
+		if req, err := http.NewRequest(http.MethodDelete, endpoint+"/api/crawl", nil); err == nil {
+			if response, err := http.DefaultClient.Do(req); err == nil {
+				_ = response.Body.Close()
+			}
+		}
+	}()
+
+	response, err = http.Get(endpoint + "/api/crawl/events")
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var p crawlProgress
+		if err := json.Unmarshal([]byte(data), &p); err == nil {
+			fmt.Fprintf(os.Stderr, "\rDepth %d: %d nodes found, %d asked, %d not answering, %d queued, %d peerings",
+				p.Depth, p.Found, p.Asked, p.Failed, p.Queued, p.Edges)
+		}
+	}
+	_ = response.Body.Close()
+	fmt.Fprintln(os.Stderr)
+
+	response, err = http.Get(endpoint + "/api/crawl?fmt=" + format)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		result, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("failed to get map: %s", strings.TrimSpace(string(result)))
+	}
+	_, err = io.Copy(os.Stdout, response.Body)
+	return err
+}
@@ -50,7 +50,15 @@ func run() int {
 
 	u, err := url.Parse(cmdLineEnv.endpoint)
 
-	if err == nil {
+	if err == nil && cmdLineEnv.args[0] == "crawl" {
+		if err := crawl(u.String(), cmdLineEnv.args[1:]); err != nil {
+			panic(err)
+		}
+	} else if err == nil && cmdLineEnv.args[0] == "ping" {
+		if err := ping(u.String(), cmdLineEnv.args[1:], cmdLineEnv.injson); err != nil {
+			panic(err)
+		}
+	} else if err == nil {
 		var response *http.Response
 		var err error
 		if cmdLineEnv.injson {
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// pingResult is the response from /api/ping/{key}, with times in
+// milliseconds and loss as a percentage.
+type pingResult struct {
+	Key      string  `json:"key"`
+	Address  string  `json:"address"`
+	Size     int     `json:"size"`
+	Sent     int     `json:"sent"`
+	Received int     `json:"received"`
+	Loss     float64 `json:"loss"`
+	RTTMin   float64 `json:"rtt_min"`
+	RTTAvg   float64 `json:"rtt_avg"`
+	RTTMax   float64 `json:"rtt_max"`
+	Probes   []struct {
+		Seq  uint32  `json:"seq"`
+		RTT  float64 `json:"rtt"`
+		Lost bool    `json:"lost"`
+	} `json:"probes"`
+}
+
+// ping pings a node through the mesh by its key or address, and prints the
+// round trip times like ping does, or the response as it is with -json. The
+// other arguments are key=value pairs: count and size are passed on to the
+// node.
+func ping(endpoint string, args []string, injson bool) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no public key or address to ping")
+	}
+	query := url.Values{}
+	for _, arg := range args[1:] {
+		key, value, _ := strings.Cut(arg, "=")
+		switch key {
+		case "count", "size":
+			if _, err := strconv.Atoi(value); err != nil {
+				return fmt.Errorf("invalid %s %q", key, value)
+			}
+			query.Set(key, value)
+		default:
+			return fmt.Errorf("unknown ping option %q", arg)
+		}
+	}
+	response, err := http.Get(endpoint + "/api/ping/" + url.PathEscape(args[0]) + "?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to ping: %s", strings.TrimSpace(string(body)))
+	}
+	if injson {
+		fmt.Println(string(body))
+		return nil
+	}
+	var result pingResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return err
+	}
+	fmt.Printf("PING %s (%s): %d bytes\n", result.Key, result.Address, result.Size)
+	for _, probe := range result.Probes {
+		if probe.Lost {
+			fmt.Printf("seq=%d lost\n", probe.Seq)
+		} else {
+			fmt.Printf("seq=%d time=%.3f ms\n", probe.Seq, probe.RTT)
+		}
+	}
+	fmt.Println("---", result.Address, "ping statistics ---")
+	fmt.Printf("%d probes sent, %d received, %.1f%% loss\n", result.Sent, result.Received, result.Loss)
+	if result.Received > 0 {
+		fmt.Printf("rtt min/avg/max = %.3f/%.3f/%.3f ms\n", result.RTTMin, result.RTTAvg, result.RTTMax)
+	}
+	return nil
+}
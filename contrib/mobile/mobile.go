@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"net/netip"
 	"regexp"
+	"time"
 
 	"github.com/gologme/log"
 
@@ -76,6 +78,36 @@ func (m *Mesh) StartJSON(configjson []byte) error {
 			}
 			options = append(options, core.AllowedPublicKey(k[:]))
 		}
+		for _, denied := range m.config.DeniedPublicKeys {
+			k, err := hex.DecodeString(denied)
+			if err != nil {
+				panic(err)
+			}
+			options = append(options, core.DeniedPublicKey(k[:]))
+		}
+		for _, denied := range m.config.DeniedNetworks {
+			prefix, err := netip.ParsePrefix(denied)
+			if err != nil {
+				panic(err)
+			}
+			options = append(options, core.DeniedNetwork(prefix))
+		}
+		minInterval, maxInterval, err := m.config.PeerReconnect.Intervals()
+		if err != nil {
+			panic(err)
+		}
+		options = append(options, core.PeerReconnect{
+			MinInterval: minInterval,
+			MaxInterval: maxInterval,
+			Jitter:      m.config.PeerReconnect.Jitter,
+		})
+		if m.config.LinkTimeout != "" {
+			timeout, err := time.ParseDuration(m.config.LinkTimeout)
+			if err != nil {
+				panic(err)
+			}
+			options = append(options, core.LinkTimeout(timeout))
+		}
 		m.core, err = core.New(sk[:], logger, options...)
 		if err != nil {
 			panic(err)
@@ -19,6 +19,8 @@ package config
 import (
 	"crypto/ed25519"
 	"encoding/hex"
+	"fmt"
+	"time"
 )
 
 // NodeConfig is the main configuration structure, containing configuration
@@ -33,15 +35,24 @@ type NodeConfig struct {
 	WwwRoot             string                     `comment:"Points out to embedded webserver root folder path where web interface assets are located.\nExample:/apps/mesh/www."`
 	MulticastInterfaces []MulticastInterfaceConfig `comment:"Configuration for which interfaces multicast peer discovery should be\nenabled on. Each entry in the list should be a json object which may\ncontain Regex, Beacon, Listen, and Port. Regex is a regular expression\nwhich is matched against an interface name, and interfaces use the\nfirst configuration that they match gainst. Beacon configures whether\nor not the node should send link-local multicast beacons to advertise\ntheir presence, while listening for incoming connections on Port.\nListen controls whether or not the node listens for multicast beacons\nand opens outgoing connections."`
 	AllowedPublicKeys   []string                   `comment:"List of peer public keys to allow incoming peering connections\nfrom. If left empty/undefined then all connections will be allowed\nby default. This does not affect outgoing peerings, nor does it\naffect link-local peers discovered via multicast."`
+	DeniedPublicKeys    []string                   `comment:"List of peer public keys to refuse incoming peering connections from,\nwhether or not they are in AllowedPublicKeys. Existing links from\na key are closed when it is denied at runtime."`
+	DeniedNetworks      []string                   `comment:"List of networks in CIDR notation, e.g. 192.0.2.0/24, to refuse\nincoming connections from. These are dropped as soon as they are\naccepted, before any TLS or handshake work is done."`
 	PublicKey           string                     `comment:"Your public key. Your peers may ask you for this to put\ninto their AllowedPublicKeys configuration."`
 	PrivateKey          string                     `comment:"Your private key. DO NOT share this with anyone!"`
-	IfName              string                     `comment:"Local network interface name for TUN adapter, or \"auto\" to select\nan interface automatically, or \"none\" to run without TUN."`
+	IfName              string                     `comment:"Local network interface name for TUN adapter, or \"auto\" to select\nan interface automatically, or \"none\" to run without TUN. Without\nTUN, a userspace TCP/IP stack is used for ProxyListen and Forwards,\nas it is when TUN can't be started and either of them is set."`
 	IfMTU               uint64                     `comment:"Maximum Transmission Unit (MTU) size for your local TUN interface.\nDefault is the largest supported size for your platform. The lowest\npossible value is 1280."`
 	NodeInfoPrivacy     bool                       `comment:"By default, nodeinfo contains some defaults including the platform,\narchitecture and RiV-mesh version. These can help when surveying\nthe network and diagnosing network routing problems. Enabling\nnodeinfo privacy prevents this, so that only items specified in\n\"NodeInfo\" are sent back if specified."`
 	NodeInfo            map[string]interface{}     `comment:"Optional node info. This must be a { \"key\": \"value\", ... } map\nor set as null. This is entirely optional but, if set, is visible\nto the whole network on request."`
 	NetworkDomain       NetworkDomainConfig        `comment:"Address prefix used by mesh.\nThe current implementation requires this to be a multiple of 8 bits + 7 bits.4\nNodes that configure this differently will be unable to communicate with each other using IP packets."`
 	PublicPeersUrl      string                     `comment:"Public peers URL which contains all peers in JSON format grouped by a country."`
 	FeaturesConfig      map[string]interface{}     `comment:"Optional features config. This must be a { \"key\": \"value\", ... } map\not set as null. This is mandatory for extended featured builds containing features specific settings."`
+	PeerReconnect       PeerReconnectConfig        `comment:"How often configured peers are redialled after a dial fails or their\nconnection drops. The interval starts at MinInterval and doubles after\neach failure up to MaxInterval, and is reset once the peer connects.\nJitter randomises each wait by up to that fraction of the interval.\nThese can be overridden per peer with the backoff_min, backoff_max\nand backoff_jitter URI options, e.g. tls://a.b.c.d:e?backoff_max=10m."`
+	LinkTimeout         string                     `comment:"How long a peering can go without receiving anything before it is\nclosed, e.g. \"30s\". Peerings are pinged often enough that this only\nhappens when the remote side has gone away without closing the link."`
+	ProxyListen         []string                   `comment:"Listen addresses for a local SOCKS5 or HTTP proxy into the mesh, e.g.\nsocks5://127.0.0.1:1080 or http://127.0.0.1:8080. Mesh addresses and\nnames of the form <public key>.<DNSDomain> can be reached through it,\nbut nothing outside of the mesh."`
+	Forwards            []ForwardConfig            `comment:"Ports forwarded between this machine and the mesh, which work with or\nwithout a TUN adapter. Each entry is a json object containing Protocol,\neither tcp or udp, Local, a host:port on this machine, and Mesh, an\n[address]:port in the mesh. Connections to Local are forwarded to\nMesh, unless Reverse is true, in which case connections to the port\nin Mesh on this node's own address are forwarded to Local, and the\naddress can be left out, e.g. { Local: \"127.0.0.1:22\", Mesh: \":22\",\nReverse: true }."`
+	DNSListen           []string                   `comment:"Listen addresses for a DNS server that answers for names in the mesh,\nover UDP, e.g. 127.0.0.1:53. To listen on the TUN address use '<tun>'\nas the host, e.g. [<tun>]:53. It answers AAAA queries for the names\n<public key>.<DNSDomain>, where the key can be split into two halves,\nand <name>.<DNSDomain> for the \"name\" in a known node's NodeInfo,\nand PTR queries for mesh addresses."`
+	DNSDomain           string                     `comment:"Domain of the names answered by the DNS server and the proxy."`
+	LogLevel            string                     `comment:"Log level, one of error, warn, info, debug or trace. If set, this\noverrides the -loglevel option, and unlike it can be changed by\nreloading the configuration."`
 }
 
 type MulticastInterfaceConfig struct {
@@ -52,10 +63,39 @@ type MulticastInterfaceConfig struct {
 	Priority uint64 // really uint8, but gobind won't export it
 }
 
+type ForwardConfig struct {
+	Protocol string // tcp or udp, tcp if empty
+	Local    string // host:port on this machine
+	Mesh     string // [address]:port in the mesh
+	Reverse  bool   // forward from Mesh, on this node, to Local
+}
+
 type NetworkDomainConfig struct {
 	Prefix string
 }
 
+type PeerReconnectConfig struct {
+	MinInterval string // duration, e.g. "1s"
+	MaxInterval string // duration, e.g. "1m"
+	Jitter      float64
+}
+
+// Intervals parses the minimum and maximum reconnect intervals. Empty values
+// are returned as zero, so that the core uses its defaults for them.
+func (c *PeerReconnectConfig) Intervals() (min, max time.Duration, err error) {
+	if c.MinInterval != "" {
+		if min, err = time.ParseDuration(c.MinInterval); err != nil {
+			return 0, 0, fmt.Errorf("invalid MinInterval: %w", err)
+		}
+	}
+	if c.MaxInterval != "" {
+		if max, err = time.ParseDuration(c.MaxInterval); err != nil {
+			return 0, 0, fmt.Errorf("invalid MaxInterval: %w", err)
+		}
+	}
+	return min, max, nil
+}
+
 // NewSigningKeys replaces the signing keypair in the NodeConfig with a new
 // signing keypair. The signing keys are used by the switch to derive the
 // structure of the spanning tree.
@@ -11,6 +11,7 @@ import (
 	//"errors"
 	//"fmt"
 	"net"
+	"net/netip"
 	"net/url"
 
 	//"sort"
@@ -28,16 +29,45 @@ type SelfInfo struct {
 }
 
 type PeerInfo struct {
-	Key      ed25519.PublicKey
-	Root     ed25519.PublicKey
-	Coords   []uint64
-	Port     uint64
-	Priority uint8
-	Remote   string
-	RXBytes  uint64
-	TXBytes  uint64
-	Uptime   time.Duration
-	RemoteIp string
+	Key         ed25519.PublicKey
+	Root        ed25519.PublicKey
+	Coords      []uint64
+	Port        uint64
+	Priority    uint8
+	Remote      string
+	RXBytes     uint64 // payload bytes, after decompression
+	TXBytes     uint64 // payload bytes, before compression
+	RXWireBytes uint64
+	TXWireBytes uint64
+	Compression string
+	RXRate      uint64        // current bytes per second, after decompression
+	TXRate      uint64        // current bytes per second, before compression
+	Throttled   time.Duration // time spent waiting for rate limits, in both directions
+	RTT         time.Duration // latest link round trip time
+	RTTMin      time.Duration
+	RTTAvg      time.Duration
+	LastSeen    time.Time // when anything was last received over the link
+	Uptime      time.Duration
+	RemoteIp    string
+}
+
+type ConfiguredPeerInfo struct {
+	URI             string
+	SourceInterface string
+	State           string // one of the PeerState constants
+	LastError       string
+	LastAttempt     time.Time
+	Attempts        uint64    // since the peer was last connected
+	NextRetry       time.Time // zero unless backing off
+}
+
+type ListenerInfo struct {
+	URI        string
+	Scheme     string
+	Address    string // the bound address, empty if the listener failed to start
+	Links      uint64 // inbound links, including those still handshaking
+	Handshakes uint64 // handshakes in progress
+	Rejected   uint64 // connections closed for being over the listener's limits
 }
 
 type DHTEntryInfo struct {
@@ -102,6 +132,14 @@ func (c *Core) GetPeers() []PeerInfo {
 		if linkconn, ok := p.Conn.(*linkConn); ok {
 			info.RXBytes = atomic.LoadUint64(&linkconn.rx)
 			info.TXBytes = atomic.LoadUint64(&linkconn.tx)
+			info.RXWireBytes = atomic.LoadUint64(&linkconn.rxWire)
+			info.TXWireBytes = atomic.LoadUint64(&linkconn.txWire)
+			info.Compression = linkCompressionName(linkconn.compression)
+			info.RXRate, info.TXRate = linkconn.meter.sample(info.RXBytes, info.TXBytes)
+			info.Throttled = linkconn.limitUp.throttledFor() + linkconn.limitDown.throttledFor()
+			if linkconn.frames != nil {
+				info.RTT, info.RTTMin, info.RTTAvg, info.LastSeen = linkconn.frames.stats()
+			}
 			info.Uptime = time.Since(linkconn.up)
 		}
 		peers = append(peers, info)
@@ -109,6 +147,31 @@ func (c *Core) GetPeers() []PeerInfo {
 	return peers
 }
 
+// GetConfiguredPeers returns the state of every peer in the peers list,
+// including those that aren't currently connected.
+func (c *Core) GetConfiguredPeers() []ConfiguredPeerInfo {
+	var peers []ConfiguredPeerInfo
+	phony.Block(c, func() {
+		for peer, state := range c.config._peers {
+			info := ConfiguredPeerInfo{
+				URI:             peer.URI,
+				SourceInterface: peer.SourceInterface,
+			}
+			if state != nil {
+				info.State = state.state
+				info.LastAttempt = state.lastAttempt
+				info.Attempts = state.attempts
+				info.NextRetry = state.nextRetry
+				if state.lastError != nil {
+					info.LastError = state.lastError.Error()
+				}
+			}
+			peers = append(peers, info)
+		}
+	})
+	return peers
+}
+
 func (c *Core) GetDHT() []DHTEntryInfo {
 	var dhts []DHTEntryInfo
 	ds := c.PacketConn.PacketConn.Debug.GetDHT()
@@ -148,20 +211,205 @@ func (c *Core) GetSessions() []SessionInfo {
 	return sessions
 }
 
-// Listen starts a new listener (either TCP or TLS). The input should be a url.URL
-// parsed from a string of the form e.g. "tcp://a.b.c.d:e". In the case of a
-// link-local address, the interface should be provided as the second argument.
+// Listen starts a new listener for any link type, including those added with
+// RegisterTransport. The input should be a url.URL parsed from a string of the
+// form e.g. "tcp://a.b.c.d:e". In the case of a link-local address, the
+// interface should be provided as the second argument.
 func (c *Core) Listen(u *url.URL, sintf string) (*Listener, error) {
-	switch u.Scheme {
-	case "tcp":
-		return c.links.tcp.listen(u, sintf)
-	case "tls":
-		return c.links.tls.listen(u, sintf)
-	case "unix":
-		return c.links.unix.listen(u, sintf)
-	default:
-		return nil, fmt.Errorf("unrecognised scheme %q", u.Scheme)
+	return c.links.listen(u, sintf)
+}
+
+// AddListener starts a new listener from a URI of the form e.g.
+// "tls://a.b.c.d:e" and adds it to the configured listeners, so that it is
+// reported by GetListeners and can later be removed with RemoveListener.
+func (c *Core) AddListener(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
 	}
+	phony.Block(c, func() {
+		if _, known := c.config._listeners[ListenAddress(uri)]; known {
+			err = fmt.Errorf("listener already configured")
+			return
+		}
+		var listener *Listener
+		if listener, err = c.links.listen(u, ""); err != nil {
+			return
+		}
+		c.config._listeners[ListenAddress(uri)] = listener
+	})
+	return err
+}
+
+// RemoveListener stops a configured listener. Links that were accepted by the
+// listener are left up.
+func (c *Core) RemoveListener(uri string) error {
+	var listener *Listener
+	var err error
+	phony.Block(c, func() {
+		var known bool
+		if listener, known = c.config._listeners[ListenAddress(uri)]; !known {
+			err = fmt.Errorf("listener not configured")
+			return
+		}
+		delete(c.config._listeners, ListenAddress(uri))
+	})
+	if listener != nil {
+		// Closing waits for the accept loop to finish, so don't hold up the
+		// core actor while it does.
+		_ = listener.Close()
+	}
+	return err
+}
+
+// GetListeners returns the configured listeners, including any that failed
+// to start, along with the address that each is bound to.
+func (c *Core) GetListeners() []ListenerInfo {
+	var listeners []ListenerInfo
+	phony.Block(c, func() {
+		for uri, listener := range c.config._listeners {
+			info := ListenerInfo{
+				URI: string(uri),
+			}
+			if u, err := url.Parse(string(uri)); err == nil {
+				info.Scheme = u.Scheme
+			}
+			if listener != nil {
+				info.Address = listener.Addr().String()
+				info.Links, info.Handshakes, info.Rejected = listener.limits.stats()
+			}
+			listeners = append(listeners, info)
+		}
+	})
+	return listeners
+}
+
+// AddAllowedPublicKey allows incoming peerings from the given key. As long as
+// at least one key is allowed, incoming peerings from any other keys are
+// refused, with the exception of link-local peers discovered via multicast.
+func (c *Core) AddAllowedPublicKey(key ed25519.PublicKey) error {
+	if len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("public key is incorrect length")
+	}
+	var err error
+	phony.Block(c, func() {
+		var k [32]byte
+		copy(k[:], key)
+		if _, known := c.config._allowedPublicKeys[k]; known {
+			err = fmt.Errorf("public key already allowed")
+			return
+		}
+		c.config._allowedPublicKeys[k] = struct{}{}
+	})
+	return err
+}
+
+// RemoveAllowedPublicKey stops allowing incoming peerings from the given key.
+// If disconnect is set then any inbound links from the key are closed, unless
+// removing it left no allowed keys, in which case all keys are allowed again.
+func (c *Core) RemoveAllowedPublicKey(key ed25519.PublicKey, disconnect bool) error {
+	if len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("public key is incorrect length")
+	}
+	var k [32]byte
+	copy(k[:], key)
+	var err error
+	var stillAllowed bool
+	phony.Block(c, func() {
+		if _, known := c.config._allowedPublicKeys[k]; !known {
+			err = fmt.Errorf("public key not allowed")
+			return
+		}
+		delete(c.config._allowedPublicKeys, k)
+		stillAllowed = len(c.config._allowedPublicKeys) == 0
+	})
+	if err != nil || !disconnect || stillAllowed {
+		return err
+	}
+	c.closeInboundLinks(func(link *link) bool {
+		return !link.force && link.key == k
+	})
+	return nil
+}
+
+// GetAllowedPublicKeys returns the keys that incoming peerings are allowed
+// from. An empty list means that all keys are allowed.
+func (c *Core) GetAllowedPublicKeys() []ed25519.PublicKey {
+	var keys []ed25519.PublicKey
+	phony.Block(c, func() {
+		for k := range c.config._allowedPublicKeys {
+			key := make(ed25519.PublicKey, ed25519.PublicKeySize)
+			copy(key, k[:])
+			keys = append(keys, key)
+		}
+	})
+	return keys
+}
+
+// AddDeniedPublicKey refuses inbound links from the given key, even if it is
+// an allowed key, and closes any inbound links from it that are already up.
+func (c *Core) AddDeniedPublicKey(key ed25519.PublicKey) error {
+	if err := c.denylist.addKey(key); err != nil {
+		return err
+	}
+	var k keyArray
+	copy(k[:], key)
+	c.closeInboundLinks(func(link *link) bool {
+		return link.key == k
+	})
+	return nil
+}
+
+// RemoveDeniedPublicKey stops refusing inbound links from the given key.
+func (c *Core) RemoveDeniedPublicKey(key ed25519.PublicKey) error {
+	return c.denylist.removeKey(key)
+}
+
+// AddDeniedNetwork refuses inbound connections from any address in the given
+// CIDR, e.g. "192.0.2.0/24", and closes any inbound links from it that are
+// already up.
+func (c *Core) AddDeniedNetwork(network string) error {
+	prefix, err := netip.ParsePrefix(network)
+	if err != nil {
+		return err
+	}
+	if err = c.denylist.addNetwork(prefix); err != nil {
+		return err
+	}
+	c.closeInboundLinks(func(link *link) bool {
+		host, _, err := net.SplitHostPort(link.conn.RemoteAddr().String())
+		if err != nil {
+			return false
+		}
+		addr, err := netip.ParseAddr(host)
+		return err == nil && prefix.Masked().Contains(addr.WithZone("").Unmap())
+	})
+	return nil
+}
+
+// RemoveDeniedNetwork stops refusing inbound connections from the given CIDR.
+func (c *Core) RemoveDeniedNetwork(network string) error {
+	prefix, err := netip.ParsePrefix(network)
+	if err != nil {
+		return err
+	}
+	return c.denylist.removeNetwork(prefix)
+}
+
+// GetDenylist returns the denied public keys and networks, along with how
+// many inbound connections each has refused.
+func (c *Core) GetDenylist() []DenylistEntry {
+	return c.denylist.entries()
+}
+
+func (c *Core) closeInboundLinks(match func(link *link) bool) {
+	phony.Block(&c.links, func() {
+		for _, link := range c.links._links {
+			if link != nil && link.incoming && match(link) {
+				_ = link.close()
+			}
+		}
+	})
 }
 
 // Address gets the IPv6 address of the Mesh node. This is always a /128
@@ -196,46 +444,48 @@ func (c *Core) SetLogger(log Logger) {
 // AddPeer adds a peer. This should be specified in the peer URI format, e.g.:
 // 		tcp://a.b.c.d:e
 //		socks://a.b.c.d:e/f.g.h.i:j
+//		http-proxy://a.b.c.d:e/f.g.h.i:j
 // This adds the peer to the peer list, so that they will be called again if the
-// connection drops.
+// connection drops. A URI that could never be dialled, e.g. because of a bad
+// key or option, is refused with an error and isn't added.
 
 func (c *Core) AddPeer(uri string, sourceInterface string) error {
-	var known bool
-	phony.Block(c, func() {
-		_, known = c.config._peers[Peer{uri, sourceInterface}]
-	})
-	if known {
-		return fmt.Errorf("peer already configured")
-	}
-	u, err := url.Parse(uri)
-	if err != nil {
-		return err
-	}
-	info, err := c.links.call(u, sourceInterface, nil)
-	if err != nil {
-		return err
-	}
+	var err error
 	phony.Block(c, func() {
-		c.config._peers[Peer{uri, sourceInterface}] = &info
+		peer := Peer{uri, sourceInterface}
+		if _, known := c.config._peers[peer]; known {
+			err = fmt.Errorf("peer already configured")
+			return
+		}
+		var state *peerState
+		if state, err = c._peerState(peer); err != nil {
+			return
+		}
+		c._connectPeer(peer, state)
 	})
-	return nil
+	return err
 }
 
 func (c *Core) RemovePeer(uri string, sourceInterface string) error {
 	var err error
 	phony.Block(c, func() {
 		peer := Peer{uri, sourceInterface}
-		linkInfo, ok := c.config._peers[peer]
+		state, ok := c.config._peers[peer]
 		if !ok {
 			err = fmt.Errorf("peer not configured")
 			return
 		}
-		if ok && linkInfo != nil {
-			c.links.Act(nil, func() {
-				if link := c.links._links[*linkInfo]; link != nil {
-					_ = link.close()
-				}
-			})
+		if state != nil {
+			if state.timer != nil {
+				state.timer.Stop()
+			}
+			if linkInfo := state.info; linkInfo != nil {
+				c.links.Act(nil, func() {
+					if link := c.links._links[*linkInfo]; link != nil {
+						_ = link.close()
+					}
+				})
+			}
 		}
 		delete(c.config._peers, peer)
 	})
@@ -244,13 +494,18 @@ func (c *Core) RemovePeer(uri string, sourceInterface string) error {
 
 func (c *Core) RemovePeers() error {
 	phony.Block(c, func() {
-		for peer, linkInfo := range c.config._peers {
-			if linkInfo != nil {
-				c.links.Act(nil, func() {
-					if link := c.links._links[*linkInfo]; link != nil {
-						_ = link.close()
-					}
-				})
+		for peer, state := range c.config._peers {
+			if state != nil {
+				if state.timer != nil {
+					state.timer.Stop()
+				}
+				if linkInfo := state.info; linkInfo != nil {
+					c.links.Act(nil, func() {
+						if link := c.links._links[*linkInfo]; link != nil {
+							_ = link.close()
+						}
+					})
+				}
 			}
 			delete(c.config._peers, peer)
 		}
@@ -263,6 +518,7 @@ func (c *Core) RemovePeers() error {
 //
 //	tcp://a.b.c.d:e
 //	socks://a.b.c.d:e/f.g.h.i:j
+//	http-proxy://a.b.c.d:e/f.g.h.i:j
 //
 // This does not add the peer to the peer list, so if the connection drops, the
 // peer will not be called again automatically.
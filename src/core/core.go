@@ -8,6 +8,7 @@ import (
 	"io"
 	"net"
 	"net/url"
+	"sync"
 	"time"
 
 	iwe "github.com/Arceliar/ironwood/encrypted"
@@ -35,14 +36,26 @@ type Core struct {
 	proto              protoHandler
 	log                Logger
 	addPeerTimer       *time.Timer
+	_stopping          bool // set by Shutdown, stops peers from being dialled
+	writes             writeGate
+	traffic            chan packet // from readLoop to ReadFrom
+	trafficBuffers     sync.Pool   // read buffers, returned by ReadFrom
+	readErr            error       // why readLoop stopped, once traffic is closed
+	ports              packetPorts
+	denylist           denylist
+	events             eventBus
 	PeersChangedSignal signals.Signal
 	config             struct {
-		_peers             map[Peer]*linkInfo         // configurable after startup
-		_listeners         map[ListenAddress]struct{} // configurable after startup
-		nodeinfo           NodeInfo                   // configurable after startup
-		nodeinfoPrivacy    NodeInfoPrivacy            // immutable after startup
-		_allowedPublicKeys map[[32]byte]struct{}      // configurable after startup
-		networkdomain      NetworkDomain              // immutable after startup
+		_peers             map[Peer]*peerState         // configurable after startup
+		_listeners         map[ListenAddress]*Listener // configurable after startup
+		nodeinfo           NodeInfo                    // configurable after startup
+		nodeinfoPrivacy    NodeInfoPrivacy             // immutable after startup
+		_allowedPublicKeys map[[32]byte]struct{}       // configurable after startup
+		networkdomain      NetworkDomain               // immutable after startup
+		peerReconnect      PeerReconnect               // immutable after startup
+		linkTimeout        time.Duration               // immutable after startup
+		inboundFilter      InboundFilter               // immutable after startup
+		transports         map[string]LinkTransport    // immutable after startup
 	}
 }
 
@@ -68,12 +81,28 @@ func New(secret ed25519.PrivateKey, logger Logger, opts ...SetupOption) (*Core,
 	if c.PacketConn, err = iwe.NewPacketConn(c.secret); err != nil {
 		return nil, fmt.Errorf("error creating encryption: %w", err)
 	}
-	c.config._peers = map[Peer]*linkInfo{}
-	c.config._listeners = map[ListenAddress]struct{}{}
+	c.traffic = make(chan packet, packetQueueSize)
+	c.trafficBuffers.New = func() any {
+		return make([]byte, c.PacketConn.MTU())
+	}
+	c.config._peers = map[Peer]*peerState{}
+	c.config._listeners = map[ListenAddress]*Listener{}
 	c.config._allowedPublicKeys = map[[32]byte]struct{}{}
+	c.denylist.init()
+	c.config.transports = map[string]LinkTransport{}
+	c.config.peerReconnect = PeerReconnect{
+		MinInterval: defaultPeerReconnectMinInterval,
+		MaxInterval: defaultPeerReconnectMaxInterval,
+		Jitter:      defaultPeerReconnectJitter,
+	}
+	c.config.linkTimeout = defaultLinkTimeout
 	for _, opt := range opts {
 		c._applyOption(opt)
 	}
+	if c.config.linkTimeout <= 0 {
+		c.config.linkTimeout = defaultLinkTimeout
+	}
+	c.config.peerReconnect = c.config.peerReconnect.normalise()
 	if c.log == nil {
 		c.log = log.New(io.Discard, "", 0)
 	}
@@ -90,11 +119,16 @@ func New(secret ed25519.PrivateKey, logger Logger, opts ...SetupOption) (*Core,
 			c.log.Errorf("Invalid listener URI %q specified, ignoring\n", listenaddr)
 			continue
 		}
-		if _, err = c.links.listen(u, ""); err != nil {
+		listener, err := c.links.listen(u, "")
+		if err != nil {
 			c.log.Errorf("Failed to start listener %q: %s\n", listenaddr, err)
+			continue
 		}
+		c.config._listeners[listenaddr] = listener
 	}
 	c.Act(nil, c._addPeerLoop)
+	go c.watchTree()
+	go c.readLoop()
 	return c, nil
 }
 
@@ -110,25 +144,33 @@ func (c *Core) GetThisNodeInfo() json.RawMessage {
 }
 
 // If any static peers were provided in the configuration above then we should
-// configure them. The loop ensures that disconnected peers will eventually
-// be reconnected with.
+// configure them. Disconnected peers are redialled on their own backoff
+// schedule, so the loop only needs to pick up peers that have neither a link
+// nor a retry pending, e.g. because their last dial found a link already up
+// that has since gone down.
 func (c *Core) _addPeerLoop() {
 	select {
 	case <-c.ctx.Done():
 		return
 	default:
 	}
+	if c._stopping {
+		return
+	}
 	// Add peers from the Peers section
 	for peer := range c.config._peers {
-		go func(peer string, intf string) {
-			u, err := url.Parse(peer)
-			if err != nil {
-				c.log.Errorln("Failed to parse peer url:", peer, err)
-			}
-			if err := c.CallPeer(u, intf); err != nil {
-				c.log.Errorln("Failed to add peer:", err)
-			}
-		}(peer.URI, peer.SourceInterface) // TODO: this should be acted and not in a goroutine?
+		state, err := c._peerState(peer)
+		if err != nil {
+			c.log.Errorln("Failed to parse peer url:", peer.URI, err)
+			continue
+		}
+		if state.dialing || state.timer != nil {
+			continue
+		}
+		if state.state == PeerStateConnected && state.info != nil && c.links.isUp(*state.info) {
+			continue
+		}
+		c._connectPeer(peer, state)
 	}
 
 	c.addPeerTimer = time.AfterFunc(time.Minute, func() {
@@ -136,14 +178,25 @@ func (c *Core) _addPeerLoop() {
 	})
 }
 
+// RetryPeersNow dials all configured peers that are not connected straight
+// away, without waiting for their backoff to expire.
 func (c *Core) RetryPeersNow() {
-	if c.addPeerTimer != nil && !c.addPeerTimer.Stop() {
-		<-c.addPeerTimer.C
-	}
-	c.Act(nil, c._addPeerLoop)
+	c.Act(nil, func() {
+		if c.addPeerTimer != nil {
+			c.addPeerTimer.Stop()
+		}
+		for _, state := range c.config._peers {
+			if state != nil && state.timer != nil {
+				state.timer.Stop()
+				state.timer = nil
+			}
+		}
+		c._addPeerLoop()
+	})
 }
 
-// Stop shuts down the Mesh node.
+// Stop shuts down the Mesh node straight away. Use Shutdown to let peers know
+// that we are going.
 func (c *Core) Stop() {
 	phony.Block(c, func() {
 		c.log.Infoln("Stopping...")
@@ -161,6 +214,12 @@ func (c *Core) _close() error {
 		c.addPeerTimer.Stop()
 		c.addPeerTimer = nil
 	}
+	for _, state := range c.config._peers {
+		if state != nil && state.timer != nil {
+			state.timer.Stop()
+			state.timer = nil
+		}
+	}
 	return err
 }
 
@@ -169,41 +228,59 @@ func (c *Core) MTU() uint64 {
 	return c.PacketConn.MTU() - sessionTypeOverhead
 }
 
+// ReadFrom returns the next packet of traffic sent with WriteTo, as used by
+// TUN. Datagrams for ListenPacket are never returned here.
 func (c *Core) ReadFrom(p []byte) (n int, from net.Addr, err error) {
-	buf := make([]byte, c.PacketConn.MTU(), 65535)
+	pkt, ok := <-c.traffic
+	if !ok {
+		return 0, nil, c.readErr
+	}
+	n = copy(p, pkt.data[1:])
+	c.trafficBuffers.Put(pkt.data[:cap(pkt.data)]) // nolint:staticcheck
+	return n, pkt.from, nil
+}
+
+// readLoop reads everything sent to the node, handling protocol packets and
+// passing traffic on to ReadFrom or to the PacketConn for its port. Packets
+// are dropped if whoever should read them isn't keeping up, so that one slow
+// reader can't hold up the others. Traffic is read into buffers from a pool
+// and queued as it is, so that queueing it doesn't copy or allocate.
+func (c *Core) readLoop() {
+	defer c.ports.close()
+	defer close(c.traffic)
+	buf := c.trafficBuffers.Get().([]byte)
 	for {
-		bs := buf
-		n, from, err = c.PacketConn.ReadFrom(bs)
+		n, from, err := c.PacketConn.ReadFrom(buf)
 		if err != nil {
-			return 0, from, err
+			c.readErr = err
+			return
 		}
 		if n == 0 {
 			continue
 		}
-		switch bs[0] {
+		switch buf[0] {
 		case typeSessionTraffic:
-			// This is what we want to handle here
+			select {
+			case c.traffic <- packet{buf[:n], from}:
+				buf = c.trafficBuffers.Get().([]byte)
+			default:
+			}
 		case typeSessionProto:
 			var key keyArray
 			copy(key[:], from.(iwt.Addr))
-			data := append([]byte(nil), bs[1:n]...)
+			data := append([]byte(nil), buf[1:n]...)
 			c.proto.handleProto(nil, key, data)
-			continue
-		default:
-			continue
-		}
-		bs = bs[1:n]
-		copy(p, bs)
-		if len(p) < len(bs) {
-			n = len(p)
-		} else {
-			n = len(bs)
+		case typeSessionPort:
+			c.ports.deliver(buf[1:n], from)
 		}
-		return
 	}
 }
 
 func (c *Core) WriteTo(p []byte, addr net.Addr) (n int, err error) {
+	if !c.writes.enter() {
+		return 0, net.ErrClosed
+	}
+	defer c.writes.exit()
 	buf := make([]byte, 0, 65535)
 	buf = append(buf, typeSessionTraffic)
 	buf = append(buf, p...)
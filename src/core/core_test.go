@@ -2,10 +2,20 @@ package core
 
 import (
 	"bytes"
+	"context"
 	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"math/rand"
+	"net"
+	"net/http"
 	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -16,6 +26,9 @@ import (
 // If verbose is set to true, three log levels are enabled: "info", "warn", "error".
 func GetLoggerWithPrefix(prefix string, verbose bool) *log.Logger {
 	l := log.New(os.Stderr, prefix, log.Flags())
+	// The logger sets its call depth lazily on first use, which races if the
+	// first two log lines are written from different goroutines.
+	l.SetCallDepth(2)
 	if !verbose {
 		return l
 	}
@@ -37,14 +50,14 @@ func CreateAndConnectTwo(t testing.TB, verbose bool) (nodeA *Core, nodeB *Core)
 		t.Fatal(err)
 	}
 	logger := GetLoggerWithPrefix("", false)
-	if nodeA, err = New(skA, logger, ListenAddress("tcp://127.0.0.1:0")); err != nil {
+	if nodeA, err = New(skA, logger, ListenAddress("tcp://127.0.0.1:0"), NetworkDomain{Prefix: "fc"}); err != nil {
 		t.Fatal(err)
 	}
-	if nodeB, err = New(skB, logger, ListenAddress("tcp://127.0.0.1:0")); err != nil {
+	if nodeB, err = New(skB, logger, ListenAddress("tcp://127.0.0.1:0"), NetworkDomain{Prefix: "fc"}); err != nil {
 		t.Fatal(err)
 	}
 
-	u, err := url.Parse("tcp://" + nodeA.links.tcp.getAddr().String())
+	u, err := url.Parse("tcp://" + nodeA.GetListeners()[0].Address)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -65,6 +78,30 @@ func CreateAndConnectTwo(t testing.TB, verbose bool) (nodeA *Core, nodeB *Core)
 	return nodeA, nodeB
 }
 
+// newTestCore creates a node that logs with the given prefix and is stopped
+// when the test finishes.
+func newTestCore(t testing.TB, prefix string, opts ...SetupOption) *Core {
+	t.Helper()
+	_, sk, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]SetupOption{NetworkDomain{Prefix: "fc"}}, opts...)
+	node, err := New(sk, GetLoggerWithPrefix(prefix, false), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(node.Stop)
+	return node
+}
+
+// newTestCores creates two unconnected nodes with the same options, which
+// are stopped when the test finishes.
+func newTestCores(t testing.TB, opts ...SetupOption) (nodeA *Core, nodeB *Core) {
+	t.Helper()
+	return newTestCore(t, "A: ", opts...), newTestCore(t, "B: ", opts...)
+}
+
 // WaitConnected blocks until either nodes negotiated DHT or 5 seconds passed.
 func WaitConnected(nodeA, nodeB *Core) bool {
 	// It may take up to 3 seconds, but let's wait 5.
@@ -148,6 +185,39 @@ func TestCore_Start_Transfer(t *testing.T) {
 	<-done
 }
 
+// TestCore_Start_ConnectTransports checks that two nodes can peer over each
+// of the datagram and HTTP based transports.
+func TestCore_Start_ConnectTransports(t *testing.T) {
+	for _, listen := range []string{
+		"quic://127.0.0.1:0",
+		"ws://127.0.0.1:0/mesh",
+		"wss://127.0.0.1:0",
+	} {
+		lu, err := url.Parse(listen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(lu.Scheme, func(t *testing.T) {
+			nodeA, nodeB := newTestCores(t)
+
+			listener, err := nodeA.links.listen(lu, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := url.Parse(fmt.Sprintf("%s://%s%s?key=%s", lu.Scheme, listener.Addr(), lu.Path, hex.EncodeToString(nodeA.PublicKey())))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = nodeB.CallPeer(u, ""); err != nil {
+				t.Fatal(err)
+			}
+			if !WaitConnected(nodeA, nodeB) {
+				t.Fatal("nodes did not connect")
+			}
+		})
+	}
+}
+
 // BenchmarkCore_Start_Transfer estimates the possible transfer between nodes (in MB/s).
 func BenchmarkCore_Start_Transfer(b *testing.B) {
 	nodeA, nodeB := CreateAndConnectTwo(b, false)
@@ -184,3 +254,433 @@ func BenchmarkCore_Start_Transfer(b *testing.B) {
 	}
 	<-done
 }
+
+// CreateHTTPProxy starts a minimal HTTP CONNECT proxy that only accepts the
+// given credentials and returns its address.
+func CreateHTTPProxy(t testing.TB, user, password string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodConnect {
+				http.Error(w, "", http.StatusMethodNotAllowed)
+				return
+			}
+			if r.Header.Get("Proxy-Authorization") != auth {
+				http.Error(w, "", http.StatusProxyAuthRequired)
+				return
+			}
+			remote, err := net.Dial("tcp", r.Host)
+			if err != nil {
+				http.Error(w, "", http.StatusBadGateway)
+				return
+			}
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				_ = remote.Close()
+				return
+			}
+			_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+			go func() {
+				_, _ = io.Copy(remote, conn)
+				_ = remote.Close()
+			}()
+			_, _ = io.Copy(conn, remote)
+			_ = conn.Close()
+		}),
+	}
+	go server.Serve(listener) // nolint:errcheck
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+	return listener.Addr().String()
+}
+
+func TestCore_Start_ConnectHTTPProxy(t *testing.T) {
+	nodeA, nodeB := newTestCores(t)
+
+	listener, err := nodeA.links.listen(&url.URL{Scheme: "tcp", Host: "127.0.0.1:0"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := CreateHTTPProxy(t, "user", "secret")
+
+	u, err := url.Parse(fmt.Sprintf("http-proxy://user:wrong@%s/%s", proxy, listener.Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errch := make(chan error, 1)
+	if _, err = nodeB.links.call(u, "", errch); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-errch; !errors.Is(err, errHTTPProxyAuth) {
+		t.Fatalf("expected proxy authentication error, got %v", err)
+	}
+
+	u, err = url.Parse(fmt.Sprintf("http-proxy://user:secret@%s/%s?key=%s", proxy, listener.Addr(), hex.EncodeToString(nodeA.PublicKey())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = nodeB.CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("nodes did not connect")
+	}
+}
+
+// TestCore_Start_ConnectWSProxy checks that ws:// peerings are tunnelled
+// through an HTTP proxy with CONNECT.
+func TestCore_Start_ConnectWSProxy(t *testing.T) {
+	nodeA, nodeB := newTestCores(t)
+
+	listener, err := nodeA.links.listen(&url.URL{Scheme: "ws", Host: "127.0.0.1:0"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := CreateHTTPProxy(t, "user", "secret")
+	query := url.Values{
+		"proxy": {"http://user:secret@" + proxy},
+		"key":   {hex.EncodeToString(nodeA.PublicKey())},
+	}
+	u := &url.URL{Scheme: "ws", Host: listener.Addr().String(), RawQuery: query.Encode()}
+	if err = nodeB.CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("nodes did not connect")
+	}
+}
+
+func TestCore_Start_ConnectPassword(t *testing.T) {
+	for _, tc := range []struct {
+		name, listen, peer string
+		connect            bool
+	}{
+		{"match", "secret", "secret", true},
+		{"mismatch", "secret", "wrong", false},
+		{"missing", "secret", "", false},
+		{"unexpected", "", "secret", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			nodeA, nodeB := newTestCores(t)
+
+			lu := &url.URL{Scheme: "tcp", Host: "127.0.0.1:0", RawQuery: url.Values{"password": {tc.listen}}.Encode()}
+			listener, err := nodeA.links.listen(lu, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			u := &url.URL{Scheme: "tcp", Host: listener.Addr().String(), RawQuery: url.Values{"password": {tc.peer}}.Encode()}
+			errch := make(chan error, 1)
+			if _, err = nodeB.links.call(u, "", errch); err != nil {
+				t.Fatal(err)
+			}
+			if err = <-errch; err != nil {
+				t.Fatal(err)
+			}
+			if connected := WaitConnected(nodeA, nodeB); connected != tc.connect {
+				t.Fatalf("expected connected to be %v", tc.connect)
+			}
+		})
+	}
+}
+
+// TestCore_Start_Compression checks that compression is only used when both
+// sides ask for it, and that traffic still passes over a compressed link.
+func TestCore_Start_Compression(t *testing.T) {
+	for _, tc := range []struct {
+		name, listen, peer, expect string
+	}{
+		{"both", "zstd", "zstd", "zstd"},
+		{"listener", "zstd", "", ""},
+		{"peer", "", "zstd", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			nodeA, nodeB := newTestCores(t)
+
+			lu := &url.URL{Scheme: "tcp", Host: "127.0.0.1:0", RawQuery: url.Values{"compress": {tc.listen}}.Encode()}
+			listener, err := nodeA.links.listen(lu, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			u := &url.URL{Scheme: "tcp", Host: listener.Addr().String(), RawQuery: url.Values{"compress": {tc.peer}}.Encode()}
+			if err = nodeB.CallPeer(u, ""); err != nil {
+				t.Fatal(err)
+			}
+			msgLen := 1500
+			done := CreateEchoListener(t, nodeA, msgLen, 1)
+			if !WaitConnected(nodeA, nodeB) {
+				t.Fatal("nodes did not connect")
+			}
+			msg := make([]byte, msgLen)
+			msg[0] = 0x60
+			copy(msg[8:24], nodeB.Address())
+			copy(msg[24:40], nodeA.Address())
+			if _, err = nodeB.WriteTo(msg, nodeA.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, msgLen)
+			if _, _, err = nodeB.ReadFrom(buf); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(msg[40:], buf[40:]) {
+				t.Fatal("expected echo")
+			}
+			<-done
+			for _, p := range nodeB.GetPeers() {
+				if p.Compression != tc.expect {
+					t.Fatalf("expected compression %q, got %q", tc.expect, p.Compression)
+				}
+				// Link framing adds a few bytes, so uncompressed links use
+				// slightly more on the wire than they carry.
+				if tc.expect == "" && (p.RXWireBytes < p.RXBytes || p.TXWireBytes < p.TXBytes) {
+					t.Fatal("fewer wire bytes than payload bytes on an uncompressed link")
+				}
+				if p.RXWireBytes == 0 || p.TXWireBytes == 0 {
+					t.Fatal("no wire bytes counted")
+				}
+			}
+		})
+	}
+
+	// Listeners refuse invalid options, as peer URIs do.
+	node := newTestCore(t, "")
+	for _, uri := range []string{
+		"tcp://127.0.0.1:0?compress=x",
+		"tcp://127.0.0.1:0?ratelimit=x",
+	} {
+		if err := node.AddListener(uri); err == nil {
+			t.Fatalf("expected an error listening on %s", uri)
+		}
+	}
+}
+
+// TestCore_Listeners checks that listeners can be added and removed while the
+// node is running.
+func TestCore_Listeners(t *testing.T) {
+	nodeA, nodeB := newTestCores(t)
+
+	const uri = "tcp://127.0.0.1:0"
+	if err := nodeA.AddListener(uri); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodeA.AddListener(uri); err == nil {
+		t.Fatal("expected an error adding the same listener twice")
+	}
+	listeners := nodeA.GetListeners()
+	if len(listeners) != 1 || listeners[0].URI != uri || listeners[0].Scheme != "tcp" {
+		t.Fatalf("unexpected listeners: %+v", listeners)
+	}
+	addr := listeners[0].Address
+	if _, port, _ := net.SplitHostPort(addr); port == "" || port == "0" {
+		t.Fatalf("listener not reporting its bound address: %q", addr)
+	}
+
+	u, _ := url.Parse("tcp://" + addr)
+	if err := nodeB.CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("could not connect to added listener")
+	}
+
+	if err := nodeA.RemoveListener(uri); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodeA.RemoveListener(uri); err == nil {
+		t.Fatal("expected an error removing an unknown listener")
+	}
+	if len(nodeA.GetListeners()) != 0 {
+		t.Fatal("listener still reported after removal")
+	}
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		_ = conn.Close()
+		t.Fatal("listener still accepting after removal")
+	}
+	if len(nodeA.GetPeers()) != 1 {
+		t.Fatal("accepted link was closed along with the listener")
+	}
+}
+
+// TestCore_AllowedPublicKeys checks that allowed keys can be changed while the
+// node is running, and that removing a key can close its inbound links.
+func TestCore_AllowedPublicKeys(t *testing.T) {
+	var other ed25519.PublicKey
+	var err error
+	if other, _, err = ed25519.GenerateKey(nil); err != nil {
+		t.Fatal(err)
+	}
+	nodeA := newTestCore(t, "A: ",
+		ListenAddress("tcp://127.0.0.1:0"),
+		AllowedPublicKey(other),
+	)
+	nodeB := newTestCore(t, "B: ")
+
+	u, _ := url.Parse("tcp://" + nodeA.GetListeners()[0].Address)
+	if err = nodeB.CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 500)
+	if len(nodeA.GetPeers()) != 0 {
+		t.Fatal("peering from a key that isn't allowed succeeded")
+	}
+
+	keyB := nodeB.PublicKey()
+	if err = nodeA.AddAllowedPublicKey(keyB); err != nil {
+		t.Fatal(err)
+	}
+	if keys := nodeA.GetAllowedPublicKeys(); len(keys) != 2 {
+		t.Fatalf("expected two allowed keys, got %d", len(keys))
+	}
+	if err = nodeB.CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("peering from an allowed key failed")
+	}
+
+	if err = nodeA.RemoveAllowedPublicKey(keyB, true); err != nil {
+		t.Fatal(err)
+	}
+	if err = nodeA.RemoveAllowedPublicKey(keyB, true); err == nil {
+		t.Fatal("expected an error removing a key that isn't allowed")
+	}
+	for i := 0; i < 50 && len(nodeA.GetPeers()) != 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if len(nodeA.GetPeers()) != 0 {
+		t.Fatal("inbound link from a removed key was not closed")
+	}
+}
+
+// lockedBuffer collects log output from several goroutines.
+type lockedBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+func TestCore_Shutdown(t *testing.T) {
+	var skA ed25519.PrivateKey
+	var err error
+	if _, skA, err = ed25519.GenerateKey(nil); err != nil {
+		t.Fatal(err)
+	}
+	var output lockedBuffer
+	logger := log.New(&output, "A: ", 0)
+	logger.SetCallDepth(2)
+	logger.EnableLevel("info")
+	nodeA, err := New(skA, logger, NetworkDomain{Prefix: "fc"},
+		ListenAddress("tcp://127.0.0.1:0"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nodeA.Stop()
+	nodeB := newTestCore(t, "B: ",
+		ListenAddress("tcp://127.0.0.1:0"),
+		Peer{URI: "tcp://" + nodeA.GetListeners()[0].Address},
+	)
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("nodes did not connect")
+	}
+	events, unsubscribe := nodeA.Subscribe(64)
+	defer unsubscribe()
+	listenAddr := nodeB.GetListeners()[0].Address
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = nodeB.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if disconnected := waitEvent[LinkDisconnected](t, events); disconnected.Err != nil {
+		t.Fatalf("unexpected disconnected event: %+v", disconnected)
+	}
+	if !strings.Contains(output.String(), "peer left") {
+		t.Fatalf("goodbye was not logged:\n%s", output.String())
+	}
+	if _, err = nodeB.WriteTo([]byte{0}, nodeA.LocalAddr()); err == nil {
+		t.Fatal("expected writes to fail after shutdown")
+	}
+	if conn, err := net.Dial("tcp", listenAddr); err == nil {
+		_ = conn.Close()
+		t.Fatal("listener is still open after shutdown")
+	}
+}
+
+// TestCore_Shutdown_Drain checks that packets written before Shutdown are
+// sent before the goodbye closes the link.
+func TestCore_Shutdown_Drain(t *testing.T) {
+	nodeA, nodeB := CreateAndConnectTwo(t, false)
+	defer nodeA.Stop()
+	defer nodeB.Stop()
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("nodes did not connect")
+	}
+
+	received := make(chan int, 2*packetQueueSize)
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, _, err := nodeA.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			received <- n
+		}
+	}()
+	// Wait for the session to be set up before counting anything.
+	deadline := time.After(5 * time.Second)
+	for warm := false; !warm; {
+		if _, err := nodeB.WriteTo([]byte{0}, nodeA.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-received:
+			warm = true
+		case <-time.After(100 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("no traffic arrived")
+		}
+	}
+	for len(received) > 0 {
+		<-received
+	}
+
+	const count = 100
+	msg := make([]byte, 1000)
+	for i := 0; i < count; i++ {
+		if _, err := nodeB.WriteTo(msg, nodeA.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := nodeB.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		select {
+		case n := <-received:
+			if n != len(msg) {
+				t.Fatalf("unexpected packet of %d bytes", n)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("only %d of %d packets arrived", i, count)
+		}
+	}
+}
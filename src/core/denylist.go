@@ -0,0 +1,171 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// denylist holds the public keys and networks that inbound links are refused
+// from, along with how many times each has been hit. It is checked from the
+// accept loops of every listener, so it has its own lock rather than living
+// on the core actor.
+type denylist struct {
+	mutex    sync.Mutex
+	keys     map[keyArray]uint64
+	networks map[netip.Prefix]uint64
+}
+
+// DenylistEntry is a denied public key or network, only one of which is set,
+// and the number of inbound connections that it has refused.
+type DenylistEntry struct {
+	Key     ed25519.PublicKey
+	Network string
+	Hits    uint64
+}
+
+func (d *denylist) init() {
+	d.keys = map[keyArray]uint64{}
+	d.networks = map[netip.Prefix]uint64{}
+}
+
+func (d *denylist) addKey(key ed25519.PublicKey) error {
+	if len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("public key is incorrect length")
+	}
+	var k keyArray
+	copy(k[:], key)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, known := d.keys[k]; known {
+		return fmt.Errorf("public key already denied")
+	}
+	d.keys[k] = 0
+	return nil
+}
+
+func (d *denylist) removeKey(key ed25519.PublicKey) error {
+	var k keyArray
+	copy(k[:], key)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, known := d.keys[k]; !known {
+		return fmt.Errorf("public key not denied")
+	}
+	delete(d.keys, k)
+	return nil
+}
+
+func (d *denylist) addNetwork(network netip.Prefix) error {
+	network = network.Masked()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, known := d.networks[network]; known {
+		return fmt.Errorf("network already denied")
+	}
+	d.networks[network] = 0
+	return nil
+}
+
+func (d *denylist) removeNetwork(network netip.Prefix) error {
+	network = network.Masked()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, known := d.networks[network]; !known {
+		return fmt.Errorf("network not denied")
+	}
+	delete(d.networks, network)
+	return nil
+}
+
+// deniedKey reports whether the key is denied, counting a hit if it is.
+func (d *denylist) deniedKey(key ed25519.PublicKey) bool {
+	var k keyArray
+	copy(k[:], key)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	hits, denied := d.keys[k]
+	if denied {
+		d.keys[k] = hits + 1
+	}
+	return denied
+}
+
+// deniedAddr reports whether the address falls in a denied network, counting
+// a hit against the network if it does. Addresses that aren't IP addresses,
+// e.g. those of UNIX sockets, are never denied.
+func (d *denylist) deniedAddr(addr net.Addr) bool {
+	return d.deniedIP(ipForAddr(addr))
+}
+
+// ipForAddr returns the IP of an address, or nil if it isn't an IP address.
+func ipForAddr(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	case nil:
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(strings.SplitN(host, "%", 2)[0])
+}
+
+func (d *denylist) deniedIP(ip net.IP) bool {
+	a, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	a = a.Unmap()
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for network, hits := range d.networks {
+		if network.Contains(a) {
+			d.networks[network] = hits + 1
+			return true
+		}
+	}
+	return false
+}
+
+func (d *denylist) entries() []DenylistEntry {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	entries := make([]DenylistEntry, 0, len(d.keys)+len(d.networks))
+	for k, hits := range d.keys {
+		key := make(ed25519.PublicKey, ed25519.PublicKeySize)
+		copy(key, k[:])
+		entries = append(entries, DenylistEntry{Key: key, Hits: hits})
+	}
+	for network, hits := range d.networks {
+		entries = append(entries, DenylistEntry{Network: network.String(), Hits: hits})
+	}
+	return entries
+}
+
+// linkDenyListener drops connections from denied networks as they are
+// accepted, for listeners that hand connections to a server which does its
+// own TLS or protocol work before we would otherwise get to see them.
+type linkDenyListener struct {
+	net.Listener
+	denylist *denylist
+}
+
+func (l *linkDenyListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil || !l.denylist.deniedAddr(conn.RemoteAddr()) {
+			return conn, err
+		}
+		_ = conn.Close()
+	}
+}
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"net"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestDenylist_Addr(t *testing.T) {
+	var d denylist
+	d.init()
+	if err := d.addNetwork(netip.MustParsePrefix("192.0.2.1/24")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.addNetwork(netip.MustParsePrefix("192.0.2.0/24")); err == nil {
+		t.Fatal("expected an error adding the same network twice")
+	}
+	for addr, expect := range map[net.Addr]bool{
+		&net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 1}:        true,
+		&net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.10"), Port: 1}: true,
+		&net.UDPAddr{IP: net.ParseIP("192.0.3.10"), Port: 1}:        false,
+		&net.UnixAddr{Name: "/tmp/mesh.sock", Net: "unix"}:          false,
+	} {
+		if denied := d.deniedAddr(addr); denied != expect {
+			t.Fatalf("expected %s denied to be %v", addr, expect)
+		}
+	}
+	if entries := d.entries(); len(entries) != 1 || entries[0].Network != "192.0.2.0/24" || entries[0].Hits != 2 {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+}
+
+// TestDenylist_Inbound checks that denied networks and keys, and the inbound
+// filter, refuse inbound links, and that denying a key closes its links.
+func TestDenylist_Inbound(t *testing.T) {
+	var err error
+	filtered := make(chan struct{}, 1)
+	nodeA := newTestCore(t, "A: ",
+		ListenAddress("tcp://127.0.0.1:0"),
+		DeniedNetwork(netip.MustParsePrefix("127.0.0.0/8")),
+		InboundFilter(func(key ed25519.PublicKey, remoteAddr net.Addr) bool {
+			select {
+			case <-filtered:
+				return false
+			default:
+				return true
+			}
+		}),
+	)
+	nodeB := newTestCore(t, "B: ")
+	u, _ := url.Parse("tcp://" + nodeA.GetListeners()[0].Address)
+
+	// A denied network is dropped on accept.
+	if err = nodeB.CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 500)
+	if len(nodeA.GetPeers()) != 0 {
+		t.Fatal("link from a denied network came up")
+	}
+	if d := nodeA.GetDenylist(); len(d) != 1 || d[0].Hits == 0 {
+		t.Fatalf("expected a hit on the denied network: %+v", d)
+	}
+	if err = nodeA.RemoveDeniedNetwork("127.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The inbound filter refuses the link once.
+	filtered <- struct{}{}
+	if err = nodeB.CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 500)
+	if len(nodeA.GetPeers()) != 0 {
+		t.Fatal("link refused by the inbound filter came up")
+	}
+
+	// Denying a key closes its existing link.
+	if err = nodeB.CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("link did not come up after removing the denied network")
+	}
+	if err = nodeA.AddDeniedPublicKey(nodeB.PublicKey()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50 && len(nodeA.GetPeers())+len(nodeB.GetPeers()) != 0; i++ {
+		time.Sleep(time.Millisecond * 100)
+	}
+	if len(nodeA.GetPeers()) != 0 {
+		t.Fatal("link from a denied key was not closed")
+	}
+	time.Sleep(time.Millisecond * 100)
+	if err = nodeB.CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 500)
+	if len(nodeA.GetPeers()) != 0 {
+		t.Fatal("link from a denied key came up")
+	}
+	for _, d := range nodeA.GetDenylist() {
+		if d.Key != nil && d.Hits == 0 {
+			t.Fatal("expected a hit on the denied key")
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"sync"
+	"time"
+)
+
+// Directions of a link, as reported in events.
+const (
+	LinkDirectionInbound  = "inbound"
+	LinkDirectionOutbound = "outbound"
+)
+
+// Event is implemented by each of the events delivered by Core.Subscribe:
+// LinkConnecting, LinkConnected, LinkDisconnected, HandshakeRejected,
+// RootChanged and CoordsChanged.
+type Event interface {
+	isEvent()
+}
+
+// LinkConnecting is sent when a link has been established at the transport
+// level and the handshake is about to start.
+type LinkConnecting struct {
+	URI       string
+	LinkType  string
+	Direction string
+}
+
+// LinkConnected is sent once the handshake has finished and the link is up.
+type LinkConnected struct {
+	Key       ed25519.PublicKey
+	URI       string
+	LinkType  string
+	Direction string
+}
+
+// LinkDisconnected is sent when a link that was up goes down. Err is nil if
+// the link was closed cleanly, e.g. by either side shutting down, and is
+// otherwise the reason it went down, such as a timeout.
+type LinkDisconnected struct {
+	Key       ed25519.PublicKey
+	URI       string
+	LinkType  string
+	Direction string
+	Err       error
+}
+
+// HandshakeRejected is sent when a link doesn't come up because its handshake
+// failed or the remote side was refused, e.g. for an incompatible version, a
+// wrong password or a key that isn't allowed. Key is nil if the remote side
+// didn't get as far as telling us its key.
+type HandshakeRejected struct {
+	Key       ed25519.PublicKey
+	URI       string
+	LinkType  string
+	Direction string
+	Err       error
+}
+
+// RootChanged is sent when the root of the spanning tree that we are in
+// changes.
+type RootChanged struct {
+	Root ed25519.PublicKey
+}
+
+// CoordsChanged is sent when our coordinates in the spanning tree change.
+type CoordsChanged struct {
+	Coords []uint64
+}
+
+func (e LinkConnecting) isEvent()    {}
+func (e LinkConnected) isEvent()     {}
+func (e LinkDisconnected) isEvent()  {}
+func (e HandshakeRejected) isEvent() {}
+func (e RootChanged) isEvent()       {}
+func (e CoordsChanged) isEvent()     {}
+
+// How often the spanning tree is checked for root and coordinate changes.
+const eventTreeInterval = time.Second
+
+// eventBus delivers events to subscribers without ever blocking the sender.
+// A subscriber that falls behind misses events rather than holding up links.
+type eventBus struct {
+	mutex       sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func (b *eventBus) subscribe(buffer int) (chan Event, func()) {
+	ch := make(chan Event, buffer)
+	b.mutex.Lock()
+	if b.subscribers == nil {
+		b.subscribers = map[chan Event]struct{}{}
+	}
+	b.subscribers[ch] = struct{}{}
+	b.mutex.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			delete(b.subscribers, ch)
+			b.mutex.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (b *eventBus) publish(e Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (b *eventBus) hasSubscribers() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.subscribers) > 0
+}
+
+// Subscribe returns a channel that receives connection lifecycle and spanning
+// tree events, and a function that unsubscribes and closes the channel.
+// Events are dropped for a subscriber whose buffer is full, so the buffer
+// should be large enough to cover the time spent handling each event.
+func (c *Core) Subscribe(buffer int) (<-chan Event, func()) {
+	return c.events.subscribe(buffer)
+}
+
+// watchTree sends RootChanged and CoordsChanged events, as the spanning tree
+// doesn't tell us when it changes.
+func (c *Core) watchTree() {
+	ticker := time.NewTicker(eventTreeInterval)
+	defer ticker.Stop()
+	var root ed25519.PublicKey
+	var coords []uint64
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !c.events.hasSubscribers() {
+			continue
+		}
+		self := c.PacketConn.PacketConn.Debug.GetSelf()
+		if !root.Equal(self.Root) {
+			root = self.Root
+			c.events.publish(RootChanged{Root: root})
+		}
+		if !coordsEqual(coords, self.Coords) {
+			coords = self.Coords
+			c.events.publish(CoordsChanged{Coords: coords})
+		}
+	}
+}
+
+func coordsEqual(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
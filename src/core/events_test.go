@@ -0,0 +1,72 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// waitEvent returns the first event of type T, failing the test if none
+// arrives in time.
+func waitEvent[T Event](t *testing.T, events <-chan Event) T {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e, ok := e.(T); ok {
+				return e
+			}
+		case <-timeout:
+			var none T
+			t.Fatalf("timed out waiting for %T", none)
+			return none
+		}
+	}
+}
+
+func TestEvents_Lifecycle(t *testing.T) {
+	var err error
+	nodeA := newTestCore(t, "A: ",
+		ListenAddress("tcp://127.0.0.1:0?password=secret"),
+	)
+	nodeB := newTestCore(t, "B: ")
+	events, unsubscribe := nodeA.Subscribe(64)
+	defer unsubscribe()
+	addr := nodeA.GetListeners()[0].Address
+
+	u, _ := url.Parse(fmt.Sprintf("tcp://%s?password=wrong", addr))
+	if err = nodeB.CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	if rejected := waitEvent[HandshakeRejected](t, events); !errors.Is(rejected.Err, errPasswordMismatch) || rejected.Direction != LinkDirectionInbound {
+		t.Fatalf("unexpected rejection: %+v", rejected)
+	}
+
+	// Give node B time to clean up its side of the rejected link, or it will
+	// think that it is still connecting.
+	time.Sleep(time.Millisecond * 500)
+	u, _ = url.Parse(fmt.Sprintf("tcp://%s?password=secret", addr))
+	if err = nodeB.CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	waitEvent[LinkConnecting](t, events)
+	connected := waitEvent[LinkConnected](t, events)
+	if !connected.Key.Equal(nodeB.PublicKey()) || connected.LinkType != "tcp" {
+		t.Fatalf("unexpected connected event: %+v", connected)
+	}
+	// The first check of the tree after subscribing reports the current root.
+	waitEvent[RootChanged](t, events)
+
+	nodeB.Stop()
+	if disconnected := waitEvent[LinkDisconnected](t, events); !disconnected.Key.Equal(nodeB.PublicKey()) {
+		t.Fatalf("unexpected disconnected event: %+v", disconnected)
+	}
+
+	// Unsubscribing closes the channel once any buffered events are read.
+	unsubscribe()
+	for range events {
+	}
+}
@@ -2,6 +2,8 @@ package core
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -38,6 +40,7 @@ type link struct {
 	conn     *linkConn
 	options  linkOptions
 	info     linkInfo
+	key      keyArray // the remote public key, once the handshake is done
 	incoming bool
 	force    bool
 }
@@ -45,11 +48,17 @@ type link struct {
 type linkOptions struct {
 	pinnedEd25519Keys map[keyArray]struct{}
 	priority          uint8
+	password          []byte
+	compression       uint8
+	rateUp            uint64         // bytes per second, zero if unlimited
+	rateDown          uint64         // bytes per second, zero if unlimited
+	admission         *linkAdmission // set for inbound links counted by a listener's limits
 }
 
 type Listener struct {
 	net.Listener
 	closed chan struct{}
+	limits *linkListenerLimits
 }
 
 func (l *Listener) Close() error {
@@ -58,22 +67,125 @@ func (l *Listener) Close() error {
 	return err
 }
 
+type links struct {
+	phony.Inbox
+	core    *Core
+	tcp     *linkTCP                  // TCP interface support, shared by other transports
+	tls     *linkTLS                  // TLS interface support, shared by other transports
+	schemes map[string]*linkTransport // built-in and registered link types by URI scheme
+	_links  map[linkInfo]*link        // *link is nil if connection in progress
+	// TODO timeout (to remove from switch), read from config.ReadTimeout
+}
+
+func (l *links) init(c *Core) error {
+	l.core = c
+	l.schemes = map[string]*linkTransport{}
+	l.tcp = l.newLinkTCP()
+	l.tls = l.newLinkTLS(l.tcp)
+	httpProxy := l.newLinkHTTPProxy(l.tls, l.tcp)
+	ws := l.newLinkWS(l.tls, l.tcp, httpProxy)
+
+	l.registerTransport("tcp", "TCP", l.tcp)
+	l.registerTransport("tls", "TLS", l.tls)
+	l.registerTransport("unix", "UNIX", l.newLinkUNIX())
+	l.registerTransport("quic", "QUIC", l.newLinkQUIC(l.tls, l.tcp))
+	l.registerTransport("ws", "WebSocket", ws)
+	l.registerTransport("wss", "WebSocket", ws)
+	l.registerTransport("socks", "SOCKS", l.newLinkSOCKS())
+	l.registerTransport("http-proxy", "HTTP proxy", httpProxy)
+	l.registerTransport("mpath", "Multipath", l.newLinkMPATH())
+	l.registerPlatformTransports()
+	for scheme, transport := range c.config.transports {
+		l.registerTransport(scheme, scheme, transport)
+	}
+
+	l._links = make(map[linkInfo]*link)
+	return nil
+}
+
 func (l *links) shutdown() {
-	phony.Block(l.tcp, func() {
-		for l := range l.tcp._listeners {
-			_ = l.Close()
+	for _, scheme := range l.schemes {
+		scheme.shutdown()
+	}
+}
+
+func (l *links) call(u *url.URL, sintf string, errch chan<- error) (info linkInfo, err error) {
+	info = linkInfoForURL(u, sintf)
+	if l.isConnectedTo(info) {
+		if errch != nil {
+			close(errch) // already connected, no error
 		}
-	})
-	phony.Block(l.tls, func() {
-		for l := range l.tls._listeners {
-			_ = l.Close()
+		return info, nil
+	}
+	transport, options, err := l.dialOptions(u)
+	if err != nil {
+		if errch != nil {
+			close(errch)
 		}
-	})
-	phony.Block(l.unix, func() {
-		for l := range l.unix._listeners {
-			_ = l.Close()
+		return info, err
+	}
+	go func() {
+		if errch != nil {
+			defer close(errch)
 		}
-	})
+		if err := transport.dial(u, options, sintf); err != nil && err != io.EOF {
+			if errors.Is(err, errHTTPProxyAuth) {
+				l.core.log.Warnf("Failed to authenticate with %s %s: %s\n", transport.name, u.Host, err)
+			} else {
+				l.core.log.Warnf("Failed to dial %s %s: %s\n", transport.name, u.Host, err)
+			}
+			if errch != nil {
+				errch <- err
+			}
+		}
+	}()
+	return info, nil
+}
+
+// dialOptions returns the transport for a peer URI and the options that it
+// asks for, failing if any of them are invalid.
+func (l *links) dialOptions(u *url.URL) (*linkTransport, linkOptions, error) {
+	options := linkOptions{
+		pinnedEd25519Keys: map[keyArray]struct{}{},
+	}
+	query := u.Query()
+	for _, pubkey := range query["key"] {
+		sigPub, err := hex.DecodeString(pubkey)
+		if err != nil {
+			return nil, options, fmt.Errorf("pinned key contains invalid hex characters")
+		}
+		var sigPubKey keyArray
+		copy(sigPubKey[:], sigPub)
+		options.pinnedEd25519Keys[sigPubKey] = struct{}{}
+	}
+	if p := query.Get("priority"); p != "" {
+		pi, err := strconv.ParseUint(p, 10, 8)
+		if err != nil {
+			return nil, options, fmt.Errorf("priority invalid: %w", err)
+		}
+		options.priority = uint8(pi)
+	}
+	options.password = []byte(query.Get("password"))
+	var err error
+	if options.compression, err = linkCompressionFor(query.Get("compress")); err != nil {
+		return nil, options, err
+	}
+	if options.rateUp, options.rateDown, err = linkRateLimits(query); err != nil {
+		return nil, options, err
+	}
+	transport, ok := l.schemes[u.Scheme]
+	if !ok {
+		return nil, options, errors.New("unknown call scheme: " + u.Scheme)
+	}
+	return transport, options, nil
+}
+
+func (l *links) listen(u *url.URL, sintf string) (*Listener, error) {
+	transport, ok := l.schemes[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unrecognised scheme %q", u.Scheme)
+	}
+	return transport.listen(u, sintf)
 }
 
 func (l *links) isConnectedTo(info linkInfo) bool {
@@ -84,11 +196,25 @@ func (l *links) isConnectedTo(info linkInfo) bool {
 	return isConnected
 }
 
+// isUp reports whether a link with the given info has finished its
+// handshake, unlike isConnectedTo which also counts links still being set up.
+func (l *links) isUp(info linkInfo) bool {
+	var isUp bool
+	phony.Block(l, func() {
+		isUp = l._links[info] != nil
+	})
+	return isUp
+}
+
 func (l *links) create(conn net.Conn, dial *linkDial, name string, info linkInfo, incoming, force bool, options linkOptions) error {
+	now := time.Now()
 	intf := link{
 		conn: &linkConn{
-			Conn: conn,
-			up:   time.Now(),
+			Conn:      conn,
+			up:        now,
+			limitUp:   newLinkRateLimiter(options.rateUp),
+			limitDown: newLinkRateLimiter(options.rateDown),
+			meter:     linkRateMeter{last: now},
 		},
 		lname:    name,
 		links:    l,
@@ -105,49 +231,52 @@ func (l *links) create(conn net.Conn, dial *linkDial, name string, info linkInfo
 	return nil
 }
 
-func (intf *link) handler(dial *linkDial) error {
-	defer intf.conn.Close() // nolint:errcheck
+// Errors that the handshake can fail with, so that the reason a link was
+// rejected can be told apart in the logs.
+var (
+	errIncompatibleVersion = errors.New("remote node is incompatible version")
+	errInvalidSignature    = errors.New("remote node failed to prove ownership of its public key")
+	errPinnedKeyMismatch   = errors.New("node public key does not match pinned keys")
+	errKeyNotAllowed       = errors.New("node public key is not in AllowedPublicKeys")
+	errKeyDenied           = errors.New("node public key is denied")
+	errKeyFiltered         = errors.New("node refused by inbound filter")
+	errPasswordMismatch    = errors.New("remote node did not supply the link password")
+)
 
-	// Don't connect to this link more than once.
-	if intf.links.isConnectedTo(intf.info) {
-		return nil
+// handshake exchanges version metadata with the remote side and then a
+// signature over the nonce that the other side sent, so that the remote
+// side is known to hold the private key for the public key it claimed
+// before the pinned keys and AllowedPublicKeys are checked against it.
+func (intf *link) handshake() (*version_metadata, error) {
+	local := version_getBaseMetadata()
+	local.key = intf.links.core.public
+	local.compression = intf.options.compression
+	if _, err := rand.Read(local.nonce[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate handshake nonce: %w", err)
 	}
-
-	// Mark the connection as in progress.
-	phony.Block(intf.links, func() {
-		intf.links._links[intf.info] = nil
-	})
-
-	// When we're done, clean up the connection entry.
-	defer phony.Block(intf.links, func() {
-		delete(intf.links._links, intf.info)
-	})
-
-	meta := version_getBaseMetadata()
-	meta.key = intf.links.core.public
-	metaBytes := meta.encode()
+	metaBytes := local.encode()
 	if err := intf.conn.SetDeadline(time.Now().Add(time.Second * 6)); err != nil {
-		return fmt.Errorf("failed to set handshake deadline: %w", err)
+		return nil, fmt.Errorf("failed to set handshake deadline: %w", err)
 	}
 	n, err := intf.conn.Write(metaBytes)
 	switch {
 	case err != nil:
-		return fmt.Errorf("write handshake: %w", err)
+		return nil, fmt.Errorf("write handshake: %w", err)
 	case err == nil && n != len(metaBytes):
-		return fmt.Errorf("incomplete handshake send")
-	}
-	if _, err = io.ReadFull(intf.conn, metaBytes); err != nil {
-		return fmt.Errorf("read handshake: %w", err)
+		return nil, fmt.Errorf("incomplete handshake send")
 	}
-	if err = intf.conn.SetDeadline(time.Time{}); err != nil {
-		return fmt.Errorf("failed to clear handshake deadline: %w", err)
+	// Read and check the version first, as the rest of the metadata may be a
+	// different length if the remote side is running a different version.
+	hlen := version_getHeaderLength()
+	if _, err = io.ReadFull(intf.conn, metaBytes[:hlen]); err != nil {
+		return nil, fmt.Errorf("read handshake: %w", err)
 	}
-	meta = version_metadata{}
+	remote := &version_metadata{}
 	base := version_getBaseMetadata()
-	if !meta.decode(metaBytes) {
-		return errors.New("failed to decode metadata")
+	if !remote.decodeHeader(metaBytes[:hlen]) {
+		return nil, errors.New("failed to decode metadata")
 	}
-	if !meta.check() {
+	if !remote.check() {
 		var connectError string
 		if intf.incoming {
 			connectError = "Rejected incoming connection"
@@ -158,84 +287,196 @@ func (intf *link) handler(dial *linkDial) error {
 			connectError,
 			intf.lname,
 			fmt.Sprintf("%d.%d", base.ver, base.minorVer),
-			fmt.Sprintf("%d.%d", meta.ver, meta.minorVer),
+			fmt.Sprintf("%d.%d", remote.ver, remote.minorVer),
 		)
-		return errors.New("remote node is incompatible version")
+		return nil, fmt.Errorf("%w (local %d.%d, remote %d.%d)", errIncompatibleVersion,
+			base.ver, base.minorVer, remote.ver, remote.minorVer)
+	}
+	if _, err = io.ReadFull(intf.conn, metaBytes[hlen:]); err != nil {
+		return nil, fmt.Errorf("read handshake: %w", err)
 	}
-	// Check if the remote side matches the keys we expected. This is a bit of a weak
-	// check - in future versions we really should check a signature or something like that.
+	if !remote.decode(metaBytes) {
+		return nil, errors.New("failed to decode metadata")
+	}
+	// Now prove that we own our key by signing the nonce that the remote side
+	// sent us, and that we know the link password, and check that the remote
+	// side has done the same.
+	auth := local.sign(intf.links.core.secret, remote)
+	auth = append(auth, local.mac(intf.options.password, remote)...)
+	if n, err = intf.conn.Write(auth); err != nil {
+		return nil, fmt.Errorf("write handshake signature: %w", err)
+	} else if n != len(auth) {
+		return nil, fmt.Errorf("incomplete handshake signature send")
+	}
+	if _, err = io.ReadFull(intf.conn, auth); err != nil {
+		return nil, fmt.Errorf("read handshake signature: %w", err)
+	}
+	if err = intf.conn.SetDeadline(time.Time{}); err != nil {
+		return nil, fmt.Errorf("failed to clear handshake deadline: %w", err)
+	}
+	sig, mac := auth[:ed25519.SignatureSize], auth[ed25519.SignatureSize:]
+	if !local.verify(remote, sig) {
+		return nil, fmt.Errorf("%w %q", errInvalidSignature, hex.EncodeToString(remote.key))
+	}
+	if !local.checkMAC(intf.options.password, remote, mac) {
+		return nil, fmt.Errorf("%w: %q", errPasswordMismatch, hex.EncodeToString(remote.key))
+	}
+	// Check if the remote side matches the keys we expected.
 	if pinned := intf.options.pinnedEd25519Keys; len(pinned) > 0 {
 		var key keyArray
-		copy(key[:], meta.key)
+		copy(key[:], remote.key)
 		if _, allowed := pinned[key]; !allowed {
-			return fmt.Errorf("node public key that does not match pinned keys")
+			return nil, fmt.Errorf("%w: %q", errPinnedKeyMismatch, hex.EncodeToString(remote.key))
 		}
 	}
 	// Check if we're authorized to connect to this key / IP
-	allowed := intf.links.core.config._allowedPublicKeys
-	isallowed := len(allowed) == 0
-	for k := range allowed {
-		if bytes.Equal(k[:], meta.key) {
-			isallowed = true
-			break
+	var isallowed bool
+	phony.Block(intf.links.core, func() {
+		allowed := intf.links.core.config._allowedPublicKeys
+		isallowed = len(allowed) == 0
+		for k := range allowed {
+			if bytes.Equal(k[:], remote.key) {
+				isallowed = true
+				break
+			}
 		}
-	}
+	})
 	if intf.incoming && !intf.force && !isallowed {
-		_ = intf.close()
-		return fmt.Errorf("node public key %q is not in AllowedPublicKeys", hex.EncodeToString(meta.key))
+		return nil, fmt.Errorf("%w: %q", errKeyNotAllowed, hex.EncodeToString(remote.key))
 	}
+	return remote, nil
+}
+
+func (intf *link) handler(dial *linkDial) (err error) {
+	defer intf.conn.Close() // nolint:errcheck
+	defer intf.options.admission.done()
 
+	// Don't connect to this link more than once.
+	if intf.links.isConnectedTo(intf.info) {
+		return nil
+	}
+
+	// Mark the connection as in progress.
 	phony.Block(intf.links, func() {
-		intf.links._links[intf.info] = intf
+		intf.links._links[intf.info] = nil
 	})
 
-	dir := "outbound"
+	dir := LinkDirectionOutbound
 	if intf.incoming {
-		dir = "inbound"
+		dir = LinkDirectionInbound
+	}
+	events := &intf.links.core.events
+	events.publish(LinkConnecting{
+		URI:       intf.lname,
+		LinkType:  intf.info.linkType,
+		Direction: dir,
+	})
+
+	// Only links that never came up return an error, so report it as the
+	// reason that they were rejected.
+	var meta *version_metadata
+	defer func() {
+		if err == nil {
+			return
+		}
+		rejected := HandshakeRejected{
+			URI:       intf.lname,
+			LinkType:  intf.info.linkType,
+			Direction: dir,
+			Err:       err,
+		}
+		if meta != nil {
+			rejected.Key = meta.key
+		}
+		events.publish(rejected)
+	}()
+
+	// Let the core know when the link goes away, so that it can be dialled
+	// again if it belongs to a configured peer, even if it was made some
+	// other way, e.g. with CallPeer.
+	defer func() {
+		intf.links.core.peerLinkDown(dial, intf.info, err)
+	}()
+
+	// When we're done, clean up the connection entry.
+	defer phony.Block(intf.links, func() {
+		delete(intf.links._links, intf.info)
+	})
+
+	meta, err = intf.handshake()
+	intf.options.admission.handshakeDone()
+	if err != nil {
+		if errors.Is(err, errKeyNotAllowed) {
+			_ = intf.close()
+		}
+		return err
+	}
+	if intf.incoming {
+		c := intf.links.core
+		if c.denylist.deniedKey(meta.key) {
+			return fmt.Errorf("%w: %q", errKeyDenied, hex.EncodeToString(meta.key))
+		}
+		if filter := c.config.inboundFilter; filter != nil && !filter(meta.key, intf.conn.RemoteAddr()) {
+			return fmt.Errorf("%w: %q", errKeyFiltered, hex.EncodeToString(meta.key))
+		}
 	}
+
+	if meta.compression == intf.options.compression {
+		if err = intf.conn.compress(meta.compression); err != nil {
+			return fmt.Errorf("failed to set up link compression: %w", err)
+		}
+	}
+
+	// From here on everything is framed, so that we can ping the remote side.
+	intf.conn.frames = newLinkFramer(intf.conn)
+	go intf.conn.frames.run(intf.links.core.config.linkTimeout)
+	defer intf.conn.frames.stop()
+
+	copy(intf.key[:], meta.key)
+	phony.Block(intf.links, func() {
+		intf.links._links[intf.info] = intf
+	})
+	if !intf.incoming && dial != nil {
+		intf.links.core.peerLinkUp(dial)
+	}
+
 	remoteAddr := net.IP(intf.links.core.AddrForKey(meta.key)[:]).String()
 	remoteStr := fmt.Sprintf("%s@%s", remoteAddr, intf.info.remote)
 	localStr := intf.conn.LocalAddr()
 	intf.links.core.log.Infof("Connected %s %s: %s, source %s",
 		dir, strings.ToUpper(intf.info.linkType), remoteStr, localStr)
+	events.publish(LinkConnected{
+		Key:       meta.key,
+		URI:       intf.lname,
+		LinkType:  intf.info.linkType,
+		Direction: dir,
+	})
 
 	time.AfterFunc(time.Millisecond*500, func() {
 		intf.links.core.PeersChangedSignal.Emit(nil)
 	})
+	disconnected := LinkDisconnected{
+		Key:       meta.key,
+		URI:       intf.lname,
+		LinkType:  intf.info.linkType,
+		Direction: dir,
+	}
 	err = intf.links.core.HandleConn(meta.key, intf.conn, intf.options.priority)
-	switch err {
-	case io.EOF, net.ErrClosed, nil:
+	switch {
+	case intf.conn.frames.peerLeft():
+		intf.links.core.log.Infof("Disconnected %s %s: %s, source %s; peer left",
+			dir, strings.ToUpper(intf.info.linkType), remoteStr, localStr)
+	case err == nil, err == io.EOF, err == net.ErrClosed:
 		intf.links.core.log.Infof("Disconnected %s %s: %s, source %s",
 			dir, strings.ToUpper(intf.info.linkType), remoteStr, localStr)
 	default:
 		intf.links.core.log.Infof("Disconnected %s %s: %s, source %s; error: %s",
 			dir, strings.ToUpper(intf.info.linkType), remoteStr, localStr, err)
+		disconnected.Err = err
 	}
+	events.publish(disconnected)
 	intf.links.core.PeersChangedSignal.Emit(nil)
 
-	if !intf.incoming && dial != nil {
-		// The connection was one that we dialled, so wait a second and try to
-		// dial it again.
-		var retry func(attempt int)
-		retry = func(attempt int) {
-			// intf.links.core.log.Infof("Retrying %s (attempt %d of 5)...", dial.url.String(), attempt)
-			errch := make(chan error, 1)
-			if _, err := intf.links.call(dial.url, dial.sintf, errch); err != nil {
-				return
-			}
-			if err := <-errch; err != nil {
-				if attempt < 3 {
-					time.AfterFunc(time.Second, func() {
-						retry(attempt + 1)
-					})
-				}
-			}
-		}
-		time.AfterFunc(time.Second, func() {
-			retry(1)
-		})
-	}
-
 	return nil
 }
 
@@ -251,32 +492,87 @@ func linkInfoFor(linkType, sintf, remote string) linkInfo {
 	}
 }
 
+// linkInfoForURL identifies an outbound link by its peer URI, for every
+// transport alike, so that the link can be found before it is dialled. This
+// is the host and port as written in the URI, plus the path, which tells
+// apart links through the same proxy. Hostnames aren't resolved, so unlike
+// before transports were registered by scheme, peers given by hostname and by
+// IP address get a link each even if they are the same host. Link-local
+// addresses are identified by the IP alone, as for inbound links, so that two
+// nodes that find each other by multicast only end up with one link.
+func linkInfoForURL(u *url.URL, sintf string) linkInfo {
+	remote := u.Host
+	if host, _, err := net.SplitHostPort(u.Host); err == nil {
+		host = strings.SplitN(host, "%", 2)[0]
+		if ip := net.ParseIP(host); ip != nil && ip.IsLinkLocalUnicast() {
+			remote = ip.String()
+		}
+	}
+	return linkInfoFor(u.Scheme, sintf, remote+u.Path)
+}
+
+// linkInfoForAddr identifies an inbound link by the addresses of its
+// connection. Links from IP addresses are identified by the remote address,
+// or just the IP if it is link-local.
+func linkInfoForAddr(linkType, sintf string, local, remote net.Addr) linkInfo {
+	switch ip := ipForAddr(remote); {
+	case ip == nil:
+		return linkInfoFor(linkType, sintf, fmt.Sprintf("%s/%s", local, remote))
+	case ip.IsLinkLocalUnicast():
+		return linkInfoFor(linkType, sintf, ip.String())
+	default:
+		return linkInfoFor(linkType, sintf, remote.String())
+	}
+}
+
 type linkConn struct {
 	// tx and rx are at the beginning of the struct to ensure 64-bit alignment
 	// on 32-bit platforms, see https://pkg.go.dev/sync/atomic#pkg-note-BUG
-	rx uint64
-	tx uint64
-	up time.Time
+	rx     uint64 // payload bytes
+	tx     uint64 // payload bytes
+	rxWire uint64 // bytes on the wire, differs from rx when compressed
+	txWire uint64 // bytes on the wire, differs from tx when compressed
+	up     time.Time
 	net.Conn
+	compression uint8
+	reader      io.Reader        // set by compress, nil if uncompressed
+	writer      io.Writer        // set by compress, nil if uncompressed
+	limitUp     *linkRateLimiter // nil if unlimited
+	limitDown   *linkRateLimiter // nil if unlimited
+	meter       linkRateMeter
+	frames      *linkFramer // set once the handshake is complete
 }
 
 func (c *linkConn) Read(p []byte) (n int, err error) {
-	n, err = c.Conn.Read(p)
+	if c.frames != nil {
+		n, err = c.frames.read(p)
+	} else {
+		n, err = linkStream{c}.Read(p)
+	}
 	atomic.AddUint64(&c.rx, uint64(n))
 	return
 }
 
 func (c *linkConn) Write(p []byte) (n int, err error) {
-	n, err = c.Conn.Write(p)
+	if c.frames != nil {
+		n, err = c.frames.write(p)
+	} else {
+		n, err = linkStream{c}.Write(p)
+	}
 	atomic.AddUint64(&c.tx, uint64(n))
 	return
 }
 
-func linkOptionsForListener(u *url.URL) (l linkOptions) {
+func linkOptionsForListener(u *url.URL) (l linkOptions, err error) {
 	if p := u.Query().Get("priority"); p != "" {
 		if pi, err := strconv.ParseUint(p, 10, 8); err == nil {
 			l.priority = uint8(pi)
 		}
 	}
+	l.password = []byte(u.Query().Get("password"))
+	if l.compression, err = linkCompressionFor(u.Query().Get("compress")); err != nil {
+		return
+	}
+	l.rateUp, l.rateDown, err = linkRateLimits(u.Query())
 	return
 }
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression algorithms that can be negotiated in the version metadata. The
+// zero value means no compression, which is used unless both sides ask for
+// the same algorithm, with the compress=zstd option of a peer or listener URI.
+// Traffic between nodes is already encrypted by the time it reaches a link,
+// so only the unencrypted overhead compresses: ironwood's routing headers and
+// protocol messages, and the link's own frames. Every link that uses it pays
+// for an encoder and decoder, so it's only worth it on slow links where that
+// overhead is a large share of what is sent.
+const (
+	linkCompressionNone uint8 = iota
+	linkCompressionZstd
+)
+
+func linkCompressionFor(name string) (uint8, error) {
+	switch name {
+	case "", "none":
+		return linkCompressionNone, nil
+	case "zstd":
+		return linkCompressionZstd, nil
+	default:
+		return linkCompressionNone, fmt.Errorf("unsupported compression %q", name)
+	}
+}
+
+func linkCompressionName(c uint8) string {
+	switch c {
+	case linkCompressionZstd:
+		return "zstd"
+	default:
+		return ""
+	}
+}
+
+// linkWire reads and writes the underlying connection of a linkConn, counting
+// the bytes that actually go over the wire and applying any rate limits.
+type linkWire struct {
+	c *linkConn
+}
+
+func (w linkWire) Read(p []byte) (n int, err error) {
+	n, err = w.c.Conn.Read(p)
+	atomic.AddUint64(&w.c.rxWire, uint64(n))
+	w.c.limitDown.wait(n)
+	return
+}
+
+func (w linkWire) Write(p []byte) (n int, err error) {
+	w.c.limitUp.wait(len(p))
+	n, err = w.c.Conn.Write(p)
+	atomic.AddUint64(&w.c.txWire, uint64(n))
+	return
+}
+
+// linkZstdWriter compresses each write into the stream and flushes it
+// straight away, as ironwood expects its packets to be sent when written.
+type linkZstdWriter struct {
+	mutex   sync.Mutex
+	encoder *zstd.Encoder
+}
+
+func (w *linkZstdWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	n, err := w.encoder.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, w.encoder.Flush()
+}
+
+// compress switches the connection over to the given compression algorithm.
+// It must be called after the handshake and before any other traffic. With a
+// concurrency of 1 neither the encoder nor the decoder start any goroutines,
+// so there is nothing to clean up when the link closes.
+func (c *linkConn) compress(algorithm uint8) error {
+	switch algorithm {
+	case linkCompressionNone:
+		return nil
+	case linkCompressionZstd:
+		encoder, err := zstd.NewWriter(linkWire{c},
+			zstd.WithEncoderConcurrency(1),
+			zstd.WithEncoderLevel(zstd.SpeedFastest),
+			zstd.WithWindowSize(1<<20),
+		)
+		if err != nil {
+			return err
+		}
+		decoder, err := zstd.NewReader(linkWire{c},
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxWindow(1<<20),
+		)
+		if err != nil {
+			_ = encoder.Close()
+			return err
+		}
+		c.compression = algorithm
+		c.reader = decoder
+		c.writer = &linkZstdWriter{encoder: encoder}
+		return nil
+	default:
+		return fmt.Errorf("unsupported compression %d", algorithm)
+	}
+}
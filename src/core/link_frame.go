@@ -0,0 +1,315 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Once the handshake is complete, everything sent over a link is framed so
+// that link-level control messages can be mixed in with ironwood's traffic.
+// Each frame starts with its type. Data frames are followed by a uvarint
+// length and then that many bytes of ironwood traffic, while ping and pong
+// frames carry an 8 byte value which the pong echoes back from the ping.
+// Goodbye frames have no value and are the last thing sent by a node that is
+// shutting down, so that the remote side can drop the link straight away.
+const (
+	linkFrameData uint8 = iota
+	linkFramePing
+	linkFramePong
+	linkFrameGoodbye
+)
+
+// errLinkPeerLeft is the reason a link is closed after a goodbye frame.
+var errLinkPeerLeft = errors.New("peer left")
+
+// The default time after which a link that we haven't heard anything from
+// is closed. Pings are sent three times in this period, so an idle link
+// that is still alive always has traffic.
+const defaultLinkTimeout = time.Second * 30
+
+// Pings are never sent more often than this, however short the timeout.
+const linkPingMinInterval = time.Millisecond * 100
+
+// linkStream reads and writes a linkConn below the framing, i.e. through the
+// compressor if there is one.
+type linkStream struct {
+	c *linkConn
+}
+
+func (s linkStream) Read(p []byte) (int, error) {
+	if s.c.reader != nil {
+		return s.c.reader.Read(p)
+	}
+	return linkWire{s.c}.Read(p)
+}
+
+func (s linkStream) Write(p []byte) (int, error) {
+	if s.c.writer != nil {
+		return s.c.writer.Write(p)
+	}
+	return linkWire{s.c}.Write(p)
+}
+
+// linkFramer splits ironwood's traffic into data frames and exchanges pings
+// with the remote side, to measure the round trip time and to notice when a
+// link has gone silent.
+type linkFramer struct {
+	lastSeen  int64 // unix nanoseconds, accessed atomically
+	lastWrite int64 // unix nanoseconds that the last data frame was written, accessed atomically
+	writing   int32 // data frames being written, accessed atomically
+	conn      *linkConn
+	reader    *bufio.Reader
+	remaining uint64 // bytes left to read of the current data frame
+	wmutex    sync.Mutex
+	wbuf      []byte
+	mutex     sync.Mutex // protects the fields below
+	rtt       time.Duration
+	rttMin    time.Duration
+	rttSum    time.Duration
+	rttCount  uint64
+	reason    error         // why the link was closed, if it was closed by us
+	pings     chan struct{} // to the control writer, to send a ping
+	pongs     chan [8]byte  // to the control writer, the value of a ping to echo
+	done      chan struct{}
+}
+
+func newLinkFramer(c *linkConn) *linkFramer {
+	f := &linkFramer{
+		conn:   c,
+		reader: bufio.NewReader(linkStream{c}),
+		pings:  make(chan struct{}, 1),
+		pongs:  make(chan [8]byte, 1),
+		done:   make(chan struct{}),
+	}
+	f.seen()
+	return f
+}
+
+func (f *linkFramer) seen() {
+	atomic.StoreInt64(&f.lastSeen, time.Now().UnixNano())
+}
+
+func (f *linkFramer) read(p []byte) (int, error) {
+	for f.remaining == 0 {
+		if err := f.readFrame(); err != nil {
+			return 0, f.closeReason(err)
+		}
+	}
+	if uint64(len(p)) > f.remaining {
+		p = p[:f.remaining]
+	}
+	n, err := f.reader.Read(p)
+	f.remaining -= uint64(n)
+	if n > 0 {
+		f.seen()
+	}
+	return n, f.closeReason(err)
+}
+
+// readFrame reads frame headers and handles any control frames, returning
+// once the start of a data frame has been read.
+func (f *linkFramer) readFrame() error {
+	frameType, err := f.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	f.seen()
+	switch frameType {
+	case linkFrameData:
+		if f.remaining, err = binary.ReadUvarint(f.reader); err != nil {
+			return err
+		}
+	case linkFramePing, linkFramePong:
+		var value [8]byte
+		if _, err = io.ReadFull(f.reader, value[:]); err != nil {
+			return err
+		}
+		if frameType == linkFramePing {
+			// Reply from the control writer, so that a blocked write can't
+			// stop us from reading. If a pong is already waiting then this
+			// ping goes unanswered, and the next one will be answered.
+			select {
+			case f.pongs <- value:
+			default:
+			}
+		} else {
+			f.pong(time.Duration(binary.BigEndian.Uint64(value[:])))
+		}
+	case linkFrameGoodbye:
+		f.close(errLinkPeerLeft)
+		return errLinkPeerLeft
+	default:
+		return fmt.Errorf("unknown link frame type %d", frameType)
+	}
+	return nil
+}
+
+func (f *linkFramer) write(p []byte) (int, error) {
+	atomic.AddInt32(&f.writing, 1)
+	defer func() {
+		atomic.StoreInt64(&f.lastWrite, time.Now().UnixNano())
+		atomic.AddInt32(&f.writing, -1)
+	}()
+	f.wmutex.Lock()
+	defer f.wmutex.Unlock()
+	f.wbuf = append(f.wbuf[:0], linkFrameData)
+	f.wbuf = binary.AppendUvarint(f.wbuf, uint64(len(p)))
+	header := len(f.wbuf)
+	f.wbuf = append(f.wbuf, p...)
+	n, err := linkStream{f.conn}.Write(f.wbuf)
+	if n -= header; n < 0 {
+		n = 0
+	}
+	return n, f.closeReason(err)
+}
+
+func (f *linkFramer) writeControl(frameType uint8, value [8]byte) {
+	f.wmutex.Lock()
+	defer f.wmutex.Unlock()
+	f.wbuf = append(f.wbuf[:0], frameType)
+	f.wbuf = append(f.wbuf, value[:]...)
+	_, _ = linkStream{f.conn}.Write(f.wbuf)
+}
+
+// goodbye tells the remote side that we are shutting down. It waits for any
+// write in progress, so the goodbye is never sent in the middle of a frame.
+func (f *linkFramer) goodbye() {
+	f.wmutex.Lock()
+	defer f.wmutex.Unlock()
+	f.wbuf = append(f.wbuf[:0], linkFrameGoodbye)
+	_, _ = linkStream{f.conn}.Write(f.wbuf)
+}
+
+// idle reports whether no data frame is being written, and none has been
+// for the given time. Ironwood writes the packets that it has queued for a
+// link one after another, starting the next as soon as the last returns, so
+// a link that has been idle for a moment has nothing queued.
+func (f *linkFramer) idle(d time.Duration) bool {
+	if atomic.LoadInt32(&f.writing) > 0 {
+		return false
+	}
+	return time.Since(time.Unix(0, atomic.LoadInt64(&f.lastWrite))) >= d
+}
+
+// ping sends the time since the link came up, which is echoed back to us in
+// the pong, so there is no need to remember when each ping was sent.
+func (f *linkFramer) ping() {
+	var value [8]byte
+	binary.BigEndian.PutUint64(value[:], uint64(time.Since(f.conn.up)))
+	f.writeControl(linkFramePing, value)
+}
+
+func (f *linkFramer) pong(sent time.Duration) {
+	rtt := time.Since(f.conn.up) - sent
+	if rtt < 0 {
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.rtt = rtt
+	if f.rttMin == 0 || rtt < f.rttMin {
+		f.rttMin = rtt
+	}
+	f.rttSum += rtt
+	f.rttCount++
+}
+
+// stats returns the latest, lowest and average round trip times and when we
+// last heard from the remote side.
+func (f *linkFramer) stats() (rtt, rttMin, rttAvg time.Duration, lastSeen time.Time) {
+	f.mutex.Lock()
+	rtt, rttMin = f.rtt, f.rttMin
+	if f.rttCount > 0 {
+		rttAvg = f.rttSum / time.Duration(f.rttCount)
+	}
+	f.mutex.Unlock()
+	return rtt, rttMin, rttAvg, time.Unix(0, atomic.LoadInt64(&f.lastSeen))
+}
+
+// run sends pings until stop is called, and closes the link if nothing has
+// been heard from the remote side within the timeout.
+func (f *linkFramer) run(timeout time.Duration) {
+	interval := timeout / 3
+	if interval < linkPingMinInterval {
+		interval = linkPingMinInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	go f.writeControlLoop()
+	f.pings <- struct{}{}
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+		}
+		lastSeen := time.Unix(0, atomic.LoadInt64(&f.lastSeen))
+		if silent := time.Since(lastSeen); silent > timeout {
+			f.close(fmt.Errorf("link timed out, nothing received for %s", silent.Round(time.Second)))
+			return
+		}
+		// Ping from the control writer, so that a write which is blocked on
+		// a dead link can't stop us from noticing the timeout. If the last
+		// ping hasn't been sent yet then there is no need for another.
+		select {
+		case f.pings <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// writeControlLoop sends the pings and pongs asked for by run and readFrame
+// until stop is called.
+func (f *linkFramer) writeControlLoop() {
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-f.pings:
+			f.ping()
+		case value := <-f.pongs:
+			f.writeControl(linkFramePong, value)
+		}
+	}
+}
+
+func (f *linkFramer) stop() {
+	close(f.done)
+}
+
+// close closes the link, recording the reason so that it is returned from
+// any read or write that fails as a result.
+func (f *linkFramer) close(reason error) {
+	f.mutex.Lock()
+	if f.reason == nil {
+		f.reason = reason
+	}
+	f.mutex.Unlock()
+	_ = f.conn.Conn.Close()
+}
+
+// peerLeft returns true if the link was closed because the remote side sent
+// a goodbye frame.
+func (f *linkFramer) peerLeft() bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.reason == errLinkPeerLeft
+}
+
+func (f *linkFramer) closeReason(err error) error {
+	if err == nil {
+		return nil
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.reason != nil && !errors.Is(err, f.reason) {
+		return f.reason
+	}
+	return err
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"net"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// createBlackhole forwards connections to target until its returned flag is
+// set, after which everything is silently dropped without closing anything,
+// like a link whose remote side has vanished.
+func createBlackhole(t testing.TB, target string) (string, *int32) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	var dropped int32
+	forward := func(dst, src net.Conn) {
+		buf := make([]byte, 65535)
+		for {
+			n, err := src.Read(buf)
+			if err != nil {
+				return
+			}
+			if atomic.LoadInt32(&dropped) == 0 {
+				if _, err = dst.Write(buf[:n]); err != nil {
+					return
+				}
+			}
+		}
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			remote, err := net.Dial("tcp", target)
+			if err != nil {
+				_ = conn.Close()
+				continue
+			}
+			t.Cleanup(func() {
+				_ = conn.Close()
+				_ = remote.Close()
+			})
+			go forward(remote, conn)
+			go forward(conn, remote)
+		}
+	}()
+	return listener.Addr().String(), &dropped
+}
+
+func TestLink_PingTimeout(t *testing.T) {
+	nodeA := newTestCore(t, "A: ", LinkTimeout(time.Millisecond*600))
+	nodeB := newTestCore(t, "B: ")
+
+	listener, err := nodeB.links.listen(&url.URL{Scheme: "tcp", Host: "127.0.0.1:0"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, dropped := createBlackhole(t, listener.Addr().String())
+	if err = nodeA.CallPeer(&url.URL{Scheme: "tcp", Host: addr}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("nodes did not connect")
+	}
+
+	// Give the pings a chance to measure the round trip time.
+	time.Sleep(time.Millisecond * 500)
+	peers := nodeA.GetPeers()
+	if len(peers) != 1 {
+		t.Fatalf("expected one peer, got %d", len(peers))
+	}
+	if p := peers[0]; p.RTT <= 0 || p.RTTMin <= 0 || p.RTTAvg <= 0 || time.Since(p.LastSeen) > time.Second {
+		t.Fatalf("link round trip not measured: %+v", p)
+	}
+
+	// Once the link goes silent it should be closed within the timeout.
+	atomic.StoreInt32(dropped, 1)
+	deadline := time.Now().Add(time.Second * 3)
+	for len(nodeA.GetPeers()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("silent link was not closed")
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+}
+
+// TestLink_ShortTimeout checks that a timeout too short to ping three times
+// in doesn't stop links from being set up.
+func TestLink_ShortTimeout(t *testing.T) {
+	nodeA, nodeB := newTestCores(t, LinkTimeout(time.Nanosecond))
+	listener, err := nodeA.links.listen(&url.URL{Scheme: "tcp", Host: "127.0.0.1:0"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errch := make(chan error, 1)
+	if _, err = nodeB.links.call(&url.URL{Scheme: "tcp", Host: listener.Addr().String()}, "", errch); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-errch; err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(linkPingMinInterval * 2)
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// linkListenerLimits caps the inbound connections of a listener, set from the
+// listener URI, e.g. tls://[::]:0?maxconns=500&maxperip=4&maxhandshakes=32.
+// Connections over any of the limits are closed as soon as they are accepted
+// and counted as rejected. A zero limit means unlimited. The limits apply to
+// every link type, but ws:// and wss:// connections are only accepted once the
+// HTTP upgrade, and for wss:// the TLS handshake, is done, so those aren't
+// covered by maxhandshakes. A listener without addresses of the form
+// host:port, e.g. unix://, can't use maxperip.
+type linkListenerLimits struct {
+	maxConns      uint64 // inbound links, including those still handshaking
+	maxPerIP      uint64 // inbound links from a single source address
+	maxHandshakes uint64 // handshakes in progress at once
+	mutex         sync.Mutex
+	conns         uint64
+	handshakes    uint64
+	perIP         map[string]uint64
+	rejected      uint64
+}
+
+// linkAdmission is held by an inbound link that was let in by a listener's
+// limits, and gives its place back when the handshake ends and when the link
+// goes away.
+type linkAdmission struct {
+	limits    *linkListenerLimits
+	ip        string
+	handshake sync.Once
+	release   sync.Once
+}
+
+func linkListenerLimitsFor(u *url.URL) (*linkListenerLimits, error) {
+	l := &linkListenerLimits{
+		perIP: map[string]uint64{},
+	}
+	query := u.Query()
+	for _, limit := range []struct {
+		key   string
+		value *uint64
+	}{
+		{"maxconns", &l.maxConns},
+		{"maxperip", &l.maxPerIP},
+		{"maxhandshakes", &l.maxHandshakes},
+	} {
+		if v := query.Get(limit.key); v != "" {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s invalid: %w", limit.key, err)
+			}
+			*limit.value = n
+		}
+	}
+	return l, nil
+}
+
+// check returns an error if the limits can't be enforced on a listener with
+// the given address.
+func (l *linkListenerLimits) check(addr net.Addr) error {
+	if l.maxPerIP == 0 {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(addr.String()); err != nil {
+		return fmt.Errorf("maxperip can't be used on a listener without IP addresses")
+	}
+	return nil
+}
+
+// admit counts a newly accepted connection against the limits, returning
+// false if it is over any of them and should be closed.
+func (l *linkListenerLimits) admit(addr net.Addr) (*linkAdmission, bool) {
+	if l == nil {
+		return nil, true
+	}
+	ip := addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if (l.maxConns > 0 && l.conns >= l.maxConns) ||
+		(l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP) ||
+		(l.maxHandshakes > 0 && l.handshakes >= l.maxHandshakes) {
+		l.rejected++
+		return nil, false
+	}
+	l.conns++
+	l.handshakes++
+	l.perIP[ip]++
+	return &linkAdmission{limits: l, ip: ip}, true
+}
+
+// stats returns the number of inbound links and handshakes in progress, and
+// how many connections have been rejected for being over the limits.
+func (l *linkListenerLimits) stats() (conns, handshakes, rejected uint64) {
+	if l == nil {
+		return 0, 0, 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.conns, l.handshakes, l.rejected
+}
+
+// handshakeDone is called once the handshake has finished, whether or not it
+// succeeded.
+func (a *linkAdmission) handshakeDone() {
+	if a == nil {
+		return
+	}
+	a.handshake.Do(func() {
+		a.limits.mutex.Lock()
+		a.limits.handshakes--
+		a.limits.mutex.Unlock()
+	})
+}
+
+// done is called once the link has gone away.
+func (a *linkAdmission) done() {
+	if a == nil {
+		return
+	}
+	a.handshakeDone()
+	a.release.Do(func() {
+		a.limits.mutex.Lock()
+		defer a.limits.mutex.Unlock()
+		a.limits.conns--
+		if a.limits.perIP[a.ip]--; a.limits.perIP[a.ip] == 0 {
+			delete(a.limits.perIP, a.ip)
+		}
+	})
+}
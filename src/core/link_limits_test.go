@@ -0,0 +1,104 @@
+package core
+
+import (
+	"net"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLinkLimits_Admit(t *testing.T) {
+	u, _ := url.Parse("tcp://127.0.0.1:0?maxconns=3&maxperip=2&maxhandshakes=2")
+	limits, err := linkListenerLimitsFor(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}
+	b := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1}
+
+	a1, ok := limits.admit(a)
+	if !ok {
+		t.Fatal("first connection rejected")
+	}
+	a2, ok := limits.admit(a)
+	if !ok {
+		t.Fatal("second connection rejected")
+	}
+	if _, ok = limits.admit(b); ok {
+		t.Fatal("connection over maxhandshakes admitted")
+	}
+	a1.handshakeDone()
+	a2.handshakeDone()
+	if _, ok = limits.admit(a); ok {
+		t.Fatal("connection over maxperip admitted")
+	}
+	b1, ok := limits.admit(b)
+	if !ok {
+		t.Fatal("connection from another address rejected")
+	}
+	b1.handshakeDone()
+	if _, ok = limits.admit(b); ok {
+		t.Fatal("connection over maxconns admitted")
+	}
+	a1.done()
+	a1.done()
+	if conns, handshakes, rejected := limits.stats(); conns != 2 || handshakes != 0 || rejected != 3 {
+		t.Fatalf("unexpected stats: %d conns, %d handshakes, %d rejected", conns, handshakes, rejected)
+	}
+	if _, ok = limits.admit(a); !ok {
+		t.Fatal("connection rejected after a place was given back")
+	}
+
+	u, _ = url.Parse("tcp://127.0.0.1:0?maxconns=lots")
+	if _, err = linkListenerLimitsFor(u); err == nil {
+		t.Fatal("expected an error for an invalid limit")
+	}
+}
+
+// TestLinkLimits_Listener checks that connections which never finish their
+// handshake are closed straight away once maxhandshakes is reached.
+func TestLinkLimits_Listener(t *testing.T) {
+	node := newTestCore(t, "",
+		ListenAddress("tcp://127.0.0.1:0?maxhandshakes=2"),
+	)
+	addr := node.GetListeners()[0].Address
+
+	var conns []net.Conn
+	defer func() {
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, conn)
+	}
+	_ = conns[2].SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conns[2].Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the connection over the limit to be closed")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("connection over the limit was left open")
+	}
+	if l := node.GetListeners()[0]; l.Handshakes != 2 || l.Rejected != 1 {
+		t.Fatalf("unexpected listener stats: %+v", l)
+	}
+}
+
+// TestLinkLimits_NoIP checks that maxperip is refused on listeners that have
+// no IP addresses to count by, but the other limits are not.
+func TestLinkLimits_NoIP(t *testing.T) {
+	node := newTestCore(t, "")
+	dir := t.TempDir()
+	u := &url.URL{Scheme: "unix", Path: filepath.Join(dir, "a.sock"), RawQuery: "maxperip=1"}
+	if _, err := node.links.listen(u, ""); err == nil {
+		t.Fatal("expected an error for maxperip on a unix listener")
+	}
+	u = &url.URL{Scheme: "unix", Path: filepath.Join(dir, "b.sock"), RawQuery: "maxconns=1"}
+	if _, err := node.links.listen(u, ""); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -11,15 +11,11 @@ import (
 	"strings"
 
 	"github.com/getlantern/multipath"
-
-	"github.com/Arceliar/phony"
 )
 
 type linkMPATH struct {
-	phony.Inbox
 	*links
-	listener   *net.ListenConfig
-	_listeners map[*Listener]context.CancelFunc
+	listener *net.ListenConfig
 }
 
 func (l *links) newLinkMPATH() *linkMPATH {
@@ -28,99 +24,17 @@ func (l *links) newLinkMPATH() *linkMPATH {
 		listener: &net.ListenConfig{
 			KeepAlive: -1,
 		},
-		_listeners: map[*Listener]context.CancelFunc{},
 	}
 	lt.listener.Control = lt.tcpContext
 	return lt
 }
 
-func (l *linkMPATH) dial(url *url.URL, options linkOptions, sintf string) error {
-	info := linkInfoFor("mpath", sintf, strings.SplitN(url.Host, "%", 2)[0])
-	conn, err := l.connFor(url, sintf)
-	if err != nil {
-		return err
-	}
-	name := strings.TrimRight(strings.SplitN(url.String(), "?", 2)[0], "/")
-	dial := &linkDial{
-		url:   url,
-		sintf: sintf,
-	}
-	return l.handler(dial, name, info, conn, options, false, false)
-}
-
-func (l *linkMPATH) listen(url *url.URL, sintf string) (*Listener, error) {
-	/*
-		hostport := url.Host
-		if sintf != "" {
-			if host, port, err := net.SplitHostPort(hostport); err == nil {
-				hostport = fmt.Sprintf("[%s%%%s]:%s", host, sintf, port)
-			}
-		}
-	*/
-	_, cancel := context.WithCancel(l.core.ctx)
-	listener, err := l.listenFor(url, sintf)
-	if err != nil {
-		cancel()
-		return nil, err
-	}
-	entry := &Listener{
-		Listener: listener,
-		closed:   make(chan struct{}),
-	}
-	phony.Block(l, func() {
-		l._listeners[entry] = cancel
-	})
-	l.core.log.Printf("Multipath listener started on %s", listener.Addr())
-	go func() {
-		defer phony.Block(l, func() {
-			delete(l._listeners, entry)
-		})
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				cancel()
-				break
-			}
-			raddr := conn.RemoteAddr().(*net.TCPAddr)
-			name := fmt.Sprintf("mpath://%s", raddr)
-			info := linkInfoFor("mpath", sintf, strings.SplitN(raddr.IP.String(), "%", 2)[0])
-			if err = l.handler(nil, name, info, conn, linkOptionsForListener(url), true, raddr.IP.IsLinkLocalUnicast()); err != nil {
-				l.core.log.Errorln("Failed to create inbound link:", err)
-			}
-		}
-		_ = listener.Close()
-		close(entry.closed)
-		l.core.log.Printf("Multipath listener stopped on %s", listener.Addr())
-	}()
-	return entry, nil
-}
-
-func (l *linkMPATH) handler(dial *linkDial, name string, info linkInfo, conn net.Conn, options linkOptions, incoming, force bool) error {
-	return l.links.create(
-		conn,     // connection
-		dial,     // connection URL
-		name,     // connection name
-		info,     // connection info
-		incoming, // not incoming
-		force,    // not forced
-		options,  // connection options
-	)
+func (l *linkMPATH) Dial(_ context.Context, url *url.URL, sintf string) (net.Conn, error) {
+	return l.connFor(url, sintf)
 }
 
-// Returns the address of the listener.
-//
-//nolint:unused
-func (l *linkMPATH) getAddr() *net.TCPAddr {
-	// TODO: Fix this, because this will currently only give a single address
-	// to multicast.go, which obviously is not great, but right now multicast.go
-	// doesn't have the ability to send more than one address in a packet either
-	var addr *net.TCPAddr
-	phony.Block(l, func() {
-		for listener := range l._listeners {
-			addr = listener.Addr().(*net.TCPAddr)
-		}
-	})
-	return addr
+func (l *linkMPATH) Listen(_ context.Context, url *url.URL, sintf string) (net.Listener, error) {
+	return l.listenFor(url, sintf)
 }
 
 func (l *linkMPATH) connFor(url *url.URL, sinterfaces string) (net.Conn, error) {
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+type linkQUIC struct {
+	*links
+	tcp        *linkTCP
+	tlsconfig  *tls.Config
+	quicconfig *quic.Config
+}
+
+// linkQUICStream joins a QUIC connection with the single bidirectional stream
+// that carries the link, so that the pair can be used as a net.Conn.
+type linkQUICStream struct {
+	quic.Connection
+	quic.Stream
+	pconn net.PacketConn // only set for outbound connections, which own their socket
+}
+
+func (s *linkQUICStream) Close() error {
+	err := s.Stream.Close()
+	_ = s.Connection.CloseWithError(0, "")
+	if s.pconn != nil {
+		_ = s.pconn.Close()
+	}
+	return err
+}
+
+// linkQUICListener adapts a QUIC listener to net.Listener. Each accepted
+// connection waits for the remote side to open its stream in a separate
+// goroutine so that a slow client cannot hold up the accept loop.
+type linkQUICListener struct {
+	*quic.Listener
+	pconn    net.PacketConn
+	ctx      context.Context
+	cancel   context.CancelFunc
+	ch       chan *linkQUICStream
+	denylist *denylist
+}
+
+func (l *linkQUICListener) Accept() (net.Conn, error) {
+	select {
+	case qs := <-l.ch:
+		return qs, nil
+	case <-l.ctx.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *linkQUICListener) Close() error {
+	l.cancel()
+	err := l.Listener.Close()
+	_ = l.pconn.Close()
+	return err
+}
+
+func (l *linkQUICListener) acceptLoop() {
+	for {
+		qc, err := l.Listener.Accept(l.ctx)
+		if err != nil {
+			l.cancel()
+			return
+		}
+		// QUIC has finished its handshake by now, but we can at least avoid
+		// waiting for a stream and doing our own handshake.
+		if l.denylist.deniedAddr(qc.RemoteAddr()) {
+			_ = qc.CloseWithError(0, "")
+			continue
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(l.ctx, time.Second*6)
+			defer cancel()
+			qs, err := qc.AcceptStream(ctx)
+			if err != nil {
+				_ = qc.CloseWithError(0, "")
+				return
+			}
+			select {
+			case l.ch <- &linkQUICStream{Connection: qc, Stream: qs}:
+			case <-l.ctx.Done():
+				_ = qc.CloseWithError(0, "")
+			}
+		}()
+	}
+}
+
+func (l *links) newLinkQUIC(tls *linkTLS, tcp *linkTCP) *linkQUIC {
+	lt := &linkQUIC{
+		links:     l,
+		tcp:       tcp,
+		tlsconfig: tls.config.Clone(),
+		quicconfig: &quic.Config{
+			MaxIdleTimeout:  time.Minute,
+			KeepAlivePeriod: time.Second * 20,
+		},
+	}
+	lt.tlsconfig.NextProtos = []string{"riv-mesh"}
+	return lt
+}
+
+func (l *linkQUIC) Dial(ctx context.Context, url *url.URL, sintf string) (net.Conn, error) {
+	addr, err := net.ResolveUDPAddr("udp", url.Host)
+	if err != nil {
+		return nil, err
+	}
+	// Reuse the TCP dialer logic to validate link-local zones and to pick a
+	// suitable source address when a source interface has been given.
+	dst := &net.TCPAddr{IP: addr.IP, Port: addr.Port, Zone: addr.Zone}
+	dialer, err := l.tcp.dialerFor(dst, sintf)
+	if err != nil {
+		return nil, err
+	}
+	addr.Zone = dst.Zone
+	lc := &net.ListenConfig{}
+	laddr := ":0"
+	if sintf != "" {
+		lc.Control = dialer.Control
+		if src, ok := dialer.LocalAddr.(*net.TCPAddr); ok {
+			laddr = (&net.UDPAddr{IP: src.IP, Zone: src.Zone}).String()
+		}
+	}
+	pconn, err := lc.ListenPacket(ctx, "udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+	tlsconfig := l.tlsconfig.Clone()
+	tlsconfig.ServerName = tlsServerNameFor(url)
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+	qc, err := quic.Dial(ctx, pconn, addr, tlsconfig, l.quicconfig)
+	if err != nil {
+		_ = pconn.Close()
+		return nil, err
+	}
+	qs, err := qc.OpenStreamSync(ctx)
+	if err != nil {
+		_ = qc.CloseWithError(0, "")
+		_ = pconn.Close()
+		return nil, err
+	}
+	conn := &linkQUICStream{
+		Connection: qc,
+		Stream:     qs,
+		pconn:      pconn,
+	}
+	return conn, nil
+}
+
+func (l *linkQUIC) Listen(ctx context.Context, url *url.URL, sintf string) (net.Listener, error) {
+	lc := &net.ListenConfig{}
+	pconn, err := lc.ListenPacket(ctx, "udp", listenHostPort(url, sintf))
+	if err != nil {
+		return nil, err
+	}
+	ql, err := quic.Listen(pconn, l.tlsconfig, l.quicconfig)
+	if err != nil {
+		_ = pconn.Close()
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	listener := &linkQUICListener{
+		Listener: ql,
+		pconn:    pconn,
+		ctx:      ctx,
+		cancel:   cancel,
+		ch:       make(chan *linkQUICStream),
+		denylist: &l.core.denylist,
+	}
+	go listener.acceptLoop()
+	return listener, nil
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// parseRate parses a bandwidth such as "512kbit" or "2mbit" into bytes per
+// second. A number without a unit is taken to be in bits per second.
+func parseRate(rate string) (uint64, error) {
+	s := strings.ToLower(strings.TrimSpace(rate))
+	multiplier := uint64(1)
+	for _, unit := range []struct {
+		suffix     string
+		multiplier uint64
+	}{
+		{"kbit", 1000},
+		{"mbit", 1000 * 1000},
+		{"gbit", 1000 * 1000 * 1000},
+		{"bit", 1},
+	} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s, multiplier = strings.TrimSuffix(s, unit.suffix), unit.multiplier
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf("%q is not a positive rate, e.g. 512kbit or 2mbit", rate)
+	}
+	return uint64(f*float64(multiplier)) / 8, nil
+}
+
+// linkRateLimits reads the ratelimit, ratelimit_up and ratelimit_down options
+// from a peer or listener URI, returning the upload and download limits in
+// bytes per second. Zero means unlimited.
+func linkRateLimits(query map[string][]string) (up, down uint64, err error) {
+	get := func(key string) string {
+		if v := query[key]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	if v := get("ratelimit"); v != "" {
+		if up, err = parseRate(v); err != nil {
+			return 0, 0, fmt.Errorf("ratelimit invalid: %w", err)
+		}
+		down = up
+	}
+	if v := get("ratelimit_up"); v != "" {
+		if up, err = parseRate(v); err != nil {
+			return 0, 0, fmt.Errorf("ratelimit_up invalid: %w", err)
+		}
+	}
+	if v := get("ratelimit_down"); v != "" {
+		if down, err = parseRate(v); err != nil {
+			return 0, 0, fmt.Errorf("ratelimit_down invalid: %w", err)
+		}
+	}
+	return up, down, nil
+}
+
+// linkRateLimiter is a token bucket. Tokens are bytes, and a caller may take
+// more than are available, in which case it sleeps until the debt has been
+// paid off. This lets writes larger than the bucket through while still
+// keeping to the rate on average.
+type linkRateLimiter struct {
+	throttled int64 // nanoseconds spent waiting, accessed atomically
+	mutex     sync.Mutex
+	rate      float64 // bytes per second
+	burst     float64 // the most tokens that can build up
+	tokens    float64
+	last      time.Time
+}
+
+func newLinkRateLimiter(rate uint64) *linkRateLimiter {
+	if rate == 0 {
+		return nil
+	}
+	burst := float64(rate) / 4 // 250ms worth of traffic
+	if burst < 1500 {
+		burst = 1500
+	}
+	return &linkRateLimiter{
+		rate:   float64(rate),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// wait takes n tokens from the bucket, blocking for as long as is needed to
+// keep to the rate.
+func (r *linkRateLimiter) wait(n int) {
+	if r == nil || n <= 0 {
+		return
+	}
+	r.mutex.Lock()
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+	r.last = now
+	r.tokens -= float64(n)
+	var delay time.Duration
+	if r.tokens < 0 {
+		delay = time.Duration(-r.tokens / r.rate * float64(time.Second))
+	}
+	r.mutex.Unlock()
+	if delay > 0 {
+		atomic.AddInt64(&r.throttled, int64(delay))
+		time.Sleep(delay)
+	}
+}
+
+func (r *linkRateLimiter) throttledFor() time.Duration {
+	if r == nil {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64(&r.throttled))
+}
+
+// linkRateMeter works out the current throughput of a link from its byte
+// counters. The rate is recalculated at most once a second, however often
+// it is asked for, and is otherwise the rate over the last sample. The last
+// sample time must be set to when the link came up.
+type linkRateMeter struct {
+	mutex  sync.Mutex
+	last   time.Time
+	rx, tx uint64
+	rxRate uint64
+	txRate uint64
+}
+
+func (m *linkRateMeter) sample(rx, tx uint64) (rxRate, txRate uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	if elapsed := now.Sub(m.last).Seconds(); elapsed >= 1 {
+		m.rxRate = uint64(float64(rx-m.rx) / elapsed)
+		m.txRate = uint64(float64(tx-m.tx) / elapsed)
+		m.last, m.rx, m.tx = now, rx, tx
+	}
+	return m.rxRate, m.txRate
+}
@@ -0,0 +1,58 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLink_ParseRate(t *testing.T) {
+	for s, expect := range map[string]uint64{
+		"8":       1,
+		"800bit":  100,
+		"512kbit": 64000,
+		"2mbit":   250000,
+		"1.5Mbit": 187500,
+		"1gbit":   125000000,
+	} {
+		if rate, err := parseRate(s); err != nil || rate != expect {
+			t.Fatalf("parseRate(%q) = %d, %v; expected %d", s, rate, err, expect)
+		}
+	}
+	for _, s := range []string{"", "fast", "-1mbit", "0", "mbit"} {
+		if _, err := parseRate(s); err == nil {
+			t.Fatalf("parseRate(%q) should have failed", s)
+		}
+	}
+}
+
+func TestLink_RateLimits(t *testing.T) {
+	up, down, err := linkRateLimits(map[string][]string{
+		"ratelimit":      {"8mbit"},
+		"ratelimit_down": {"16mbit"},
+	})
+	if err != nil || up != 1000000 || down != 2000000 {
+		t.Fatalf("unexpected limits %d/%d, %v", up, down, err)
+	}
+	if _, _, err = linkRateLimits(map[string][]string{"ratelimit_up": {"lots"}}); err == nil {
+		t.Fatal("expected an error for an invalid rate")
+	}
+}
+
+func TestLink_RateLimiter(t *testing.T) {
+	var r *linkRateLimiter
+	r.wait(1 << 20) // a nil limiter is unlimited
+	if r.throttledFor() != 0 {
+		t.Fatal("nil limiter should never throttle")
+	}
+
+	r = newLinkRateLimiter(400000)
+	start := time.Now()
+	r.wait(100000) // the whole burst, so no wait
+	r.wait(200000) // 200000 bytes of debt at 400000 bytes per second
+	if elapsed := time.Since(start); elapsed < time.Millisecond*450 {
+		t.Fatalf("expected to be throttled for 500ms, only took %s", elapsed)
+	}
+	if throttled := r.throttledFor(); throttled < time.Millisecond*450 || throttled > time.Millisecond*550 {
+		t.Fatalf("expected 500ms of throttling to be counted, got %s", throttled)
+	}
+}
@@ -5,58 +5,44 @@ package core
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 	"net"
 	"net/url"
 	"strconv"
 	"strings"
 
-	"github.com/Arceliar/phony"
 	sctp "github.com/vikulin/sctp"
 )
 
 type linkSCTP struct {
-	phony.Inbox
 	*links
-	listener   *net.ListenConfig
-	_listeners map[*Listener]context.CancelFunc
 }
 
 func (l *links) newLinkSCTP() *linkSCTP {
 	lt := &linkSCTP{
 		links: l,
-		listener: &net.ListenConfig{
-			KeepAlive: -1,
-		},
-		_listeners: map[*Listener]context.CancelFunc{},
 	}
 	return lt
 }
 
-func (l *linkSCTP) dial(url *url.URL, options linkOptions, sintf string) error {
-	info := linkInfoFor("sctp", sintf, strings.SplitN(url.Host, "%", 2)[0])
-	if l.links.isConnectedTo(info) {
-		return nil
-	}
+func (l *linkSCTP) Dial(_ context.Context, url *url.URL, sintf string) (net.Conn, error) {
 	host, port, err := net.SplitHostPort(url.Host)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	dst, err := net.ResolveIPAddr("ip", host)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	raddress := l.getAddress(dst.String() + ":" + port)
 	var conn net.Conn
 	laddress := l.getAddress("0.0.0.0:0")
 	conn, err = sctp.NewSCTPConnection(laddress, laddress.AddressFamily, sctp.InitMsg{NumOstreams: 2, MaxInstreams: 2, MaxAttempts: 2, MaxInitTimeout: 5}, sctp.OneToOne, false)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	err = conn.(*sctp.SCTPConn).Connect(raddress)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	//conn.(*sctp.SCTPConn).SetWriteBuffer(324288)
 	//conn.(*sctp.SCTPConn).SetReadBuffer(324288)
@@ -67,17 +53,12 @@ func (l *linkSCTP) dial(url *url.URL, options linkOptions, sintf string) error {
 	//l.core.log.Printf("Write buffer %d", wbuf)
 	err = conn.(*sctp.SCTPConn).SetEvents(sctp.SCTP_EVENT_DATA_IO)
 	if err != nil {
-		return err
-	}
-	dial := &linkDial{
-		url:   url,
-		sintf: sintf,
+		return nil, err
 	}
-	return l.handler(dial, url.String(), info, conn, options, false, false)
+	return conn, nil
 }
 
-func (l *linkSCTP) listen(url *url.URL, sintf string) (*Listener, error) {
-	//_, cancel := context.WithCancel(l.core.ctx)
+func (l *linkSCTP) Listen(_ context.Context, url *url.URL, sintf string) (net.Listener, error) {
 	/*
 		hostport := url.Host
 		if sintf != "" {
@@ -88,84 +69,39 @@ func (l *linkSCTP) listen(url *url.URL, sintf string) (*Listener, error) {
 	*/
 	addr := l.getAddress(url.Host)
 	listener, err := sctp.NewSCTPListener(addr, sctp.InitMsg{NumOstreams: 2, MaxInstreams: 2, MaxAttempts: 2, MaxInitTimeout: 5}, sctp.OneToOne, false)
-
 	if err != nil {
-		//cancel()
 		return nil, err
 	}
 	err = listener.SetEvents(sctp.SCTP_EVENT_DATA_IO)
 	if err != nil {
+		_ = listener.Close()
 		return nil, err
 	}
-	entry := &Listener{
-		Listener: listener,
-		closed:   make(chan struct{}),
-	}
-	//phony.Block(l, func() {
-	//	l._listeners[entry] = cancel
-	//})
-	l.core.log.Printf("SCTP listener started on %s", listener.Addr())
-	go func() {
-		defer phony.Block(l, func() {
-			delete(l._listeners, entry)
-		})
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				//cancel()
-				break
-			}
-			addr := conn.RemoteAddr().(*sctp.SCTPAddr)
-			ips, err := json.Marshal(addr.IPAddrs)
-			if err != nil {
-				break
-			}
-			name := fmt.Sprintf("sctp://%s", ips)
-			info := linkInfoFor("sctp", sintf, string(ips))
-			//conn.(*sctp.SCTPConn).SetWriteBuffer(324288)
-			//conn.(*sctp.SCTPConn).SetReadBuffer(324288)
-			wbuf, _ := conn.(*sctp.SCTPConn).GetWriteBuffer()
-			rbuf, _ := conn.(*sctp.SCTPConn).GetReadBuffer()
-
-			l.core.log.Printf("Read buffer %d", rbuf)
-			l.core.log.Printf("Write buffer %d", wbuf)
-			if err = l.handler(nil, name, info, conn, linkOptionsForListener(url), true, addr.IPAddrs[0].IP.IsLinkLocalUnicast()); err != nil {
-				l.core.log.Errorln("Failed to create inbound link:", err)
-			}
-		}
-		_ = listener.Close()
-		close(entry.closed)
-		l.core.log.Printf("SCTP listener stopped on %s", listener.Addr())
-	}()
-	return entry, nil
+	return &linkSCTPListener{listener, &l.core.denylist}, nil
 }
 
-func (l *linkSCTP) handler(dial *linkDial, name string, info linkInfo, conn net.Conn, options linkOptions, incoming, force bool) error {
-	return l.links.create(
-		conn,     // connection
-		dial,     // connection URL
-		name,     // connection name
-		info,     // connection info
-		incoming, // not incoming
-		force,    // not forced
-		options,  // connection options
-	)
+// linkSCTPListener drops connections if any of the remote addresses are in a
+// denied network, as an association can have more than one.
+type linkSCTPListener struct {
+	*sctp.SCTPListener
+	denylist *denylist
 }
 
-// Returns the address of the listener.
-//
-//nolint:unused
-func (l *linkSCTP) getAddr() *net.TCPAddr {
-	// TODO: Fix this, because this will currently only give a single address
-	// to multicast.go, which obviously is not great, but right now multicast.go
-	// doesn't have the ability to send more than one address in a packet either
-	var addr *net.TCPAddr
-	phony.Block(l, func() {
-		for listener := range l._listeners {
-			addr = listener.Addr().(*net.TCPAddr)
+func (l *linkSCTPListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.SCTPListener.Accept()
+		if err != nil {
+			return nil, err
 		}
-	})
-	return addr
+		denied := false
+		for _, ip := range conn.RemoteAddr().(*sctp.SCTPAddr).IPAddrs {
+			denied = denied || l.denylist.deniedIP(ip.IP)
+		}
+		if !denied {
+			return conn, nil
+		}
+		_ = conn.Close()
+	}
 }
 
 // SCTP infrastructure
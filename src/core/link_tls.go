@@ -9,23 +9,17 @@ import (
 	"crypto/x509/pkix"
 	"encoding/hex"
 	"encoding/pem"
-	"fmt"
 	"math/big"
 	"net"
 	"net/url"
-	"strings"
 	"time"
-
-	"github.com/Arceliar/phony"
 )
 
 type linkTLS struct {
-	phony.Inbox
 	*links
-	tcp        *linkTCP
-	listener   *net.ListenConfig
-	config     *tls.Config
-	_listeners map[*Listener]context.CancelFunc
+	tcp      *linkTCP
+	listener *net.ListenConfig
+	config   *tls.Config
 }
 
 func (l *links) newLinkTLS(tcp *linkTCP) *linkTLS {
@@ -36,7 +30,6 @@ func (l *links) newLinkTLS(tcp *linkTCP) *linkTLS {
 			Control:   tcp.tcpContext,
 			KeepAlive: -1,
 		},
-		_listeners: map[*Listener]context.CancelFunc{},
 	}
 	var err error
 	lt.config, err = lt.generateConfig()
@@ -46,82 +39,52 @@ func (l *links) newLinkTLS(tcp *linkTCP) *linkTLS {
 	return lt
 }
 
-func (l *linkTLS) dial(url *url.URL, options linkOptions, sintf, sni string) error {
+func (l *linkTLS) Dial(ctx context.Context, url *url.URL, sintf string) (net.Conn, error) {
 	addr, err := net.ResolveTCPAddr("tcp", url.Host)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	dialer, err := l.tcp.dialerFor(addr, sintf)
 	if err != nil {
-		return err
-	}
-	info := linkInfoFor("tls", sintf, tcpIDFor(dialer.LocalAddr, addr))
-	if l.links.isConnectedTo(info) {
-		return nil
+		return nil, err
 	}
 	tlsconfig := l.config.Clone()
-	tlsconfig.ServerName = sni
+	tlsconfig.ServerName = tlsServerNameFor(url)
 	tlsdialer := &tls.Dialer{
 		NetDialer: dialer,
 		Config:    tlsconfig,
 	}
-	conn, err := tlsdialer.DialContext(l.core.ctx, "tcp", addr.String())
+	return tlsdialer.DialContext(ctx, "tcp", addr.String())
+}
+
+// Listen returns connections before the TLS handshake, which doesn't start
+// until the first read or write, so that connections from denied networks
+// or over the listener limits are dropped before any work is done for them.
+func (l *linkTLS) Listen(ctx context.Context, url *url.URL, sintf string) (net.Listener, error) {
+	listener, err := l.listener.Listen(ctx, "tcp", listenHostPort(url, sintf))
 	if err != nil {
-		return err
-	}
-	name := strings.TrimRight(strings.SplitN(url.String(), "?", 2)[0], "/")
-	dial := &linkDial{
-		url:   url,
-		sintf: sintf,
+		return nil, err
 	}
-	return l.handler(dial, name, info, conn, options, false, false)
+	return tls.NewListener(listener, l.config), nil
 }
 
-func (l *linkTLS) listen(url *url.URL, sintf string) (*Listener, error) {
-	ctx, cancel := context.WithCancel(l.core.ctx)
-	hostport := url.Host
-	if sintf != "" {
-		if host, port, err := net.SplitHostPort(hostport); err == nil {
-			hostport = fmt.Sprintf("[%s%%%s]:%s", host, sintf, port)
+// tlsServerNameFor returns the SNI to use when dialling the given peering URI.
+func tlsServerNameFor(u *url.URL) string {
+	// SNI headers must contain hostnames and not IP addresses, so we must make sure
+	// that we do not populate the SNI with an IP literal. We do this by splitting
+	// the host-port combo from the query option and then seeing if it parses to an
+	// IP address successfully or not.
+	if sni := u.Query().Get("sni"); sni != "" {
+		if net.ParseIP(sni) == nil {
+			return sni
 		}
 	}
-	listener, err := l.listener.Listen(ctx, "tcp", hostport)
-	if err != nil {
-		cancel()
-		return nil, err
-	}
-	tlslistener := tls.NewListener(listener, l.config)
-	entry := &Listener{
-		Listener: tlslistener,
-		closed:   make(chan struct{}),
+	// If the SNI is not configured still because the above failed then we'll try
+	// again but this time we'll use the host part of the peering URI instead.
+	if host, _, err := net.SplitHostPort(u.Host); err == nil && net.ParseIP(host) == nil {
+		return host
 	}
-	phony.Block(l, func() {
-		l._listeners[entry] = cancel
-	})
-	l.core.log.Printf("TLS listener started on %s", listener.Addr())
-	go func() {
-		defer phony.Block(l, func() {
-			delete(l._listeners, entry)
-		})
-		for {
-			conn, err := tlslistener.Accept()
-			if err != nil {
-				cancel()
-				break
-			}
-			laddr := conn.LocalAddr().(*net.TCPAddr)
-			raddr := conn.RemoteAddr().(*net.TCPAddr)
-			name := fmt.Sprintf("tls://%s", raddr)
-			info := linkInfoFor("tls", sintf, tcpIDFor(laddr, raddr))
-			if err = l.handler(nil, name, info, conn, linkOptionsForListener(url), true, raddr.IP.IsLinkLocalUnicast()); err != nil {
-				l.core.log.Errorln("Failed to create inbound link:", err)
-			}
-		}
-		_ = tlslistener.Close()
-		close(entry.closed)
-		l.core.log.Printf("TLS listener stopped on %s", listener.Addr())
-	}()
-	return entry, nil
+	return ""
 }
 
 // RFC5280 section 4.1.2.5
@@ -168,7 +131,3 @@ func (l *linkTLS) generateConfig() (*tls.Config, error) {
 		MinVersion:         tls.VersionTLS13,
 	}, nil
 }
-
-func (l *linkTLS) handler(dial *linkDial, name string, info linkInfo, conn net.Conn, options linkOptions, incoming, force bool) error {
-	return l.tcp.handler(dial, name, info, conn, options, incoming, force)
-}
@@ -0,0 +1,158 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/Arceliar/phony"
+)
+
+// LinkTransport lets an application add its own type of link, e.g. over a
+// radio or in-memory pipes in tests. Once registered with RegisterTransport,
+// peer and listener URIs with the transport's scheme are dialled and listened
+// on through it. The connections it returns carry the usual handshake and
+// link traffic, so they only need to be reliable and ordered, like TCP. The
+// built-in transports are implemented the same way.
+type LinkTransport interface {
+	// Dial connects to the node at the given peer URI, using the source
+	// interface if one was given. The context is cancelled when the node
+	// shuts down.
+	Dial(ctx context.Context, u *url.URL, sintf string) (net.Conn, error)
+	// Listen accepts connections for the given listener URI. The listener is
+	// closed when it is removed or the node shuts down.
+	Listen(ctx context.Context, u *url.URL, sintf string) (net.Listener, error)
+}
+
+// RegisterTransport adds a link transport for URIs with the given scheme,
+// replacing the built-in transport if there is one for the scheme.
+func RegisterTransport(scheme string, t LinkTransport) SetupOption {
+	return transportOption{strings.ToLower(scheme), t}
+}
+
+type transportOption struct {
+	scheme    string
+	transport LinkTransport
+}
+
+func (a transportOption) isSetupOption() {}
+
+// linkTransport runs the link handler over the connections of a transport,
+// whether built in or registered, and keeps track of its listeners.
+type linkTransport struct {
+	phony.Inbox
+	*links
+	scheme     string
+	name       string // used in log messages, e.g. "TLS"
+	transport  LinkTransport
+	_listeners map[*Listener]context.CancelFunc
+}
+
+// registerTransport makes the transport available for URIs with the given
+// scheme, replacing any that was there already.
+func (l *links) registerTransport(scheme, name string, t LinkTransport) {
+	l.schemes[scheme] = &linkTransport{
+		links:      l,
+		scheme:     scheme,
+		name:       name,
+		transport:  t,
+		_listeners: map[*Listener]context.CancelFunc{},
+	}
+}
+
+// dial returns once the connection has been handed to the link handler, and
+// dialling a link that is already up does nothing.
+func (l *linkTransport) dial(u *url.URL, options linkOptions, sintf string) error {
+	info := linkInfoForURL(u, sintf)
+	if l.links.isConnectedTo(info) {
+		return nil
+	}
+	conn, err := l.transport.Dial(l.core.ctx, u, sintf)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimRight(strings.SplitN(u.Redacted(), "?", 2)[0], "/")
+	dial := &linkDial{
+		url:   u,
+		sintf: sintf,
+	}
+	return l.links.create(conn, dial, name, info, false, false, options)
+}
+
+func (l *linkTransport) listen(u *url.URL, sintf string) (*Listener, error) {
+	limits, err := linkListenerLimitsFor(u)
+	if err != nil {
+		return nil, err
+	}
+	listenOptions, err := linkOptionsForListener(u)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(l.core.ctx)
+	listener, err := l.transport.Listen(ctx, u, sintf)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err = limits.check(listener.Addr()); err != nil {
+		_ = listener.Close()
+		cancel()
+		return nil, err
+	}
+	entry := &Listener{
+		Listener: listener,
+		closed:   make(chan struct{}),
+		limits:   limits,
+	}
+	phony.Block(l, func() {
+		l._listeners[entry] = cancel
+	})
+	l.core.log.Printf("%s listener started on %s", l.name, listener.Addr())
+	go func() {
+		defer phony.Block(l, func() {
+			delete(l._listeners, entry)
+		})
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				cancel()
+				break
+			}
+			raddr := conn.RemoteAddr()
+			if l.core.denylist.deniedAddr(raddr) {
+				_ = conn.Close()
+				continue
+			}
+			options := listenOptions
+			var admitted bool
+			if options.admission, admitted = limits.admit(raddr); !admitted {
+				_ = conn.Close()
+				continue
+			}
+			// Link-local peers are found by multicast, so they are let in
+			// even if they aren't in AllowedPublicKeys.
+			ip := ipForAddr(raddr)
+			force := ip != nil && ip.IsLinkLocalUnicast()
+			name := fmt.Sprintf("%s://%s", l.scheme, raddr)
+			info := linkInfoForAddr(l.scheme, sintf, conn.LocalAddr(), raddr)
+			if err = l.links.create(conn, nil, name, info, true, force, options); err != nil {
+				l.core.log.Errorln("Failed to create inbound link:", err)
+			}
+		}
+		_ = listener.Close()
+		close(entry.closed)
+		l.core.log.Printf("%s listener stopped on %s", l.name, listener.Addr())
+	}()
+	return entry, nil
+}
+
+// shutdown closes all of the listeners.
+func (l *linkTransport) shutdown() {
+	phony.Block(l, func() {
+		for listener := range l._listeners {
+			_ = listener.Close()
+		}
+	})
+}
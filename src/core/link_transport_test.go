@@ -0,0 +1,89 @@
+package core
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// loopbackTransport is a LinkTransport that carries links over TCP on the
+// loopback address, remembering the listener address under the URI host so
+// that peer URIs don't need to know the port.
+type loopbackTransport struct {
+	mutex sync.Mutex
+	addrs map[string]string
+}
+
+func (t *loopbackTransport) Dial(ctx context.Context, u *url.URL, _ string) (net.Conn, error) {
+	t.mutex.Lock()
+	addr := t.addrs[u.Host]
+	t.mutex.Unlock()
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+func (t *loopbackTransport) Listen(ctx context.Context, u *url.URL, _ string) (net.Listener, error) {
+	var lc net.ListenConfig
+	l, err := lc.Listen(ctx, "tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	t.mutex.Lock()
+	t.addrs[u.Host] = l.Addr().String()
+	t.mutex.Unlock()
+	return l, nil
+}
+
+func TestLinkTransport_Register(t *testing.T) {
+	var err error
+	transport := &loopbackTransport{addrs: map[string]string{}}
+	nodeA := newTestCore(t, "A: ",
+		RegisterTransport("loop", transport),
+		ListenAddress("loop://a"),
+	)
+	nodeB := newTestCore(t, "B: ",
+		RegisterTransport("loop", transport),
+		Peer{URI: "loop://a"},
+	)
+
+	if listeners := nodeA.GetListeners(); len(listeners) != 1 || listeners[0].Address == "" {
+		t.Fatalf("registered transport did not listen: %+v", listeners)
+	}
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("could not connect over registered transport")
+	}
+	if peers := nodeB.GetPeers(); len(peers) != 1 || peers[0].Remote != "loop://a" {
+		t.Fatalf("unexpected peers: %+v", peers)
+	}
+	u, _ := url.Parse("nope://a")
+	if err = nodeB.CallPeer(u, ""); err == nil {
+		t.Fatal("expected an error for an unregistered scheme")
+	}
+}
+
+// TestLinkTransport_InfoForURL checks how outbound links are identified, which
+// decides whether two peer URIs share a link.
+func TestLinkTransport_InfoForURL(t *testing.T) {
+	for _, tc := range []struct {
+		uri, remote string
+	}{
+		{"tcp://1.2.3.4:5", "1.2.3.4:5"},
+		{"tls://1.2.3.4:5?key=00&priority=1", "1.2.3.4:5"},
+		{"tcp://[2001:db8::1]:5", "[2001:db8::1]:5"},
+		{"tls://[fe80::1%25eth0]:5", "fe80::1"},
+		{"tls://example.com:443", "example.com:443"},
+		{"socks://127.0.0.1:1080/1.2.3.4:5", "127.0.0.1:1080/1.2.3.4:5"},
+		{"unix:///run/mesh.sock", "/run/mesh.sock"},
+	} {
+		u, err := url.Parse(tc.uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info := linkInfoForURL(u, "eth0")
+		if info.remote != tc.remote || info.linkType != u.Scheme || info.local != "eth0" {
+			t.Fatalf("%s: unexpected link info %+v, expected remote %q", tc.uri, info, tc.remote)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// The largest message ironwood will write, with plenty of room to spare.
+const wsReadLimit = 1024 * 1024
+
+type linkWS struct {
+	*links
+	tcp   *linkTCP
+	tls   *linkTLS
+	proxy *linkHTTPProxy
+}
+
+// linkWSConn replaces the placeholder addresses that websocket.NetConn
+// reports with those of the underlying TCP connection.
+type linkWSConn struct {
+	net.Conn
+	laddr net.Addr
+	raddr net.Addr
+}
+
+func (c *linkWSConn) LocalAddr() net.Addr {
+	return c.laddr
+}
+
+func (c *linkWSConn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+func (l *links) newLinkWS(tls *linkTLS, tcp *linkTCP, proxy *linkHTTPProxy) *linkWS {
+	lt := &linkWS{
+		links: l,
+		tcp:   tcp,
+		tls:   tls,
+		proxy: proxy,
+	}
+	return lt
+}
+
+// Outbound WebSocket peerings use the proxy given by the "proxy" query
+// parameter if present, otherwise the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// environment variables are honoured. Both ws:// and wss:// peerings are
+// tunnelled through the proxy using CONNECT, so only http:// proxies can be
+// used.
+func (l *linkWS) Dial(ctx context.Context, u *url.URL, sintf string) (net.Conn, error) {
+	proxy, err := wsProxyFor(u)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{
+		Timeout:   time.Second * 5,
+		KeepAlive: -1,
+		Control:   l.tcp.tcpContext,
+	}
+	if sintf != "" {
+		dialer.Control = l.tcp.getControl(sintf)
+	}
+	var laddr, raddr net.Addr
+	tlsconfig := l.tls.config.Clone()
+	tlsconfig.ServerName = tlsServerNameFor(u)
+	transport := &http.Transport{
+		TLSClientConfig: tlsconfig,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			target := addr
+			if proxy != nil {
+				addr = proxy.Host
+			}
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			laddr, raddr = conn.LocalAddr(), conn.RemoteAddr()
+			if proxy != nil {
+				if conn, err = l.proxy.connect(conn, proxy.User, target); err != nil {
+					_ = conn.Close()
+					return nil, err
+				}
+			}
+			return conn, nil
+		},
+	}
+	wsurl := *u
+	wsurl.RawQuery = ""
+	dialctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	wsconn, _, err := websocket.Dial(dialctx, wsurl.String(), &websocket.DialOptions{
+		HTTPClient:      &http.Client{Transport: transport},
+		Subprotocols:    []string{"riv-mesh"},
+		CompressionMode: websocket.CompressionDisabled,
+	})
+	if err != nil {
+		return nil, err
+	}
+	wsconn.SetReadLimit(wsReadLimit)
+	conn := &linkWSConn{
+		Conn:  websocket.NetConn(ctx, wsconn, websocket.MessageBinary),
+		laddr: laddr,
+		raddr: raddr,
+	}
+	return conn, nil
+}
+
+// wsProxyFor returns the HTTP proxy to reach the peer through, or nil if the
+// peer should be dialled directly.
+func wsProxyFor(u *url.URL) (*url.URL, error) {
+	var proxy *url.URL
+	var err error
+	if p := u.Query().Get("proxy"); p != "" {
+		proxy, err = url.Parse(p)
+	} else {
+		scheme := "http"
+		if u.Scheme == "wss" {
+			scheme = "https"
+		}
+		proxy, err = http.ProxyFromEnvironment(&http.Request{
+			URL: &url.URL{Scheme: scheme, Host: u.Host},
+		})
+	}
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("proxy invalid: %w", err)
+	case proxy == nil:
+		return nil, nil
+	case proxy.Scheme != "http":
+		return nil, fmt.Errorf("proxy scheme %q not supported, only http proxies can be used", proxy.Scheme)
+	}
+	p := *proxy
+	if p.Port() == "" {
+		p.Host = net.JoinHostPort(p.Hostname(), "80")
+	}
+	return &p, nil
+}
+
+// linkWSListener hands out the connections that the HTTP server has
+// upgraded to WebSocket.
+type linkWSListener struct {
+	net.Listener // the TCP listener, which the HTTP server serves on
+	server       *http.Server
+	ctx          context.Context
+	cancel       context.CancelFunc
+	ch           chan net.Conn
+}
+
+func (l *linkWSListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.ch:
+		return conn, nil
+	case <-l.ctx.Done():
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *linkWSListener) Close() error {
+	l.cancel()
+	return l.server.Close()
+}
+
+// Browsers and other clients that send an Origin header are only accepted if
+// it matches the listener's own host, or one of the patterns given with the
+// "origin" query parameter, e.g. ws://[::]:1234?origin=*.example.com
+func (l *linkWS) Listen(ctx context.Context, url *url.URL, sintf string) (net.Listener, error) {
+	listener, err := l.tcp.listener.Listen(ctx, "tcp", listenHostPort(url, sintf))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	wsl := &linkWSListener{
+		Listener: listener,
+		ctx:      ctx,
+		cancel:   cancel,
+		ch:       make(chan net.Conn),
+	}
+	// The HTTP server does the TLS and websocket work before the connection
+	// is accepted, so connections from denied networks are dropped as they
+	// are accepted from TCP instead. The listener limits are only applied
+	// once the upgrade is done, see linkListenerLimits.
+	listener = &linkDenyListener{listener, &l.core.denylist}
+	if url.Scheme == "wss" {
+		listener = tls.NewListener(listener, l.tls.config)
+	}
+	origins := url.Query()["origin"]
+	path := url.Path
+	if path == "" {
+		path = "/"
+	}
+	wsl.server = &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != path {
+				http.NotFound(w, r)
+				return
+			}
+			wsconn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+				Subprotocols:    []string{"riv-mesh"},
+				OriginPatterns:  origins,
+				CompressionMode: websocket.CompressionDisabled,
+			})
+			if err != nil {
+				return
+			}
+			wsconn.SetReadLimit(wsReadLimit)
+			laddr, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+			raddr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+			if err != nil || laddr == nil {
+				_ = wsconn.Close(websocket.StatusInternalError, "")
+				return
+			}
+			conn := &linkWSConn{
+				Conn:  websocket.NetConn(l.core.ctx, wsconn, websocket.MessageBinary),
+				laddr: laddr,
+				raddr: raddr,
+			}
+			select {
+			case wsl.ch <- conn:
+			case <-ctx.Done():
+				_ = conn.Close()
+			}
+		}),
+		ReadHeaderTimeout: time.Second * 6,
+		BaseContext: func(_ net.Listener) context.Context {
+			return ctx
+		},
+	}
+	go func() {
+		_ = wsl.server.Serve(listener)
+		cancel()
+	}()
+	return wsl, nil
+}
@@ -2,6 +2,9 @@ package core
 
 import (
 	"crypto/ed25519"
+	"net"
+	"net/netip"
+	"time"
 )
 
 func (c *Core) _applyOption(opt SetupOption) {
@@ -9,7 +12,7 @@ func (c *Core) _applyOption(opt SetupOption) {
 	case Peer:
 		c.config._peers[v] = nil
 	case ListenAddress:
-		c.config._listeners[v] = struct{}{}
+		c.config._listeners[v] = nil
 	case NodeInfo:
 		c.config.nodeinfo = v
 	case NodeInfoPrivacy:
@@ -20,6 +23,18 @@ func (c *Core) _applyOption(opt SetupOption) {
 		pk := [32]byte{}
 		copy(pk[:], v)
 		c.config._allowedPublicKeys[pk] = struct{}{}
+	case PeerReconnect:
+		c.config.peerReconnect = v
+	case LinkTimeout:
+		c.config.linkTimeout = time.Duration(v)
+	case DeniedPublicKey:
+		_ = c.denylist.addKey(ed25519.PublicKey(v))
+	case DeniedNetwork:
+		_ = c.denylist.addNetwork(netip.Prefix(v))
+	case InboundFilter:
+		c.config.inboundFilter = v
+	case transportOption:
+		c.config.transports[v.scheme] = v.transport
 	}
 }
 
@@ -39,9 +54,41 @@ type NetworkDomain struct {
 }
 type AllowedPublicKey ed25519.PublicKey
 
+// PeerReconnect sets how often configured peers are redialled after their
+// link drops or a dial fails. The interval starts at MinInterval, doubles
+// after each failure up to MaxInterval and is reset once a link comes up.
+// Each wait is randomised by up to plus or minus Jitter, a fraction of the
+// interval, so that peers don't all get redialled at the same moment.
+type PeerReconnect struct {
+	MinInterval time.Duration
+	MaxInterval time.Duration
+	Jitter      float64
+}
+
+// LinkTimeout is how long a link can go without receiving anything before it
+// is closed. Links are pinged often enough that this only happens when the
+// remote side has gone away without closing the connection.
+type LinkTimeout time.Duration
+
+// DeniedPublicKey refuses inbound links from a public key, regardless of
+// AllowedPublicKey, and DeniedNetwork refuses inbound connections from any
+// address in a network before any handshake work is done for them.
+type DeniedPublicKey ed25519.PublicKey
+type DeniedNetwork netip.Prefix
+
+// InboundFilter is called once the handshake of an inbound link has told us
+// the remote public key, after the denylist has been checked. The link is
+// closed unless it returns true.
+type InboundFilter func(key ed25519.PublicKey, remoteAddr net.Addr) bool
+
 func (a ListenAddress) isSetupOption()    {}
 func (a Peer) isSetupOption()             {}
 func (a NodeInfo) isSetupOption()         {}
 func (a NodeInfoPrivacy) isSetupOption()  {}
 func (a NetworkDomain) isSetupOption()    {}
 func (a AllowedPublicKey) isSetupOption() {}
+func (a PeerReconnect) isSetupOption()    {}
+func (a LinkTimeout) isSetupOption()      {}
+func (a DeniedPublicKey) isSetupOption()  {}
+func (a DeniedNetwork) isSetupOption()    {}
+func (a InboundFilter) isSetupOption()    {}
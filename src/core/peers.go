@@ -0,0 +1,243 @@
+package core
+
+import (
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// The reconnect schedule used for configured peers unless the PeerReconnect
+// setup option or the peer URI says otherwise.
+const (
+	defaultPeerReconnectMinInterval = time.Second
+	defaultPeerReconnectMaxInterval = time.Minute
+	defaultPeerReconnectJitter      = 0.2
+)
+
+// The states that a configured peer can be in, as reported by
+// GetConfiguredPeers.
+const (
+	PeerStateConnecting = "connecting"
+	PeerStateConnected  = "connected"
+	PeerStateBackoff    = "backoff"
+)
+
+// peerState tracks a configured peer between connection attempts. The url is
+// parsed once and the same pointer is passed to every dial, so that the link
+// handler can report back which configured peer a link belongs to.
+type peerState struct {
+	url         *url.URL
+	info        *linkInfo
+	reconnect   PeerReconnect
+	state       string
+	dialing     bool          // a dial is in progress
+	backoff     time.Duration // the interval last waited, zero once connected
+	timer       *time.Timer   // fires the next attempt, nil if none is scheduled
+	lastError   error
+	lastAttempt time.Time
+	attempts    uint64 // since the peer was last connected
+	nextRetry   time.Time
+}
+
+// peerReconnectFor returns the reconnect schedule for a peer, starting with
+// the given defaults and applying any overrides from the peer URI, e.g.
+// tls://a.b.c.d:e?backoff_min=5s&backoff_max=10m&backoff_jitter=0.1
+func peerReconnectFor(u *url.URL, defaults PeerReconnect) (PeerReconnect, error) {
+	r := defaults
+	var err error
+	query := u.Query()
+	if v := query.Get("backoff_min"); v != "" {
+		if r.MinInterval, err = time.ParseDuration(v); err != nil {
+			return r, fmt.Errorf("backoff_min invalid: %w", err)
+		}
+	}
+	if v := query.Get("backoff_max"); v != "" {
+		if r.MaxInterval, err = time.ParseDuration(v); err != nil {
+			return r, fmt.Errorf("backoff_max invalid: %w", err)
+		}
+	}
+	if v := query.Get("backoff_jitter"); v != "" {
+		if r.Jitter, err = strconv.ParseFloat(v, 64); err != nil {
+			return r, fmt.Errorf("backoff_jitter invalid: %w", err)
+		}
+	}
+	return r.normalise(), nil
+}
+
+// normalise fills in defaults for unset values and keeps the rest in range.
+func (r PeerReconnect) normalise() PeerReconnect {
+	if r.MinInterval <= 0 {
+		r.MinInterval = defaultPeerReconnectMinInterval
+	}
+	if r.MaxInterval <= 0 {
+		r.MaxInterval = defaultPeerReconnectMaxInterval
+	}
+	if r.MaxInterval < r.MinInterval {
+		r.MaxInterval = r.MinInterval
+	}
+	switch {
+	case r.Jitter < 0:
+		r.Jitter = 0
+	case r.Jitter > 1:
+		r.Jitter = 1
+	}
+	return r
+}
+
+// next returns the interval to wait after the given one, doubling it up to
+// the maximum, and the randomised delay to actually wait for it.
+func (r PeerReconnect) next(backoff time.Duration) (time.Duration, time.Duration) {
+	switch {
+	case backoff < r.MinInterval:
+		backoff = r.MinInterval
+	case backoff >= r.MaxInterval/2:
+		backoff = r.MaxInterval
+	default:
+		backoff *= 2
+	}
+	delay := backoff + time.Duration(float64(backoff)*r.Jitter*(2*rand.Float64()-1))
+	return backoff, delay
+}
+
+// _peerState returns the state of a configured peer, creating it if needed.
+// This fails for a URI that could never be dialled, such as one with a bad key
+// or an unknown scheme, rather than retrying it.
+// This function is unsafe and should only be ran by the core actor.
+func (c *Core) _peerState(peer Peer) (*peerState, error) {
+	if state := c.config._peers[peer]; state != nil {
+		return state, nil
+	}
+	u, err := url.Parse(peer.URI)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err = c.links.dialOptions(u); err != nil {
+		return nil, err
+	}
+	reconnect, err := peerReconnectFor(u, c.config.peerReconnect)
+	if err != nil {
+		return nil, err
+	}
+	state := &peerState{
+		url:       u,
+		reconnect: reconnect,
+	}
+	c.config._peers[peer] = state
+	return state, nil
+}
+
+// This function is unsafe and should only be ran by the core actor.
+func (c *Core) _connectPeer(peer Peer, state *peerState) {
+	if state.timer != nil {
+		state.timer.Stop()
+		state.timer = nil
+	}
+	state.nextRetry = time.Time{}
+	// The link may already be up, e.g. if it was made with CallPeer.
+	if info := linkInfoForURL(state.url, peer.SourceInterface); c.links.isUp(info) {
+		state.info = &info
+		state.state = PeerStateConnected
+		state.backoff = 0
+		state.attempts = 0
+		return
+	}
+	state.state = PeerStateConnecting
+	state.lastAttempt = time.Now()
+	state.attempts++
+	errch := make(chan error, 1)
+	info, err := c.links.call(state.url, peer.SourceInterface, errch)
+	if err != nil {
+		// The URI was checked when the state was made, so this shouldn't
+		// happen, but back off as if the dial had failed if it does.
+		c.log.Errorln("Failed to add peer:", err)
+		state.lastError = err
+		c._schedulePeer(peer, state)
+		return
+	}
+	state.info = &info
+	state.dialing = true
+	go func() {
+		err := <-errch
+		c.Act(nil, func() {
+			if c.config._peers[peer] != state {
+				return
+			}
+			state.dialing = false
+			if err != nil {
+				state.lastError = err
+				c._schedulePeer(peer, state)
+			}
+		})
+	}()
+}
+
+// This function is unsafe and should only be ran by the core actor.
+func (c *Core) _schedulePeer(peer Peer, state *peerState) {
+	select {
+	case <-c.ctx.Done():
+		return
+	default:
+	}
+	if c._stopping {
+		return
+	}
+	if state.timer != nil {
+		state.timer.Stop()
+	}
+	var delay time.Duration
+	state.backoff, delay = state.reconnect.next(state.backoff)
+	state.state = PeerStateBackoff
+	state.nextRetry = time.Now().Add(delay)
+	state.timer = time.AfterFunc(delay, func() {
+		c.Act(nil, func() {
+			if c.config._peers[peer] == state && !state.dialing && state.state != PeerStateConnected {
+				c._connectPeer(peer, state)
+			}
+		})
+	})
+}
+
+// _peerForLink finds the configured peer that a link belongs to, either
+// because the link was dialled for it, or because the link was already up
+// when the peer would have been dialled.
+// This function is unsafe and should only be ran by the core actor.
+func (c *Core) _peerForLink(dial *linkDial, info linkInfo) (Peer, *peerState) {
+	for peer, state := range c.config._peers {
+		switch {
+		case state == nil:
+		case dial != nil && state.url == dial.url:
+			return peer, state
+		case state.state == PeerStateConnected && !state.dialing && state.info != nil && *state.info == info:
+			return peer, state
+		}
+	}
+	return Peer{}, nil
+}
+
+// peerLinkUp is called by the link handler when an outbound link has finished
+// its handshake, so that the backoff of the configured peer can be reset.
+func (c *Core) peerLinkUp(dial *linkDial) {
+	c.Act(nil, func() {
+		if _, state := c._peerForLink(dial, linkInfo{}); state != nil {
+			state.state = PeerStateConnected
+			state.backoff = 0
+			state.attempts = 0
+		}
+	})
+}
+
+// peerLinkDown is called by the link handler when a link has closed or failed
+// its handshake, so that the configured peer it belongs to, if any, can be
+// redialled.
+func (c *Core) peerLinkDown(dial *linkDial, info linkInfo, err error) {
+	c.Act(nil, func() {
+		if peer, state := c._peerForLink(dial, info); state != nil {
+			if err != nil {
+				state.lastError = err
+			}
+			c._schedulePeer(peer, state)
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Arceliar/phony"
+)
+
+func TestPeers_Backoff(t *testing.T) {
+	r := PeerReconnect{MinInterval: time.Second, MaxInterval: 10 * time.Second}.normalise()
+	var backoff, delay time.Duration
+	for _, expect := range []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second,
+	} {
+		backoff, delay = r.next(backoff)
+		if backoff != expect || delay != expect {
+			t.Fatalf("expected %s, got %s (delay %s)", expect, backoff, delay)
+		}
+	}
+
+	r.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if _, delay = r.next(4 * time.Second); delay < 4*time.Second || delay > 12*time.Second {
+			t.Fatalf("delay %s outside of jitter range", delay)
+		}
+	}
+}
+
+func TestPeers_ReconnectFor(t *testing.T) {
+	defaults := PeerReconnect{MinInterval: time.Second, MaxInterval: time.Minute, Jitter: 0.2}
+	u, _ := url.Parse("tls://127.0.0.1:1?backoff_min=5s&backoff_max=10m&backoff_jitter=0.1")
+	r, err := peerReconnectFor(u, defaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.MinInterval != 5*time.Second || r.MaxInterval != 10*time.Minute || r.Jitter != 0.1 {
+		t.Fatalf("overrides not applied: %+v", r)
+	}
+
+	u, _ = url.Parse("tls://127.0.0.1:1")
+	if r, err = peerReconnectFor(u, defaults); err != nil || r != defaults {
+		t.Fatalf("expected defaults, got %+v (%v)", r, err)
+	}
+
+	u, _ = url.Parse("tls://127.0.0.1:1?backoff_min=soon")
+	if _, err = peerReconnectFor(u, defaults); err == nil {
+		t.Fatal("expected an error for an invalid interval")
+	}
+}
+
+// TestPeers_Reconnect checks that a configured peer that can't be reached at
+// first is redialled until it can.
+func TestPeers_Reconnect(t *testing.T) {
+	// Find a free port and then close it again, so that the first dials fail.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	nodeA := newTestCore(t, "A: ")
+	nodeB := newTestCore(t, "B: ",
+		Peer{URI: fmt.Sprintf("tcp://%s?backoff_min=100ms&backoff_max=200ms", addr)},
+	)
+
+	time.Sleep(time.Millisecond * 300)
+	peers := nodeB.GetConfiguredPeers()
+	if len(peers) != 1 {
+		t.Fatalf("expected one configured peer, got %d", len(peers))
+	}
+	if p := peers[0]; p.State == PeerStateConnected || p.LastError == "" || p.Attempts == 0 || p.LastAttempt.IsZero() {
+		t.Fatalf("unexpected state for unreachable peer: %+v", p)
+	}
+
+	if _, err = nodeA.links.listen(&url.URL{Scheme: "tcp", Host: addr}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("configured peer was not redialled")
+	}
+	time.Sleep(time.Millisecond * 100)
+	if p := nodeB.GetConfiguredPeers()[0]; p.State != PeerStateConnected || p.Attempts != 0 {
+		t.Fatalf("unexpected state for connected peer: %+v", p)
+	}
+}
+
+// TestPeers_ReconnectExisting checks that a configured peer whose link was
+// already up, because it was made with CallPeer, is redialled when that link
+// goes down.
+func TestPeers_ReconnectExisting(t *testing.T) {
+	nodeA := newTestCore(t, "A: ", ListenAddress("tcp://127.0.0.1:0"))
+	nodeB := newTestCore(t, "B: ")
+
+	uri := fmt.Sprintf("tcp://%s?backoff_min=100ms&backoff_max=200ms", nodeA.GetListeners()[0].Address)
+	u, _ := url.Parse(uri)
+	if err := nodeB.CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("nodes did not connect")
+	}
+	if err := nodeB.AddPeer(uri, ""); err != nil {
+		t.Fatal(err)
+	}
+	if p := nodeB.GetConfiguredPeers()[0]; p.State != PeerStateConnected || p.Attempts != 0 {
+		t.Fatalf("unexpected state for a peer that is already connected: %+v", p)
+	}
+
+	info := linkInfoForURL(u, "")
+	var intf *link
+	phony.Block(&nodeB.links, func() {
+		intf = nodeB.links._links[info]
+	})
+	if intf == nil {
+		t.Fatal("link made with CallPeer was not found")
+	}
+	events, unsubscribe := nodeB.Subscribe(64)
+	defer unsubscribe()
+	_ = intf.close()
+
+	// Without the redial, the link would only come back with the next run
+	// of the peer loop, a minute later.
+	waitEvent[LinkDisconnected](t, events)
+	if connected := waitEvent[LinkConnected](t, events); connected.Direction != LinkDirectionOutbound {
+		t.Fatalf("unexpected connected event: %+v", connected)
+	}
+	time.Sleep(time.Millisecond * 100)
+	if p := nodeB.GetConfiguredPeers()[0]; p.State != PeerStateConnected || !nodeB.links.isUp(info) {
+		t.Fatalf("unexpected state for redialled peer: %+v", p)
+	}
+}
+
+// TestPeers_InvalidURI checks that a peer URI that could never be dialled is
+// refused straight away rather than retried.
+func TestPeers_InvalidURI(t *testing.T) {
+	const uri = "tcp://127.0.0.1:1?key=zz"
+	node := newTestCore(t, "", Peer{URI: uri})
+
+	for _, bad := range []string{
+		"tcp://127.0.0.1:2?key=zz",
+		"tcp://127.0.0.1:1?priority=x",
+		"tcp://127.0.0.1:1?compress=x",
+		"tcp://127.0.0.1:1?ratelimit=x",
+		"unknown://127.0.0.1:1",
+	} {
+		if err := node.AddPeer(bad, ""); err == nil {
+			t.Fatalf("expected an error adding %s", bad)
+		}
+	}
+
+	time.Sleep(time.Millisecond * 100)
+	peers := node.GetConfiguredPeers()
+	if len(peers) != 1 || peers[0].URI != uri {
+		t.Fatalf("expected only the peer from the config, got %+v", peers)
+	}
+	if p := peers[0]; p.Attempts != 0 || !p.NextRetry.IsZero() {
+		t.Fatalf("invalid peer was dialled: %+v", p)
+	}
+}
@@ -0,0 +1,210 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	iwt "github.com/Arceliar/ironwood/types"
+	"github.com/Arceliar/phony"
+)
+
+const (
+	// A ping is the ID of the Ping call, the sequence number of the probe and
+	// when it was sent, followed by padding up to the requested size.
+	pingHeaderSize = 16
+	pingInterval   = time.Second
+	// How long to wait for replies after the last probe is sent.
+	pingTimeout = 6 * time.Second
+)
+
+// PingMaxCount is the most probes that a single Ping can send.
+const PingMaxCount = 100
+
+// Probes are timestamped with the time since this, so that round trip times
+// use the monotonic clock and can't be thrown out by the wall clock changing.
+var pingEpoch = time.Now()
+
+// PingProbe is the outcome of one probe sent by Ping.
+type PingProbe struct {
+	Seq  uint32
+	RTT  time.Duration // zero if the probe was lost
+	Lost bool
+}
+
+// PingResult is the outcome of Ping. The round trip times are only over the
+// probes that weren't lost, and are zero if they all were.
+type PingResult struct {
+	Key      ed25519.PublicKey
+	Size     int // bytes in each probe
+	Probes   []PingProbe
+	Sent     int
+	Received int
+	Loss     float64 // fraction of the probes that were lost
+	RTTMin   time.Duration
+	RTTAvg   time.Duration
+	RTTMax   time.Duration
+}
+
+type pingReply struct {
+	seq uint32
+	rtt time.Duration
+}
+
+type pinger struct {
+	key     keyArray
+	replies chan pingReply
+}
+
+// Ping sends count probes of size bytes to a node, a second apart, and waits
+// for it to echo them back. Unlike an ICMPv6 ping this goes straight to the
+// node, so it works without a TUN adapter on either side, and shows whether
+// the mesh can reach the node whatever state its TUN adapter is in. Probes
+// smaller than 16 bytes are made up to that size.
+func (c *Core) Ping(key ed25519.PublicKey, count, size int) (*PingResult, error) {
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid public key")
+	}
+	if key.Equal(c.PublicKey()) {
+		return nil, errors.New("can't ping this node")
+	}
+	if count < 1 {
+		return nil, errors.New("count must be at least 1")
+	}
+	if count > PingMaxCount {
+		return nil, fmt.Errorf("count can't be more than %d", PingMaxCount)
+	}
+	if size < pingHeaderSize {
+		size = pingHeaderSize
+	}
+	const overhead = 2 // session type and proto type
+	if limit := int(c.PacketConn.MTU()) - overhead; size > limit {
+		return nil, fmt.Errorf("size can't be more than %d", limit)
+	}
+	var k keyArray
+	copy(k[:], key)
+	p := &pinger{key: k, replies: make(chan pingReply, count)}
+	var id uint32
+	phony.Block(&c.proto, func() {
+		c.proto.pingID++
+		id = c.proto.pingID
+		c.proto.pings[id] = p
+	})
+	defer phony.Block(&c.proto, func() {
+		delete(c.proto.pings, id)
+	})
+
+	result := &PingResult{
+		Key:    append(ed25519.PublicKey(nil), key...),
+		Size:   size,
+		Probes: make([]PingProbe, count),
+	}
+	for i := range result.Probes {
+		result.Probes[i] = PingProbe{Seq: uint32(i + 1), Lost: true}
+	}
+	send := func(seq uint32) {
+		bs := make([]byte, overhead+size)
+		bs[0], bs[1] = typeSessionProto, typeProtoPingRequest
+		binary.BigEndian.PutUint32(bs[2:], id)
+		binary.BigEndian.PutUint32(bs[6:], seq)
+		binary.BigEndian.PutUint64(bs[10:], uint64(time.Since(pingEpoch)))
+		_, _ = c.PacketConn.WriteTo(bs, iwt.Addr(key))
+		result.Sent++
+	}
+
+	send(1)
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	tick := ticker.C
+	var timeout <-chan time.Time
+	if count == 1 {
+		tick, timeout = nil, time.After(pingTimeout)
+	}
+	for result.Received < count {
+		select {
+		case <-tick:
+			send(uint32(result.Sent + 1))
+			if result.Sent == count {
+				tick, timeout = nil, time.After(pingTimeout)
+			}
+		case reply := <-p.replies:
+			if reply.seq < 1 || int(reply.seq) > result.Sent || reply.rtt < 0 {
+				continue
+			}
+			probe := &result.Probes[reply.seq-1]
+			if probe.Lost {
+				probe.Lost, probe.RTT = false, reply.rtt
+				result.Received++
+			}
+		case <-timeout:
+			result.stats()
+			return result, nil
+		}
+	}
+	result.stats()
+	return result, nil
+}
+
+// stats works out the loss and round trip times from the probes.
+func (r *PingResult) stats() {
+	r.Loss = float64(r.Sent-r.Received) / float64(r.Sent)
+	if r.Received == 0 {
+		return
+	}
+	var total time.Duration
+	for _, probe := range r.Probes {
+		if probe.Lost {
+			continue
+		}
+		if r.RTTMin == 0 || probe.RTT < r.RTTMin {
+			r.RTTMin = probe.RTT
+		}
+		if probe.RTT > r.RTTMax {
+			r.RTTMax = probe.RTT
+		}
+		total += probe.RTT
+	}
+	r.RTTAvg = total / time.Duration(r.Received)
+}
+
+func (p *protoHandler) handlePingRequest(from phony.Actor, key keyArray, bs []byte) {
+	p.Act(from, func() {
+		p._handlePingRequest(key, bs)
+	})
+}
+
+// _handlePingRequest echoes a probe back to the node that sent it.
+func (p *protoHandler) _handlePingRequest(key keyArray, bs []byte) {
+	if len(bs) < pingHeaderSize {
+		return
+	}
+	res := append([]byte{typeSessionProto, typeProtoPingResponse}, bs...)
+	_, _ = p.core.PacketConn.WriteTo(res, iwt.Addr(key[:]))
+}
+
+func (p *protoHandler) handlePingResponse(from phony.Actor, key keyArray, bs []byte) {
+	p.Act(from, func() {
+		p._handlePingResponse(key, bs)
+	})
+}
+
+func (p *protoHandler) _handlePingResponse(key keyArray, bs []byte) {
+	if len(bs) < pingHeaderSize {
+		return
+	}
+	pinger := p.pings[binary.BigEndian.Uint32(bs)]
+	if pinger == nil || pinger.key != key {
+		return
+	}
+	sent := time.Duration(binary.BigEndian.Uint64(bs[8:]))
+	reply := pingReply{
+		seq: binary.BigEndian.Uint32(bs[4:]),
+		rtt: time.Since(pingEpoch) - sent,
+	}
+	select {
+	case pinger.replies <- reply:
+	default:
+	}
+}
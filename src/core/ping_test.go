@@ -0,0 +1,47 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestCore_Ping(t *testing.T) {
+	nodeA, nodeB := CreateAndConnectTwo(t, false)
+	defer nodeA.Stop()
+	defer nodeB.Stop()
+
+	result, err := nodeA.Ping(nodeB.PublicKey(), 2, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Sent != 2 || result.Received != 2 || result.Loss != 0 || result.Size != 100 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	for i, probe := range result.Probes {
+		if probe.Seq != uint32(i+1) || probe.Lost || probe.RTT <= 0 {
+			t.Fatalf("unexpected probe %+v", probe)
+		}
+	}
+	if result.RTTMin > result.RTTAvg || result.RTTAvg > result.RTTMax {
+		t.Fatalf("inconsistent round trip times %+v", result)
+	}
+
+	// Small probes still have room for the header.
+	if result, err = nodeB.Ping(nodeA.PublicKey(), 1, 0); err != nil {
+		t.Fatal(err)
+	} else if result.Received != 1 || result.Size != pingHeaderSize {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	if _, err = nodeA.Ping(nodeA.PublicKey(), 1, 0); err == nil {
+		t.Fatal("expected an error pinging this node")
+	}
+	if _, err = nodeA.Ping(nodeB.PublicKey(), 0, 0); err == nil {
+		t.Fatal("expected an error for no probes")
+	}
+	if _, err = nodeA.Ping(nodeB.PublicKey(), PingMaxCount+1, 0); err == nil {
+		t.Fatal("expected an error for too many probes")
+	}
+	if _, err = nodeA.Ping(nodeB.PublicKey(), 1, 1<<20); err == nil {
+		t.Fatal("expected an error for probes bigger than the MTU")
+	}
+}
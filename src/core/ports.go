@@ -0,0 +1,256 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	iwt "github.com/Arceliar/ironwood/types"
+)
+
+// Datagrams sent through ListenPacket start with the destination and then
+// the source port, each as a big endian uint16, after the session type.
+const portHeaderSize = 4
+
+// Ports chosen by ListenPacket when asked for port zero.
+const (
+	portEphemeralFirst = 49152
+	portEphemeralLast  = 65535
+)
+
+// How many packets are queued for a reader before any more are dropped.
+const packetQueueSize = 128
+
+// PortAddr is the address of a PacketConn returned by ListenPacket, made up
+// of a node's public key and a port on that node.
+type PortAddr struct {
+	Key  ed25519.PublicKey
+	Port uint16
+}
+
+func (a *PortAddr) Network() string {
+	return "mesh"
+}
+
+func (a *PortAddr) String() string {
+	return fmt.Sprintf("%s:%d", hex.EncodeToString(a.Key), a.Port)
+}
+
+type packet struct {
+	data []byte
+	from net.Addr
+}
+
+type packetPorts struct {
+	mutex  sync.Mutex
+	conns  map[uint16]*portConn
+	next   uint16 // the next ephemeral port to try
+	closed bool
+}
+
+// ListenPacket returns a PacketConn for datagrams sent to the given port on
+// this node, so that several applications can share the node without seeing
+// each other's traffic. Its addresses are *PortAddr. If port is zero then an
+// unused port is chosen. Each datagram can be up to MTU()-4 bytes long.
+// Traffic sent with Core.WriteTo, as used by TUN, is kept apart from these
+// and is only ever returned by Core.ReadFrom.
+func (c *Core) ListenPacket(port uint16) (net.PacketConn, error) {
+	p := &c.ports
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return nil, net.ErrClosed
+	}
+	if p.conns == nil {
+		p.conns = map[uint16]*portConn{}
+	}
+	if port == 0 {
+		for i := 0; i <= portEphemeralLast-portEphemeralFirst; i++ {
+			if p.next < portEphemeralFirst {
+				p.next = portEphemeralFirst
+			}
+			candidate := p.next
+			p.next++
+			if _, ok := p.conns[candidate]; !ok {
+				port = candidate
+				break
+			}
+		}
+		if port == 0 {
+			return nil, fmt.Errorf("no free ports")
+		}
+	} else if _, ok := p.conns[port]; ok {
+		return nil, fmt.Errorf("port %d is already in use", port)
+	}
+	conn := &portConn{
+		core:    c,
+		port:    port,
+		packets: make(chan packet, packetQueueSize),
+		closed:  make(chan struct{}),
+		wake:    make(chan struct{}),
+	}
+	p.conns[port] = conn
+	return conn, nil
+}
+
+// deliver hands a datagram to the PacketConn for its destination port, if
+// there is one.
+func (p *packetPorts) deliver(bs []byte, from net.Addr) {
+	if len(bs) < portHeaderSize {
+		return
+	}
+	dst := binary.BigEndian.Uint16(bs[0:2])
+	src := binary.BigEndian.Uint16(bs[2:4])
+	p.mutex.Lock()
+	conn := p.conns[dst]
+	p.mutex.Unlock()
+	if conn == nil {
+		return
+	}
+	addr := &PortAddr{
+		Key:  append(ed25519.PublicKey(nil), from.(iwt.Addr)...),
+		Port: src,
+	}
+	select {
+	case conn.packets <- packet{append([]byte(nil), bs[portHeaderSize:]...), addr}:
+	default:
+	}
+}
+
+// close closes every PacketConn, once the node can no longer receive.
+func (p *packetPorts) close() {
+	p.mutex.Lock()
+	p.closed = true
+	conns := p.conns
+	p.conns = nil
+	p.mutex.Unlock()
+	for _, conn := range conns {
+		conn.once.Do(func() {
+			close(conn.closed)
+		})
+	}
+}
+
+// portConn is the net.PacketConn returned by ListenPacket.
+type portConn struct {
+	core     *Core
+	port     uint16
+	packets  chan packet
+	closed   chan struct{}
+	once     sync.Once
+	mutex    sync.Mutex // protects the fields below
+	deadline time.Time
+	wake     chan struct{} // closed when the deadline changes
+}
+
+func (p *portConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		p.mutex.Lock()
+		deadline, wake := p.deadline, p.wake
+		p.mutex.Unlock()
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, nil, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		var pkt packet
+		var err error
+		woken := false
+		select {
+		case pkt = <-p.packets:
+		case <-p.closed:
+			err = net.ErrClosed
+		case <-timeout:
+			err = os.ErrDeadlineExceeded
+		case <-wake:
+			woken = true
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		switch {
+		case woken:
+			continue
+		case err != nil:
+			return 0, nil, err
+		}
+		return copy(b, pkt.data), pkt.from, nil
+	}
+}
+
+func (p *portConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	dst, ok := addr.(*PortAddr)
+	if !ok {
+		return 0, fmt.Errorf("unexpected address type %T", addr)
+	}
+	if len(dst.Key) != ed25519.PublicKeySize {
+		return 0, fmt.Errorf("incorrect key length")
+	}
+	select {
+	case <-p.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	if !p.core.writes.enter() {
+		return 0, net.ErrClosed
+	}
+	defer p.core.writes.exit()
+	buf := make([]byte, 0, 1+portHeaderSize+len(b))
+	buf = append(buf, typeSessionPort)
+	buf = binary.BigEndian.AppendUint16(buf, dst.Port)
+	buf = binary.BigEndian.AppendUint16(buf, p.port)
+	buf = append(buf, b...)
+	if _, err := p.core.PacketConn.WriteTo(buf, iwt.Addr(dst.Key)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (p *portConn) Close() error {
+	err := net.ErrClosed
+	p.once.Do(func() {
+		ports := &p.core.ports
+		ports.mutex.Lock()
+		if ports.conns[p.port] == p {
+			delete(ports.conns, p.port)
+		}
+		ports.mutex.Unlock()
+		close(p.closed)
+		err = nil
+	})
+	return err
+}
+
+func (p *portConn) LocalAddr() net.Addr {
+	return &PortAddr{Key: p.core.public, Port: p.port}
+}
+
+func (p *portConn) SetDeadline(t time.Time) error {
+	return p.SetReadDeadline(t)
+}
+
+// SetReadDeadline also wakes any ReadFrom that is waiting, so that it picks
+// up the new deadline.
+func (p *portConn) SetReadDeadline(t time.Time) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.deadline = t
+	close(p.wake)
+	p.wake = make(chan struct{})
+	return nil
+}
+
+// SetWriteDeadline does nothing, as writes are queued rather than blocking.
+func (p *portConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
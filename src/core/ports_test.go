@@ -0,0 +1,130 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	iwt "github.com/Arceliar/ironwood/types"
+)
+
+func TestCore_ListenPacket(t *testing.T) {
+	nodeA, nodeB := CreateAndConnectTwo(t, false)
+	defer nodeA.Stop()
+	defer nodeB.Stop()
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("nodes did not connect")
+	}
+
+	control, err := nodeA.ListenPacket(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer control.Close()
+	bulk, err := nodeA.ListenPacket(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bulk.Close()
+	if _, err = nodeA.ListenPacket(2); err == nil {
+		t.Fatal("expected an error for a port that is in use")
+	}
+	client, err := nodeB.ListenPacket(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Each datagram arrives on the port it was sent to, from the port that
+	// it was sent from.
+	for _, conn := range []net.PacketConn{control, bulk} {
+		port := conn.LocalAddr().(*PortAddr).Port
+		msg := []byte{byte(port), 1, 2, 3}
+		if _, err = client.WriteTo(msg, &PortAddr{Key: nodeA.public, Port: port}); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 64)
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], msg) {
+			t.Fatalf("port %d got %v, expected %v", port, buf[:n], msg)
+		}
+		if from.String() != client.LocalAddr().String() {
+			t.Fatalf("unexpected source address %s", from)
+		}
+		if _, err = conn.WriteTo(msg, from); err != nil {
+			t.Fatal(err)
+		}
+		_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if n, from, err = client.ReadFrom(buf); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(buf[:n], msg) || from.(*PortAddr).Port != port {
+			t.Fatalf("unexpected reply %v from %s", buf[:n], from)
+		}
+	}
+
+	_ = bulk.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, _, err = bulk.ReadFrom(make([]byte, 64)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	_ = control.Close()
+	if _, err = nodeA.ListenPacket(1); err != nil {
+		t.Fatal("port was not freed on close:", err)
+	}
+}
+
+// TestCore_ListenPacket_UnreadTraffic checks that traffic which nobody reads
+// with ReadFrom is dropped rather than holding up datagrams for ports.
+func TestCore_ListenPacket_UnreadTraffic(t *testing.T) {
+	nodeA, nodeB := CreateAndConnectTwo(t, false)
+	defer nodeA.Stop()
+	defer nodeB.Stop()
+	if !WaitConnected(nodeA, nodeB) {
+		t.Fatal("nodes did not connect")
+	}
+
+	conn, err := nodeA.ListenPacket(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client, err := nodeB.ListenPacket(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Fill the traffic queue, which nothing reads from.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(nodeA.traffic) < packetQueueSize {
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d packets of traffic were queued", len(nodeA.traffic))
+		}
+		if _, err = nodeB.WriteTo([]byte{1, 2, 3}, iwt.Addr(nodeA.public)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	msg := []byte{4, 5, 6}
+	if _, err = client.WriteTo(msg, &PortAddr{Key: nodeA.public, Port: 1}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal("datagram was held up by unread traffic:", err)
+	}
+	if !bytes.Equal(buf[:n], msg) {
+		t.Fatalf("got %v, expected %v", buf[:n], msg)
+	}
+	if n, _, err = nodeA.ReadFrom(buf); err != nil || !bytes.Equal(buf[:n], []byte{1, 2, 3}) {
+		t.Fatalf("unexpected traffic %v: %v", buf[:n], err)
+	}
+}
@@ -39,6 +39,9 @@ type protoHandler struct {
 	selfRequests  map[keyArray]*reqInfo
 	peersRequests map[keyArray]*reqInfo
 	dhtRequests   map[keyArray]*reqInfo
+
+	pings  map[uint32]*pinger
+	pingID uint32
 }
 
 func (p *protoHandler) init(core *Core) {
@@ -48,6 +51,7 @@ func (p *protoHandler) init(core *Core) {
 	p.selfRequests = make(map[keyArray]*reqInfo)
 	p.peersRequests = make(map[keyArray]*reqInfo)
 	p.dhtRequests = make(map[keyArray]*reqInfo)
+	p.pings = make(map[uint32]*pinger)
 }
 
 // Common functions
@@ -62,6 +66,10 @@ func (p *protoHandler) handleProto(from phony.Actor, key keyArray, bs []byte) {
 		p.nodeinfo.handleReq(p, key)
 	case typeProtoNodeInfoResponse:
 		p.nodeinfo.handleRes(p, key, bs[1:])
+	case typeProtoPingRequest:
+		p.handlePingRequest(from, key, bs[1:])
+	case typeProtoPingResponse:
+		p.handlePingResponse(from, key, bs[1:])
 	case typeProtoDebug:
 		p.handleDebug(from, key, bs[1:])
 	}
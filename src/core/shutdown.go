@@ -0,0 +1,157 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Arceliar/phony"
+)
+
+// How long a link must go without writing any traffic before Shutdown takes
+// it to have sent everything that was queued for it.
+const linkDrainIdle = 10 * time.Millisecond
+
+// writeGate counts the WriteTo calls in progress, so that Shutdown can wait
+// for them to return while refusing any new ones. A WriteTo returns once
+// ironwood has taken the packet, which may still be queued for a link, so
+// this doesn't mean that everything written has been sent.
+type writeGate struct {
+	mutex   sync.Mutex
+	closed  bool
+	pending sync.WaitGroup
+}
+
+// enter returns false if the gate is closed, otherwise exit must be called
+// once the write has finished.
+func (g *writeGate) enter() bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.closed {
+		return false
+	}
+	g.pending.Add(1)
+	return true
+}
+
+func (g *writeGate) exit() {
+	g.pending.Done()
+}
+
+// close stops any new writes and waits for those in progress to return, or
+// for the context to be done.
+func (g *writeGate) close(ctx context.Context) error {
+	g.mutex.Lock()
+	g.closed = true
+	g.mutex.Unlock()
+	returned := make(chan struct{})
+	go func() {
+		g.pending.Wait()
+		close(returned)
+	}()
+	select {
+	case <-returned:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown stops the node gracefully. It stops connecting to peers, closes
+// the listeners, waits for any WriteTo calls in progress to return and for
+// the packets queued for each link to be sent, and then sends a goodbye on
+// each link, so that peers can route around us straight away rather than
+// waiting for the link to fail. If the context is done before then, the node
+// is stopped as by Stop and the context's error is returned.
+func (c *Core) Shutdown(ctx context.Context) error {
+	c.log.Infoln("Shutting down...")
+	phony.Block(c, func() {
+		c._stopping = true
+		if c.addPeerTimer != nil {
+			c.addPeerTimer.Stop()
+			c.addPeerTimer = nil
+		}
+		for _, state := range c.config._peers {
+			if state != nil && state.timer != nil {
+				state.timer.Stop()
+				state.timer = nil
+			}
+		}
+	})
+	c.links.shutdown()
+	err := c.writes.close(ctx)
+	if err == nil {
+		err = c.links.drain(ctx)
+	}
+	if err == nil {
+		err = c.links.goodbye(ctx)
+	}
+	phony.Block(c, func() {
+		if e := c._close(); err == nil {
+			err = e
+		}
+	})
+	c.log.Infoln("Stopped")
+	return err
+}
+
+// framers returns the framers of every link that is up.
+func (l *links) framers() []*linkFramer {
+	var frames []*linkFramer
+	phony.Block(l, func() {
+		for _, intf := range l._links {
+			if intf != nil && intf.conn.frames != nil {
+				frames = append(frames, intf.conn.frames)
+			}
+		}
+	})
+	return frames
+}
+
+// drain waits until every link that is up has written the packets that
+// ironwood queued for it, or the context is done. A link that is forwarding
+// traffic for others may never go idle, in which case this lasts as long as
+// the context allows.
+func (l *links) drain(ctx context.Context) error {
+	frames := l.framers()
+	ticker := time.NewTicker(linkDrainIdle / 2)
+	defer ticker.Stop()
+	for {
+		for len(frames) > 0 && frames[0].idle(linkDrainIdle) {
+			frames = frames[1:]
+		}
+		if len(frames) == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// goodbye sends a goodbye frame on every link that is up, returning once they
+// have all been sent or the context is done.
+func (l *links) goodbye(ctx context.Context) error {
+	frames := l.framers()
+	var wg sync.WaitGroup
+	for _, f := range frames {
+		wg.Add(1)
+		go func(f *linkFramer) {
+			defer wg.Done()
+			f.goodbye()
+		}(f)
+	}
+	sent := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(sent)
+	}()
+	select {
+	case <-sent:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
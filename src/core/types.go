@@ -7,6 +7,7 @@ const (
 	typeSessionDummy = iota // nolint:deadcode,varcheck
 	typeSessionTraffic
 	typeSessionProto
+	typeSessionPort // see ListenPacket
 )
 
 // Protocol packet types
@@ -14,6 +15,8 @@ const (
 	typeProtoDummy = iota
 	typeProtoNodeInfoRequest
 	typeProtoNodeInfoResponse
+	typeProtoPingRequest // see Ping
+	typeProtoPingResponse
 	typeProtoDebug = 255
 )
 
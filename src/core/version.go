@@ -4,17 +4,34 @@ package core
 // Used in the initial connection setup and key exchange
 // Some of this could arguably go in wire.go instead
 
-import "crypto/ed25519"
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// The length of the random nonce that each side sends in its metadata and
+// that the other side must sign to prove ownership of its key.
+const version_nonceLength = 32
+
+// This is prepended to everything that is signed during the handshake, so
+// that the signatures can't be mistaken for signatures over anything else.
+const version_sigContext = "riv-mesh link handshake"
+
+// The length of the MAC that proves knowledge of the link password.
+const version_macLength = sha256.Size
 
 // This is the version-specific metadata exchanged at the start of a connection.
 // It must always begin with the 4 bytes "meta" and a wire formatted uint64 major version number.
-// The current version also includes a minor version number, and the box/sig/link keys that need to be exchanged to open a connection.
+// The current version also includes a minor version number, the key that needs to be exchanged to open a connection, a nonce that the remote side must sign, and the requested link compression.
 type version_metadata struct {
 	meta [4]byte
 	ver  uint8 // 1 byte in this version
 	// Everything after this point potentially depends on the version number, and is subject to change in future versions
-	minorVer uint8 // 1 byte in this version
-	key      ed25519.PublicKey
+	minorVer    uint8 // 1 byte in this version
+	key         ed25519.PublicKey
+	nonce       [version_nonceLength]byte
+	compression uint8 // requested compression, only used if both sides request the same
 }
 
 // Gets a base metadata with no keys set, but with the correct version numbers.
@@ -22,16 +39,24 @@ func version_getBaseMetadata() version_metadata {
 	return version_metadata{
 		meta:     [4]byte{'m', 'e', 't', 'a'},
 		ver:      0,
-		minorVer: 4,
+		minorVer: 5,
 	}
 }
 
+// Gets the length of the part of the metadata that is common to all versions, used to check the version before reading the rest.
+func version_getHeaderLength() (hlen int) {
+	hlen += 4 // meta
+	hlen++    // ver, as long as it's < 127, which it is in this version
+	hlen++    // minorVer, as long as it's < 127, which it is in this version
+	return
+}
+
 // Gets the length of the metadata for this version, used to know how many bytes to read from the start of a connection.
 func version_getMetaLength() (mlen int) {
-	mlen += 4                     // meta
-	mlen++                        // ver, as long as it's < 127, which it is in this version
-	mlen++                        // minorVer, as long as it's < 127, which it is in this version
-	mlen += ed25519.PublicKeySize // key
+	mlen += version_getHeaderLength() // meta, ver, minorVer
+	mlen += ed25519.PublicKeySize     // key
+	mlen += version_nonceLength       // nonce
+	mlen++                            // compression
 	return
 }
 
@@ -42,6 +67,8 @@ func (m *version_metadata) encode() []byte {
 	bs = append(bs, m.ver)
 	bs = append(bs, m.minorVer)
 	bs = append(bs, m.key[:]...)
+	bs = append(bs, m.nonce[:]...)
+	bs = append(bs, m.compression)
 	if len(bs) != version_getMetaLength() {
 		panic("Inconsistent metadata length")
 	}
@@ -57,7 +84,22 @@ func (m *version_metadata) decode(bs []byte) bool {
 	offset += copy(m.meta[:], bs[offset:])
 	m.ver, offset = bs[offset], offset+1
 	m.minorVer, offset = bs[offset], offset+1
-	m.key = append([]byte(nil), bs[offset:]...)
+	m.key = append([]byte(nil), bs[offset:offset+ed25519.PublicKeySize]...)
+	offset += ed25519.PublicKeySize
+	offset += copy(m.nonce[:], bs[offset:])
+	m.compression = bs[offset]
+	return true
+}
+
+// Decodes only the "meta" bytes and the version numbers, so that they can be checked before the rest is read.
+func (m *version_metadata) decodeHeader(bs []byte) bool {
+	if len(bs) < version_getHeaderLength() {
+		return false
+	}
+	offset := 0
+	offset += copy(m.meta[:], bs[offset:])
+	m.ver, offset = bs[offset], offset+1
+	m.minorVer = bs[offset]
 	return true
 }
 
@@ -66,3 +108,44 @@ func (m *version_metadata) check() bool {
 	base := version_getBaseMetadata()
 	return base.meta == m.meta && base.ver == m.ver && base.minorVer == m.minorVer
 }
+
+// Gets the message that the owner of m.key signs to prove its identity to the
+// remote side. It covers the nonces, keys and compression of both sides, so a
+// signature can neither be replayed on another connection nor relayed to a
+// different node, and the negotiated compression can't be tampered with.
+func (m *version_metadata) sigMessage(remote *version_metadata) []byte {
+	bs := make([]byte, 0, len(version_sigContext)+2*version_nonceLength+2*ed25519.PublicKeySize+2)
+	bs = append(bs, version_sigContext...)
+	bs = append(bs, remote.nonce[:]...)
+	bs = append(bs, m.nonce[:]...)
+	bs = append(bs, remote.key...)
+	bs = append(bs, m.key...)
+	bs = append(bs, remote.compression, m.compression)
+	return bs
+}
+
+// Signs the nonce sent by the remote side along with both keys.
+func (m *version_metadata) sign(secret ed25519.PrivateKey, remote *version_metadata) []byte {
+	return ed25519.Sign(secret, m.sigMessage(remote))
+}
+
+// Checks that the signature received from the remote side was made with the
+// key it claimed, over the nonce that we sent.
+func (m *version_metadata) verify(remote *version_metadata, sig []byte) bool {
+	return ed25519.Verify(remote.key, remote.sigMessage(m), sig)
+}
+
+// Computes a MAC, keyed with the link password, over the same nonces and keys
+// that are signed. Both sides send one even if no password is set, in which
+// case the key is empty, so that a password on only one side is a mismatch.
+func (m *version_metadata) mac(password []byte, remote *version_metadata) []byte {
+	h := hmac.New(sha256.New, password)
+	_, _ = h.Write(m.sigMessage(remote))
+	return h.Sum(nil)
+}
+
+// Checks that the MAC received from the remote side was keyed with the same
+// password as ours.
+func (m *version_metadata) checkMAC(password []byte, remote *version_metadata, mac []byte) bool {
+	return hmac.Equal(remote.mac(password, m), mac)
+}
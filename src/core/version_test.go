@@ -0,0 +1,66 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+)
+
+func newTestMetadata(t *testing.T) (*version_metadata, ed25519.PrivateKey) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := version_getBaseMetadata()
+	meta.key = pub
+	if _, err = rand.Read(meta.nonce[:]); err != nil {
+		t.Fatal(err)
+	}
+	return &meta, priv
+}
+
+func TestVersion_EncodeDecode(t *testing.T) {
+	meta, _ := newTestMetadata(t)
+	bs := meta.encode()
+	var decoded version_metadata
+	if !decoded.decode(bs) {
+		t.Fatal("failed to decode metadata")
+	}
+	if !decoded.check() {
+		t.Fatal("decoded metadata failed version check")
+	}
+	if !bytes.Equal(decoded.key, meta.key) || decoded.nonce != meta.nonce {
+		t.Fatal("decoded metadata does not match encoded metadata")
+	}
+	if decoded.decode(bs[:len(bs)-1]) {
+		t.Fatal("decoded truncated metadata")
+	}
+}
+
+func TestVersion_SignVerify(t *testing.T) {
+	a, secretA := newTestMetadata(t)
+	b, secretB := newTestMetadata(t)
+
+	sigA := a.sign(secretA, b)
+	sigB := b.sign(secretB, a)
+	if !b.verify(a, sigA) {
+		t.Fatal("valid signature from A rejected")
+	}
+	if !a.verify(b, sigB) {
+		t.Fatal("valid signature from B rejected")
+	}
+
+	// A signature over someone else's nonce must not be accepted.
+	c, _ := newTestMetadata(t)
+	if c.verify(a, sigA) {
+		t.Fatal("signature accepted for a different nonce")
+	}
+
+	// Claiming A's key without holding A's private key must fail.
+	mallory, secretM := newTestMetadata(t)
+	mallory.key = a.key
+	if b.verify(mallory, mallory.sign(secretM, b)) {
+		t.Fatal("signature accepted from the wrong private key")
+	}
+}
@@ -0,0 +1,390 @@
+package crawler
+
+// This maps the mesh by asking every node that can be found for its peers,
+// starting from this one
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RiV-chain/RiV-mesh/src/core"
+)
+
+const (
+	defaultConcurrency = 16
+	defaultRate        = 30
+)
+
+// Network is how the crawler learns about this node and asks others about
+// themselves. It is satisfied by *core.Core.
+type Network interface {
+	GetSelf() core.SelfInfo
+	GetPeers() []core.PeerInfo
+	GetThisNodeInfo() json.RawMessage
+	AddrForKey(publicKey ed25519.PublicKey) *core.Address
+	RemoteGetSelf(key string) (map[string]any, error)
+	RemoteGetPeers(key string) (map[string]any, error)
+	GetNodeInfo(key string) (map[string]any, error)
+}
+
+// Node is a node that was found while crawling. The build details come from
+// its nodeinfo, so they are missing if it keeps that private.
+type Node struct {
+	Key           string         `json:"key"`
+	Address       string         `json:"address"`
+	Depth         int            `json:"depth"` // hops from the node that crawled
+	Coords        []uint64       `json:"coords"`
+	Name          string         `json:"name,omitempty"`
+	BuildName     string         `json:"buildname,omitempty"`
+	BuildVersion  string         `json:"buildversion,omitempty"`
+	BuildPlatform string         `json:"buildplatform,omitempty"`
+	BuildArch     string         `json:"buildarch,omitempty"`
+	NodeInfo      map[string]any `json:"nodeinfo,omitempty"`
+	Error         string         `json:"error,omitempty"` // why it couldn't be asked about itself
+}
+
+// Edge is a peering between two nodes, by their keys. Edges have no
+// direction, and Source is always the lower key.
+type Edge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// Map is everything that was found by a crawl. Nodes are in the order they
+// were found, so nearer nodes come first.
+type Map struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Complete bool      `json:"complete"` // false if the crawl was cancelled
+	Nodes    []Node    `json:"nodes"`
+	Edges    []Edge    `json:"edges"`
+}
+
+// Progress is how far a crawl has got.
+type Progress struct {
+	Depth  int `json:"depth"`  // of the nodes being asked now
+	Found  int `json:"found"`  // nodes found, including this one
+	Asked  int `json:"asked"`  // nodes that have been asked about themselves
+	Failed int `json:"failed"` // nodes that didn't answer
+	Queued int `json:"queued"` // nodes found that haven't been asked yet
+	Edges  int `json:"edges"`
+}
+
+// Crawler maps the mesh breadth first, so that the nodes nearest to this one
+// are found first. Nodes are asked about themselves with the same remote
+// debug requests that can be made through the admin API, with limits on how
+// many are asked at once and how many requests are sent each second, so that
+// mapping a large mesh doesn't flood it.
+type Crawler struct {
+	network Network
+	log     core.Logger
+	config  struct {
+		concurrency Concurrency
+		rate        Rate
+		maxDepth    MaxDepth
+	}
+}
+
+// New returns a crawler, which does nothing until Crawl or Start is called.
+func New(n Network, log core.Logger, opts ...SetupOption) *Crawler {
+	c := &Crawler{
+		network: n,
+		log:     log,
+	}
+	c.config.concurrency = defaultConcurrency
+	c.config.rate = defaultRate
+	for _, opt := range opts {
+		c._applyOption(opt)
+	}
+	if c.config.concurrency <= 0 {
+		c.config.concurrency = defaultConcurrency
+	}
+	if c.config.rate <= 0 {
+		c.config.rate = defaultRate
+	}
+	return c
+}
+
+// Crawl maps the mesh, calling progress, if it isn't nil, each time a node
+// has been asked about itself. It is called from one goroutine at a time. If
+// the context is cancelled then Crawl returns what was found until then.
+func (c *Crawler) Crawl(ctx context.Context, progress func(Progress)) *Map {
+	ticker := time.NewTicker(time.Second / time.Duration(c.config.rate))
+	defer ticker.Stop()
+	s := &crawl{
+		Crawler: c,
+		ctx:     ctx,
+		limit:   ticker.C,
+		nodes:   make(map[string]*Node),
+		edges:   make(map[Edge]struct{}),
+		report:  progress,
+	}
+	m := &Map{Started: time.Now()}
+
+	// This node is found without asking the mesh.
+	self := c.network.GetSelf()
+	key := hex.EncodeToString(self.Key)
+	s.found(key, 0)
+	node := s.nodes[key]
+	node.Coords = self.Coords
+	var nodeinfo map[string]any
+	if err := json.Unmarshal(c.network.GetThisNodeInfo(), &nodeinfo); err == nil {
+		node.setNodeInfo(nodeinfo)
+	}
+	var peers []string
+	for _, peer := range c.network.GetPeers() {
+		peers = append(peers, hex.EncodeToString(peer.Key))
+	}
+	frontier := s.peered(key, peers, 1)
+	s.mutex.Lock()
+	s.progress.Asked++
+	s._report()
+	s.mutex.Unlock()
+
+	for depth := 1; len(frontier) > 0 && ctx.Err() == nil; depth++ {
+		s.mutex.Lock()
+		s.progress.Depth = depth
+		s.mutex.Unlock()
+		frontier = s.visitAll(frontier, depth)
+	}
+
+	m.Finished = time.Now()
+	m.Complete = ctx.Err() == nil
+	for _, key := range s.order {
+		m.Nodes = append(m.Nodes, *s.nodes[key])
+	}
+	m.Edges = make([]Edge, 0, len(s.edges))
+	for edge := range s.edges {
+		m.Edges = append(m.Edges, edge)
+	}
+	sort.Slice(m.Edges, func(i, j int) bool {
+		if m.Edges[i].Source != m.Edges[j].Source {
+			return m.Edges[i].Source < m.Edges[j].Source
+		}
+		return m.Edges[i].Target < m.Edges[j].Target
+	})
+	return m
+}
+
+// crawl is the state of a single crawl.
+type crawl struct {
+	*Crawler
+	ctx      context.Context
+	limit    <-chan time.Time
+	mutex    sync.Mutex
+	nodes    map[string]*Node
+	order    []string
+	edges    map[Edge]struct{}
+	progress Progress
+	report   func(Progress)
+}
+
+// visitAll asks each of the nodes at a depth about themselves, and returns
+// the nodes found through them, which are one hop further away.
+func (s *crawl) visitAll(keys []string, depth int) []string {
+	var next []string
+	var nextMutex sync.Mutex
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, s.config.concurrency)
+loop:
+	for _, key := range keys {
+		select {
+		case <-s.ctx.Done():
+			break loop
+		case workers <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			found := s.visit(key, depth)
+			nextMutex.Lock()
+			next = append(next, found...)
+			nextMutex.Unlock()
+		}(key)
+	}
+	wg.Wait()
+	return next
+}
+
+// visit asks a node about itself and its peers, and returns the peers that
+// hadn't been found before.
+func (s *crawl) visit(key string, depth int) []string {
+	var coords []uint64
+	result, err := s.ask(s.network.RemoteGetSelf, key)
+	if err == nil {
+		coords, err = parseCoords(result)
+	}
+	if err != nil && s.ctx.Err() != nil {
+		// Cancelled before it could be asked, rather than not answering.
+		return nil
+	} else if err != nil {
+		s.log.Debugln("Crawler couldn't get details of", key, "because:", err)
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.nodes[key].Error = err.Error()
+		s.progress.Asked++
+		s.progress.Failed++
+		s.progress.Queued--
+		s._report()
+		return nil
+	}
+	var peers []string
+	if s.config.maxDepth == 0 || depth < int(s.config.maxDepth) {
+		if result, err = s.ask(s.network.RemoteGetPeers, key); err == nil {
+			peers, err = parsePeers(result)
+		}
+		if err != nil {
+			s.log.Debugln("Crawler couldn't get peers of", key, "because:", err)
+		}
+	}
+	// Nodeinfo is optional, so nodes without it are still mapped.
+	var nodeinfo map[string]any
+	if result, err = s.ask(s.network.GetNodeInfo, key); err == nil {
+		nodeinfo, _ = result[key].(map[string]any)
+	}
+	s.mutex.Lock()
+	node := s.nodes[key]
+	node.Coords = coords
+	node.setNodeInfo(nodeinfo)
+	s.progress.Asked++
+	s.progress.Queued--
+	s.mutex.Unlock()
+	return s.peered(key, peers, depth+1)
+}
+
+// ask makes a request to a node once the rate limit allows it.
+func (s *crawl) ask(fn func(key string) (map[string]any, error), key string) (map[string]any, error) {
+	select {
+	case <-s.ctx.Done():
+		return nil, s.ctx.Err()
+	case <-s.limit:
+	}
+	return fn(key)
+}
+
+// peered records the peers of a node, returning those that are new.
+func (s *crawl) peered(key string, peers []string, depth int) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var found []string
+	for _, peer := range peers {
+		if peer == key {
+			continue
+		}
+		edge := Edge{Source: key, Target: peer}
+		if edge.Target < edge.Source {
+			edge.Source, edge.Target = edge.Target, edge.Source
+		}
+		s.edges[edge] = struct{}{}
+		if s._found(peer, depth) {
+			found = append(found, peer)
+		}
+	}
+	s.progress.Edges = len(s.edges)
+	s.progress.Queued += len(found)
+	s._report()
+	return found
+}
+
+func (s *crawl) found(key string, depth int) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s._found(key, depth)
+}
+
+// _found adds a node if it is new, returning false if it isn't.
+func (s *crawl) _found(key string, depth int) bool {
+	if _, ok := s.nodes[key]; ok {
+		return false
+	}
+	kbs, _ := hex.DecodeString(key)
+	addr := s.network.AddrForKey(kbs)
+	s.nodes[key] = &Node{
+		Key:     key,
+		Address: net.IP(addr[:]).String(),
+		Depth:   depth,
+		Coords:  []uint64{},
+	}
+	s.order = append(s.order, key)
+	s.progress.Found++
+	return true
+}
+
+func (s *crawl) _report() {
+	if s.report != nil {
+		s.report(s.progress)
+	}
+}
+
+// setNodeInfo keeps a node's nodeinfo, picking out the fields that are set
+// by default.
+func (n *Node) setNodeInfo(nodeinfo map[string]any) {
+	if nodeinfo == nil {
+		return
+	}
+	n.NodeInfo = nodeinfo
+	n.Name, _ = nodeinfo["name"].(string)
+	n.BuildName, _ = nodeinfo["buildname"].(string)
+	n.BuildVersion, _ = nodeinfo["buildversion"].(string)
+	n.BuildPlatform, _ = nodeinfo["buildplatform"].(string)
+	n.BuildArch, _ = nodeinfo["buildarch"].(string)
+}
+
+// response returns the body of a remote debug response, which is keyed by
+// the node's address.
+func response(result map[string]any) (map[string]any, error) {
+	for _, v := range result {
+		if body, ok := v.(map[string]any); ok {
+			return body, nil
+		}
+	}
+	return nil, errors.New("empty response")
+}
+
+// parseCoords reads the coordinates from a getSelf response, where they are
+// formatted like [1 2 3].
+func parseCoords(result map[string]any) ([]uint64, error) {
+	body, err := response(result)
+	if err != nil {
+		return nil, err
+	}
+	s, _ := body["coords"].(string)
+	coords := []uint64{}
+	for _, field := range strings.Fields(strings.Trim(s, "[]")) {
+		coord, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid coords %q", s)
+		}
+		coords = append(coords, coord)
+	}
+	return coords, nil
+}
+
+// parsePeers reads the keys from a getPeers response.
+func parsePeers(result map[string]any) ([]string, error) {
+	body, err := response(result)
+	if err != nil {
+		return nil, err
+	}
+	keys, _ := body["keys"].([]any)
+	peers := make([]string, 0, len(keys))
+	for _, k := range keys {
+		key, _ := k.(string)
+		if kbs, err := hex.DecodeString(key); err != nil || len(kbs) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid peer key %q", key)
+		}
+		peers = append(peers, strings.ToLower(key))
+	}
+	return peers, nil
+}
@@ -0,0 +1,207 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gologme/log"
+
+	"github.com/RiV-chain/RiV-mesh/src/core"
+)
+
+// testNetwork is a made up mesh, which answers for the nodes in it as they
+// would. The node's own details come from the real core.
+type testNetwork struct {
+	*core.Core
+	self   string
+	peers  map[string][]string // of every node, including this one
+	down   map[string]bool     // nodes that don't answer
+	coords map[string]string
+}
+
+func (n *testNetwork) GetSelf() core.SelfInfo {
+	key, _ := hex.DecodeString(n.self)
+	return core.SelfInfo{Key: key, Coords: []uint64{}}
+}
+
+func (n *testNetwork) GetPeers() []core.PeerInfo {
+	var peers []core.PeerInfo
+	for _, peer := range n.peers[n.self] {
+		key, _ := hex.DecodeString(peer)
+		// Peers with more than one link show up more than once.
+		peers = append(peers, core.PeerInfo{Key: key}, core.PeerInfo{Key: key})
+	}
+	return peers
+}
+
+func (n *testNetwork) GetThisNodeInfo() json.RawMessage {
+	return json.RawMessage(`{"name":"self","buildversion":"1.0"}`)
+}
+
+func (n *testNetwork) answer(key string, body any) (map[string]any, error) {
+	if n.down[key] {
+		return nil, core.ErrTimeout
+	}
+	kbs, _ := hex.DecodeString(key)
+	ip := net.IP(n.AddrForKey(kbs)[:]).String()
+	bs, _ := json.Marshal(map[string]any{ip: body})
+	var result map[string]any
+	return result, json.Unmarshal(bs, &result)
+}
+
+func (n *testNetwork) RemoteGetSelf(key string) (map[string]any, error) {
+	return n.answer(key, map[string]string{"key": key, "coords": n.coords[key]})
+}
+
+func (n *testNetwork) RemoteGetPeers(key string) (map[string]any, error) {
+	return n.answer(key, map[string][]string{"keys": n.peers[key]})
+}
+
+func (n *testNetwork) GetNodeInfo(key string) (map[string]any, error) {
+	if n.down[key] {
+		return nil, core.ErrTimeout
+	}
+	return map[string]any{key: map[string]any{"name": "node-" + key[:4], "buildversion": "1.0"}}, nil
+}
+
+// newTestNetwork makes a mesh of nodes in a line, self - a - b - c, with d
+// also peered with a but not answering.
+func newTestNetwork(t *testing.T) (*testNetwork, []string) {
+	_, sk, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := log.New(os.Stderr, "", log.Flags())
+	logger.SetCallDepth(2)
+	c, err := core.New(sk, logger, core.NetworkDomain{Prefix: "fc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Stop)
+	keys := make([]string, 5)
+	for i := range keys {
+		pub, _, _ := ed25519.GenerateKey(nil)
+		keys[i] = hex.EncodeToString(pub)
+	}
+	self, a, b, cc, d := keys[0], keys[1], keys[2], keys[3], keys[4]
+	n := &testNetwork{
+		Core: c,
+		self: self,
+		peers: map[string][]string{
+			self: {a},
+			a:    {self, b, d},
+			b:    {a, cc},
+			cc:   {b},
+		},
+		down:   map[string]bool{d: true},
+		coords: map[string]string{},
+	}
+	for i, key := range keys {
+		n.coords[key] = fmt.Sprint([]uint64{uint64(i), 1})
+	}
+	return n, keys
+}
+
+func TestCrawler(t *testing.T) {
+	n, keys := newTestNetwork(t)
+	logger := log.New(os.Stderr, "", log.Flags())
+	var last Progress
+	m := New(n, logger, Rate(1000)).Crawl(context.Background(), func(p Progress) {
+		last = p
+	})
+
+	if !m.Complete {
+		t.Fatal("crawl wasn't complete")
+	}
+	if len(m.Nodes) != len(keys) {
+		t.Fatalf("found %d nodes, expected %d", len(m.Nodes), len(keys))
+	}
+	depths := map[string]int{keys[0]: 0, keys[1]: 1, keys[2]: 2, keys[3]: 3, keys[4]: 2}
+	for i, node := range m.Nodes {
+		if node.Depth != depths[node.Key] {
+			t.Fatalf("node %s at depth %d, expected %d", node.Key, node.Depth, depths[node.Key])
+		}
+		if i > 0 && node.Depth < m.Nodes[i-1].Depth {
+			t.Fatal("nodes weren't found breadth first")
+		}
+		switch {
+		case node.Key == keys[0]:
+			if node.Name != "self" {
+				t.Fatalf("got name %q for this node", node.Name)
+			}
+		case node.Key == keys[4]:
+			if node.Error == "" {
+				t.Fatal("expected an error for a node that didn't answer")
+			}
+		default:
+			if node.Error != "" || node.BuildVersion != "1.0" || n.coords[node.Key] != fmt.Sprint(node.Coords) {
+				t.Fatalf("unexpected details %+v", node)
+			}
+		}
+	}
+	if len(m.Edges) != 4 {
+		t.Fatalf("found %d edges, expected 4", len(m.Edges))
+	}
+	expected := Progress{Depth: 3, Found: 5, Asked: 5, Failed: 1, Queued: 0, Edges: 4}
+	if last != expected {
+		t.Fatalf("got progress %+v, expected %+v", last, expected)
+	}
+
+	// Nodes at the maximum depth are found, but nothing past them.
+	m = New(n, logger, Rate(1000), MaxDepth(2)).Crawl(context.Background(), nil)
+	if len(m.Nodes) != 4 || len(m.Edges) != 3 {
+		t.Fatalf("found %d nodes and %d edges with a maximum depth", len(m.Nodes), len(m.Edges))
+	}
+
+	var dot bytes.Buffer
+	if err := m.Write(&dot, FormatDOT); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(dot.String(), " -- ") != len(m.Edges) || !strings.Contains(dot.String(), `label="self\n`) {
+		t.Fatalf("unexpected DOT output:\n%s", dot.String())
+	}
+	var buf bytes.Buffer
+	if err := m.Write(&buf, FormatGEXF); err != nil {
+		t.Fatal(err)
+	}
+	var g gexf
+	if err := xml.Unmarshal(buf.Bytes(), &g); err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Graph.Nodes) != len(m.Nodes) || len(g.Graph.Edges) != len(m.Edges) {
+		t.Fatalf("unexpected GEXF output:\n%s", buf.String())
+	}
+	if err := m.Write(&buf, "png"); err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
+
+func TestJob(t *testing.T) {
+	n, keys := newTestNetwork(t)
+	j := New(n, log.New(os.Stderr, "", log.Flags()), Rate(1000)).Start()
+	updates, _ := j.Watch()
+	for range updates {
+	}
+	<-j.Done()
+	if m := j.Result(); m == nil || len(m.Nodes) != len(keys) {
+		t.Fatalf("unexpected result %+v", m)
+	}
+	if p := j.Progress(); p.Found != len(keys) {
+		t.Fatalf("unexpected progress %+v", p)
+	}
+	// Watching a finished crawl ends straight away.
+	updates, stop := j.Watch()
+	if _, ok := <-updates; ok {
+		t.Fatal("expected no updates after the crawl finished")
+	}
+	stop()
+}
@@ -0,0 +1,102 @@
+package crawler
+
+import (
+	"context"
+	"sync"
+)
+
+// How many progress updates can be waiting for a watcher before newer ones
+// are dropped. Each update replaces the last, so a slow watcher only misses
+// steps along the way.
+const watchQueue = 16
+
+// Job is a crawl running in the background, which can be watched while it
+// runs and cancelled.
+type Job struct {
+	cancel   context.CancelFunc
+	done     chan struct{}
+	mutex    sync.Mutex
+	progress Progress
+	result   *Map
+	watchers map[chan Progress]struct{}
+}
+
+// Start crawls the mesh in the background.
+func (c *Crawler) Start() *Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &Job{
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		watchers: make(map[chan Progress]struct{}),
+	}
+	go func() {
+		m := c.Crawl(ctx, j.update)
+		cancel()
+		j.mutex.Lock()
+		j.result = m
+		for ch := range j.watchers {
+			close(ch)
+		}
+		j.watchers = nil
+		j.mutex.Unlock()
+		close(j.done)
+	}()
+	return j
+}
+
+func (j *Job) update(p Progress) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.progress = p
+	for ch := range j.watchers {
+		select {
+		case ch <- p:
+		default:
+		}
+	}
+}
+
+// Progress returns how far the crawl has got.
+func (j *Job) Progress() Progress {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.progress
+}
+
+// Result returns the map, or nil if the crawl hasn't finished yet.
+func (j *Job) Result() *Map {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.result
+}
+
+// Done is closed when the crawl has finished.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Cancel stops the crawl, keeping what was found until then as the result.
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+// Watch returns a channel of progress updates, which is closed when the
+// crawl finishes, and a function to stop watching early.
+func (j *Job) Watch() (<-chan Progress, func()) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	ch := make(chan Progress, watchQueue)
+	if j.watchers == nil {
+		close(ch)
+		return ch, func() {}
+	}
+	j.watchers[ch] = struct{}{}
+	return ch, func() {
+		j.mutex.Lock()
+		defer j.mutex.Unlock()
+		if _, ok := j.watchers[ch]; ok {
+			delete(j.watchers, ch)
+			close(ch)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package crawler
+
+func (c *Crawler) _applyOption(opt SetupOption) {
+	switch v := opt.(type) {
+	case Concurrency:
+		c.config.concurrency = v
+	case Rate:
+		c.config.rate = v
+	case MaxDepth:
+		c.config.maxDepth = v
+	}
+}
+
+type SetupOption interface {
+	isSetupOption()
+}
+
+// Concurrency is how many nodes are asked about themselves at once. The
+// default is 16.
+type Concurrency int
+
+// Rate is how many requests are sent each second, across all of the nodes
+// being asked. Each node takes up to three. The default is 30.
+type Rate int
+
+// MaxDepth is how many hops away from this node to crawl, where nodes at
+// that depth are found but not asked for their peers. The default, 0, is no
+// limit.
+type MaxDepth int
+
+func (a Concurrency) isSetupOption() {}
+func (a Rate) isSetupOption()        {}
+func (a MaxDepth) isSetupOption()    {}
@@ -0,0 +1,189 @@
+package crawler
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Formats that a map can be written in.
+const (
+	FormatJSON = "json"
+	FormatDOT  = "dot"  // GraphViz
+	FormatGEXF = "gexf" // Gephi and other graph tools
+)
+
+// Write writes the map in one of the formats.
+func (m *Map) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatJSON, "":
+		return json.NewEncoder(w).Encode(m)
+	case FormatDOT:
+		return m.WriteDOT(w)
+	case FormatGEXF:
+		return m.WriteGEXF(w)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// WriteDOT writes the map as an undirected GraphViz graph, with each node
+// labelled by its name, if it has one, and its address.
+func (m *Map) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("graph mesh {\n")
+	for _, node := range m.Nodes {
+		label := node.Address
+		if node.Name != "" {
+			label = node.Name + "\n" + label
+		}
+		fmt.Fprintf(&b, "\t%s [label=%s", dotQuote(node.Key), dotQuote(label))
+		if node.Depth == 0 {
+			b.WriteString(", shape=doublecircle")
+		} else if node.Error != "" {
+			b.WriteString(", style=dashed")
+		}
+		b.WriteString("];\n")
+	}
+	for _, edge := range m.Edges {
+		fmt.Fprintf(&b, "\t%s -- %s;\n", dotQuote(edge.Source), dotQuote(edge.Target))
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+// The parts of GEXF 1.3 that are needed for a static, undirected graph with
+// attributes on the nodes.
+type gexf struct {
+	XMLName xml.Name  `xml:"gexf"`
+	XMLNS   string    `xml:"xmlns,attr"`
+	Version string    `xml:"version,attr"`
+	Meta    gexfMeta  `xml:"meta"`
+	Graph   gexfGraph `xml:"graph"`
+}
+
+type gexfMeta struct {
+	LastModified string `xml:"lastmodifieddate,attr"`
+	Creator      string `xml:"creator"`
+}
+
+type gexfGraph struct {
+	Mode       string         `xml:"mode,attr"`
+	EdgeType   string         `xml:"defaultedgetype,attr"`
+	Attributes gexfAttributes `xml:"attributes"`
+	Nodes      []gexfNode     `xml:"nodes>node"`
+	Edges      []gexfEdge     `xml:"edges>edge"`
+}
+
+type gexfAttributes struct {
+	Class      string          `xml:"class,attr"`
+	Attributes []gexfAttribute `xml:"attribute"`
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfNode struct {
+	ID        string         `xml:"id,attr"`
+	Label     string         `xml:"label,attr"`
+	AttValues []gexfAttValue `xml:"attvalues>attvalue"`
+}
+
+type gexfAttValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type gexfEdge struct {
+	ID     string `xml:"id,attr"`
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+// The node attributes, in the order of their IDs.
+var gexfNodeAttributes = []gexfAttribute{
+	{Title: "address", Type: "string"},
+	{Title: "depth", Type: "integer"},
+	{Title: "coords", Type: "string"},
+	{Title: "buildname", Type: "string"},
+	{Title: "buildversion", Type: "string"},
+	{Title: "buildplatform", Type: "string"},
+	{Title: "buildarch", Type: "string"},
+	{Title: "error", Type: "string"},
+}
+
+// WriteGEXF writes the map as a GEXF graph, with the details of each node as
+// attributes.
+func (m *Map) WriteGEXF(w io.Writer) error {
+	g := gexf{
+		XMLNS:   "http://gexf.net/1.3",
+		Version: "1.3",
+		Meta: gexfMeta{
+			LastModified: m.Finished.Format("2006-01-02"),
+			Creator:      "RiV-mesh",
+		},
+		Graph: gexfGraph{
+			Mode:       "static",
+			EdgeType:   "undirected",
+			Attributes: gexfAttributes{Class: "node"},
+		},
+	}
+	for i, attr := range gexfNodeAttributes {
+		attr.ID = strconv.Itoa(i)
+		g.Graph.Attributes.Attributes = append(g.Graph.Attributes.Attributes, attr)
+	}
+	for _, node := range m.Nodes {
+		label := node.Name
+		if label == "" {
+			label = node.Address
+		}
+		coords := make([]string, 0, len(node.Coords))
+		for _, coord := range node.Coords {
+			coords = append(coords, strconv.FormatUint(coord, 10))
+		}
+		values := []string{
+			node.Address,
+			strconv.Itoa(node.Depth),
+			"[" + strings.Join(coords, " ") + "]",
+			node.BuildName,
+			node.BuildVersion,
+			node.BuildPlatform,
+			node.BuildArch,
+			node.Error,
+		}
+		n := gexfNode{ID: node.Key, Label: label}
+		for i, value := range values {
+			if value != "" {
+				n.AttValues = append(n.AttValues, gexfAttValue{For: strconv.Itoa(i), Value: value})
+			}
+		}
+		g.Graph.Nodes = append(g.Graph.Nodes, n)
+	}
+	for i, edge := range m.Edges {
+		g.Graph.Edges = append(g.Graph.Edges, gexfEdge{ID: strconv.Itoa(i), Source: edge.Source, Target: edge.Target})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(g); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -14,6 +14,7 @@ import (
 
 type MulticastInterfaceConfig = config.MulticastInterfaceConfig
 type NetworkDomainConfig = config.NetworkDomainConfig
+type PeerReconnectConfig = config.PeerReconnectConfig
 
 var defaultConfig = "" // LDFLAGS='-X github.com/RiV-chain/RiV-mesh/src/defaults.defaultConfig=/path/to/config
 
@@ -26,6 +27,15 @@ type defaultParameters struct {
 
 	//Network domain
 	DefaultNetworkDomain NetworkDomainConfig
+
+	//Peer reconnect backoff
+	DefaultPeerReconnect PeerReconnectConfig
+
+	//Link timeout
+	DefaultLinkTimeout string
+
+	//DNS domain
+	DefaultDNSDomain string
 }
 
 // Defines which parameters are expected by default for configuration on a
@@ -57,6 +67,19 @@ func Define() defaultParameters {
 		DefaultNetworkDomain: NetworkDomainConfig{
 			Prefix: "fc",
 		},
+
+		// Peer reconnect backoff
+		DefaultPeerReconnect: PeerReconnectConfig{
+			MinInterval: "1s",
+			MaxInterval: "1m",
+			Jitter:      0.2,
+		},
+
+		// Link timeout
+		DefaultLinkTimeout: "30s",
+
+		// DNS domain
+		DefaultDNSDomain: "mesh",
 	}
 }
 
@@ -81,6 +104,12 @@ func GenerateConfig() *config.NodeConfig {
 	cfg.Peers = []string{}
 	cfg.InterfacePeers = map[string][]string{}
 	cfg.AllowedPublicKeys = []string{}
+	cfg.DeniedPublicKeys = []string{}
+	cfg.DeniedNetworks = []string{}
+	cfg.ProxyListen = []string{}
+	cfg.Forwards = []config.ForwardConfig{}
+	cfg.DNSListen = []string{}
+	cfg.DNSDomain = Define().DefaultDNSDomain
 	cfg.MulticastInterfaces = defaults.DefaultMulticastInterfaces
 	cfg.IfName = defaults.DefaultIfName
 	cfg.IfMTU = defaults.DefaultIfMTU
@@ -88,6 +117,8 @@ func GenerateConfig() *config.NodeConfig {
 	cfg.HttpAddress = Define().DefaultHttpAddress
 	cfg.NetworkDomain = Define().DefaultNetworkDomain
 	cfg.PublicPeersUrl = Define().DefaultPublicPeersUrl
+	cfg.PeerReconnect = Define().DefaultPeerReconnect
+	cfg.LinkTimeout = Define().DefaultLinkTimeout
 
 	return cfg
 }
@@ -0,0 +1,284 @@
+package dns
+
+// This answers DNS queries for names in the mesh, so that nodes can be
+// reached without typing their addresses
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/RiV-chain/RiV-mesh/src/core"
+)
+
+// Addresses that are worked out from keys never change, but names do.
+const (
+	keyTTL  = 3600
+	nameTTL = 60
+)
+
+// KeyFinder finds the full public key that a mesh address belongs to, which
+// can't be worked out from the address alone. It is satisfied by the TUN
+// adapter and the userspace stack in the netstack package, which learn keys
+// from the traffic that they send and receive.
+type KeyFinder interface {
+	KeyForAddress(ip net.IP) ed25519.PublicKey
+}
+
+// Server answers DNS queries for names in its domain, which is "mesh" unless
+// set otherwise:
+//
+//   - AAAA queries for <hex public key>.<domain>, with the node's address.
+//     A key is too long for a single label, so it can be split across as
+//     many as needed, e.g. into two halves.
+//   - AAAA queries for <name>.<domain>, where name is the "name" field in a
+//     known node's nodeinfo. Names are neither unique nor verified, so they
+//     are only answered if a single node uses them.
+//   - PTR queries for mesh addresses, with <hex public key>.<domain> split
+//     into halves, if the key is known.
+//
+// Anything else is refused, as it doesn't resolve names outside of the mesh.
+type Server struct {
+	core   *core.Core
+	keys   KeyFinder
+	log    core.Logger
+	conns  []net.PacketConn
+	names  names
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	config struct {
+		listen []ListenAddress
+		domain Domain
+	}
+}
+
+// New starts answering queries on each of the listen addresses. The keys can
+// be nil, in which case PTR queries are only answered for nodes that are
+// peered with, or have paths or sessions with, this one.
+func New(c *core.Core, keys KeyFinder, log core.Logger, opts ...SetupOption) (*Server, error) {
+	s := &Server{
+		core: c,
+		keys: keys,
+		log:  log,
+	}
+	s.config.domain = "mesh"
+	for _, opt := range opts {
+		s._applyOption(opt)
+	}
+	s.config.domain = Domain(strings.Trim(strings.ToLower(string(s.config.domain)), "."))
+	for _, addr := range s.config.listen {
+		conn, err := net.ListenPacket("udp", string(addr))
+		if err != nil {
+			for _, conn := range s.conns {
+				_ = conn.Close()
+			}
+			return nil, fmt.Errorf("failed to start DNS server on %s: %w", addr, err)
+		}
+		s.conns = append(s.conns, conn)
+	}
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	s.names.init(c, log)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.names.run(ctx)
+	}()
+	for _, conn := range s.conns {
+		s.log.Infof("DNS server answering for .%s on %s", s.config.domain, conn.LocalAddr())
+		s.wg.Add(1)
+		go func(conn net.PacketConn) {
+			defer s.wg.Done()
+			s.serve(conn)
+		}(conn)
+	}
+	return s, nil
+}
+
+// Addrs returns the addresses that queries are answered on.
+func (s *Server) Addrs() []net.Addr {
+	addrs := make([]net.Addr, 0, len(s.conns))
+	for _, conn := range s.conns {
+		addrs = append(addrs, conn.LocalAddr())
+	}
+	return addrs
+}
+
+// Stop stops answering queries.
+func (s *Server) Stop() error {
+	s.cancel()
+	for _, conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) serve(conn net.PacketConn) {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.log.Errorln("DNS server failed to read:", err)
+			}
+			return
+		}
+		reply, err := s.answer(buf[:n])
+		if err != nil {
+			s.log.Debugln("DNS server dropped a query from", from, "because:", err)
+			continue
+		}
+		_, _ = conn.WriteTo(reply, from)
+	}
+}
+
+// answer returns the reply to a query.
+func (s *Server) answer(query []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Response {
+		return nil, errors.New("not a query")
+	}
+	reply := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               hdr.ID,
+			Response:         true,
+			OpCode:           hdr.OpCode,
+			RecursionDesired: hdr.RecursionDesired,
+		},
+	}
+	q, err := p.Question()
+	switch {
+	case hdr.OpCode != 0:
+		reply.RCode = dnsmessage.RCodeNotImplemented
+	case err != nil:
+		reply.RCode = dnsmessage.RCodeFormatError
+	default:
+		reply.Questions = []dnsmessage.Question{q}
+		reply.Answers, reply.RCode = s.resolve(q)
+		reply.Authoritative = reply.RCode != dnsmessage.RCodeRefused
+	}
+	return reply.Pack()
+}
+
+func (s *Server) resolve(q dnsmessage.Question) ([]dnsmessage.Resource, dnsmessage.RCode) {
+	name := strings.ToLower(q.Name.String())
+	domain := "." + string(s.config.domain) + "."
+	switch {
+	case q.Class != dnsmessage.ClassINET:
+		return nil, dnsmessage.RCodeRefused
+	case name == domain[1:]:
+		return nil, dnsmessage.RCodeSuccess
+	case strings.HasSuffix(name, domain):
+		key, ttl := s.lookup(strings.TrimSuffix(name, domain))
+		if key == nil {
+			return nil, dnsmessage.RCodeNameError
+		}
+		if q.Type != dnsmessage.TypeAAAA && q.Type != dnsmessage.TypeALL {
+			return nil, dnsmessage.RCodeSuccess
+		}
+		return []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeAAAA, Class: dnsmessage.ClassINET, TTL: ttl},
+			Body:   &dnsmessage.AAAAResource{AAAA: *s.core.AddrForKey(key)},
+		}}, dnsmessage.RCodeSuccess
+	case strings.HasSuffix(name, ".ip6.arpa."):
+		ip := reverseIP(strings.TrimSuffix(name, ".ip6.arpa."))
+		if ip == nil || !s.isMeshIP(ip) {
+			return nil, dnsmessage.RCodeRefused
+		}
+		key := s.keyFor(ip)
+		if key == nil {
+			return nil, dnsmessage.RCodeNameError
+		}
+		if q.Type != dnsmessage.TypePTR && q.Type != dnsmessage.TypeALL {
+			return nil, dnsmessage.RCodeSuccess
+		}
+		ptr, err := dnsmessage.NewName(keyName(key) + domain)
+		if err != nil {
+			return nil, dnsmessage.RCodeServerFailure
+		}
+		return []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET, TTL: keyTTL},
+			Body:   &dnsmessage.PTRResource{PTR: ptr},
+		}}, dnsmessage.RCodeSuccess
+	default:
+		return nil, dnsmessage.RCodeRefused
+	}
+}
+
+// lookup finds the key for a name in the domain, either a key itself or a
+// nodeinfo name, and how long the answer can be cached for.
+func (s *Server) lookup(label string) (ed25519.PublicKey, uint32) {
+	if key, err := hex.DecodeString(strings.ReplaceAll(label, ".", "")); err == nil && len(key) == ed25519.PublicKeySize {
+		return key, keyTTL
+	}
+	if key := s.names.lookup(label); key != nil {
+		return key, nameTTL
+	}
+	return nil, 0
+}
+
+// keyFor finds the full key for a mesh address, if it is known.
+func (s *Server) keyFor(ip net.IP) ed25519.PublicKey {
+	if s.keys != nil {
+		if key := s.keys.KeyForAddress(ip); key != nil {
+			return key
+		}
+	}
+	var addr core.Address
+	var subnet core.Subnet
+	copy(addr[:], ip)
+	copy(subnet[:], ip)
+	for _, key := range append(s.names.knownKeys(), s.core.PublicKey()) {
+		if *s.core.AddrForKey(key) == addr || *s.core.SubnetForKey(key) == subnet {
+			return key
+		}
+	}
+	return nil
+}
+
+// keyName returns a key as two labels, each short enough for DNS.
+func keyName(key ed25519.PublicKey) string {
+	half := len(key) / 2
+	return hex.EncodeToString(key[:half]) + "." + hex.EncodeToString(key[half:])
+}
+
+func (s *Server) isMeshIP(ip net.IP) bool {
+	var addr core.Address
+	var subnet core.Subnet
+	copy(addr[:], ip)
+	copy(subnet[:], ip)
+	return s.core.IsValidAddress(addr) || s.core.IsValidSubnet(subnet)
+}
+
+// reverseIP parses the nibbles of an ip6.arpa name, least significant
+// first, returning nil unless there are exactly enough for an address.
+func reverseIP(nibbles string) net.IP {
+	labels := strings.Split(nibbles, ".")
+	if len(labels) != 2*net.IPv6len {
+		return nil
+	}
+	var digits strings.Builder
+	for i := len(labels) - 1; i >= 0; i-- {
+		if len(labels[i]) != 1 {
+			return nil
+		}
+		digits.WriteString(labels[i])
+	}
+	ip, err := hex.DecodeString(digits.String())
+	if err != nil {
+		return nil
+	}
+	return ip
+}
@@ -0,0 +1,121 @@
+package dns
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gologme/log"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/RiV-chain/RiV-mesh/src/core"
+)
+
+// query asks the server a single question and returns the reply.
+func query(t *testing.T, addr net.Addr, name string, qtype dnsmessage.Type) *dnsmessage.Message {
+	t.Helper()
+	q := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: 1234, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(name),
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	msg, err := q.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = conn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply dnsmessage.Message
+	if err = reply.Unpack(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if reply.ID != q.ID || !reply.Response {
+		t.Fatalf("unexpected reply header %+v", reply.Header)
+	}
+	return &reply
+}
+
+// reverseName returns the ip6.arpa name for an address.
+func reverseName(ip net.IP) string {
+	digits := hex.EncodeToString(ip)
+	var b strings.Builder
+	for i := len(digits) - 1; i >= 0; i-- {
+		b.WriteByte(digits[i])
+		b.WriteByte('.')
+	}
+	return b.String() + "ip6.arpa."
+}
+
+func TestServer(t *testing.T) {
+	_, sk, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := log.New(os.Stderr, "", log.Flags())
+	logger.SetCallDepth(2)
+	c, err := core.New(sk, logger, core.NetworkDomain{Prefix: "fc"}, core.NodeInfo{"name": "Alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	s, err := New(c, nil, logger, ListenAddress("127.0.0.1:0"), Domain("Example."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	addr := s.Addrs()[0]
+	ours := c.Address()
+	keyName := keyName(c.PublicKey()) + ".example."
+
+	for _, name := range []string{keyName, "alice.example.", "ALICE.Example."} {
+		reply := query(t, addr, name, dnsmessage.TypeAAAA)
+		if reply.RCode != dnsmessage.RCodeSuccess || len(reply.Answers) != 1 {
+			t.Fatalf("%s: got %v with %d answers", name, reply.RCode, len(reply.Answers))
+		}
+		aaaa, ok := reply.Answers[0].Body.(*dnsmessage.AAAAResource)
+		if !ok || !net.IP(aaaa.AAAA[:]).Equal(ours) {
+			t.Fatalf("%s: got %v, expected %s", name, reply.Answers[0].Body, ours)
+		}
+	}
+
+	reply := query(t, addr, reverseName(ours), dnsmessage.TypePTR)
+	if reply.RCode != dnsmessage.RCodeSuccess || len(reply.Answers) != 1 {
+		t.Fatalf("PTR: got %v with %d answers", reply.RCode, len(reply.Answers))
+	}
+	if ptr, ok := reply.Answers[0].Body.(*dnsmessage.PTRResource); !ok || ptr.PTR.String() != keyName {
+		t.Fatalf("PTR: got %v, expected %s", reply.Answers[0].Body, keyName)
+	}
+
+	// Names that exist, but not with the type that was asked for.
+	if reply = query(t, addr, keyName, dnsmessage.TypeA); reply.RCode != dnsmessage.RCodeSuccess || len(reply.Answers) != 0 {
+		t.Fatalf("A: got %v with %d answers", reply.RCode, len(reply.Answers))
+	}
+	if reply = query(t, addr, "bob.example.", dnsmessage.TypeAAAA); reply.RCode != dnsmessage.RCodeNameError {
+		t.Fatalf("unknown name: got %v", reply.RCode)
+	}
+	if reply = query(t, addr, "example.com.", dnsmessage.TypeAAAA); reply.RCode != dnsmessage.RCodeRefused {
+		t.Fatalf("name outside of the domain: got %v", reply.RCode)
+	}
+	if reply = query(t, addr, reverseName(net.ParseIP("2001:db8::1")), dnsmessage.TypePTR); reply.RCode != dnsmessage.RCodeRefused {
+		t.Fatalf("address outside of the mesh: got %v", reply.RCode)
+	}
+}
@@ -0,0 +1,204 @@
+package dns
+
+// This keeps track of the names that known nodes give themselves in their
+// nodeinfo, so that they can be looked up without asking the whole mesh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RiV-chain/RiV-mesh/src/core"
+)
+
+const (
+	// How often known nodes are checked for names that need fetching.
+	nameCheck = time.Minute
+	// How long to wait before asking a node for its nodeinfo again.
+	nameRefresh = 5 * time.Minute
+	// How long a name is answered for after it was last fetched.
+	nameExpiry = 15 * time.Minute
+	// How many nodeinfo requests are made at once.
+	nameFetches = 8
+)
+
+type keyArray [ed25519.PublicKeySize]byte
+
+type nameEntry struct {
+	name    string
+	fetched time.Time // when the name was last fetched
+	tried   time.Time // when the nodeinfo was last asked for
+}
+
+type names struct {
+	core    *core.Core
+	log     core.Logger
+	mutex   sync.Mutex
+	entries map[keyArray]*nameEntry
+	kick    chan struct{}
+}
+
+func (n *names) init(c *core.Core, log core.Logger) {
+	n.core = c
+	n.log = log
+	n.entries = make(map[keyArray]*nameEntry)
+	n.kick = make(chan struct{}, 1)
+}
+
+// run fetches names until the context is cancelled.
+func (n *names) run(ctx context.Context) {
+	ticker := time.NewTicker(nameCheck)
+	defer ticker.Stop()
+	for {
+		n.refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-n.kick:
+		}
+	}
+}
+
+// refresh fetches the names of known nodes that haven't been asked recently,
+// and forgets the names of nodes that have gone away.
+func (n *names) refresh(ctx context.Context) {
+	now := time.Now()
+	known := n.knownKeys()
+	var stale []keyArray
+	n.mutex.Lock()
+	for _, key := range known {
+		var k keyArray
+		copy(k[:], key)
+		entry, ok := n.entries[k]
+		if !ok {
+			entry = &nameEntry{}
+			n.entries[k] = entry
+		}
+		if now.Sub(entry.tried) > nameRefresh {
+			entry.tried = now
+			stale = append(stale, k)
+		}
+	}
+	for k, entry := range n.entries {
+		if now.Sub(entry.tried) > nameExpiry && now.Sub(entry.fetched) > nameExpiry {
+			delete(n.entries, k)
+		}
+	}
+	n.mutex.Unlock()
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, nameFetches)
+	for _, k := range stale {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case limit <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(k keyArray) {
+			defer wg.Done()
+			defer func() { <-limit }()
+			name, err := n.fetch(k[:])
+			if err != nil {
+				n.log.Debugln("Unable to fetch the name of", hex.EncodeToString(k[:]), "because:", err)
+				return
+			}
+			n.mutex.Lock()
+			if entry, ok := n.entries[k]; ok {
+				entry.name = name
+				entry.fetched = time.Now()
+			}
+			n.mutex.Unlock()
+		}(k)
+	}
+	wg.Wait()
+}
+
+// fetch asks a node for its nodeinfo and returns the name in it, if any.
+func (n *names) fetch(key ed25519.PublicKey) (string, error) {
+	hexkey := hex.EncodeToString(key)
+	result, err := n.core.GetNodeInfo(hexkey)
+	if err != nil {
+		return "", err
+	}
+	nodeinfo, _ := result[hexkey].(map[string]any)
+	name, _ := nodeinfo["name"].(string)
+	return normaliseName(name), nil
+}
+
+// lookup returns the key of the only node that uses a name, or nil. Asking
+// for a name that isn't known makes the names of any new nodes be fetched
+// straight away, so that asking again shortly after might succeed.
+func (n *names) lookup(name string) ed25519.PublicKey {
+	name = normaliseName(name)
+	if name == "" {
+		return nil
+	}
+	var self map[string]any
+	if err := json.Unmarshal(n.core.GetThisNodeInfo(), &self); err == nil {
+		if ours, _ := self["name"].(string); normaliseName(ours) == name {
+			return n.core.PublicKey()
+		}
+	}
+	var found ed25519.PublicKey
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for k, entry := range n.entries {
+		if entry.name != name || time.Since(entry.fetched) > nameExpiry {
+			continue
+		}
+		if found != nil {
+			return nil
+		}
+		found = append(ed25519.PublicKey(nil), k[:]...)
+	}
+	if found == nil {
+		select {
+		case n.kick <- struct{}{}:
+		default:
+		}
+	}
+	return found
+}
+
+// knownKeys returns the keys of every node that this one knows of, other
+// than itself, without duplicates.
+func (n *names) knownKeys() []ed25519.PublicKey {
+	ours := n.core.PublicKey()
+	seen := make(map[keyArray]struct{})
+	var keys []ed25519.PublicKey
+	add := func(key ed25519.PublicKey) {
+		var k keyArray
+		if len(key) != len(k) || key.Equal(ours) {
+			return
+		}
+		copy(k[:], key)
+		if _, ok := seen[k]; !ok {
+			seen[k] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	for _, peer := range n.core.GetPeers() {
+		add(peer.Key)
+	}
+	for _, session := range n.core.GetSessions() {
+		add(session.Key)
+	}
+	for _, path := range n.core.GetPaths() {
+		add(path.Key)
+	}
+	for _, entry := range n.core.GetDHT() {
+		add(entry.Key)
+	}
+	return keys
+}
+
+// normaliseName makes names case-insensitive, as they are in DNS.
+func normaliseName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
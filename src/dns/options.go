@@ -0,0 +1,23 @@
+package dns
+
+func (s *Server) _applyOption(opt SetupOption) {
+	switch v := opt.(type) {
+	case ListenAddress:
+		s.config.listen = append(s.config.listen, v)
+	case Domain:
+		s.config.domain = v
+	}
+}
+
+type SetupOption interface {
+	isSetupOption()
+}
+
+// ListenAddress is a host:port to answer queries on, over UDP.
+type ListenAddress string
+
+// Domain is the domain that names are answered in. The default is "mesh".
+type Domain string
+
+func (a ListenAddress) isSetupOption() {}
+func (a Domain) isSetupOption()        {}
@@ -0,0 +1,497 @@
+package forward
+
+// This forwards TCP and UDP ports between this machine and the mesh, in the
+// style of SSH's -L and -R options
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RiV-chain/RiV-mesh/src/core"
+)
+
+// How long to wait for a connection to open.
+const dialTimeout = 30 * time.Second
+
+// How long a UDP flow is kept open without any traffic in either direction.
+const udpTimeout = 2 * time.Minute
+
+// Network is how forwards reach the mesh. It is satisfied by the userspace
+// stack in the netstack package, and can also be implemented on top of the
+// operating system when there is a TUN adapter.
+type Network interface {
+	DialTCP(ctx context.Context, raddr *net.TCPAddr) (net.Conn, error)
+	ListenTCP(port uint16) (net.Listener, error)
+	DialUDP(raddr *net.UDPAddr) (net.Conn, error)
+	ListenUDP(port uint16) (net.PacketConn, error)
+}
+
+// Forward is a port that is forwarded between this machine and the mesh.
+// Normally connections to Local are forwarded to Mesh. If Reverse is set,
+// then connections to Mesh, which is a port on this node's own mesh address,
+// are forwarded to Local instead.
+type Forward struct {
+	Protocol string `json:"protocol"` // "tcp" or "udp"
+	Local    string `json:"local"`    // host:port on this machine
+	Mesh     string `json:"mesh"`     // [address]:port in the mesh
+	Reverse  bool   `json:"reverse"`
+}
+
+func (fw Forward) String() string {
+	if fw.Reverse {
+		return fmt.Sprintf("%s %s <- %s", fw.Protocol, fw.Local, fw.Mesh)
+	}
+	return fmt.Sprintf("%s %s -> %s", fw.Protocol, fw.Local, fw.Mesh)
+}
+
+// ForwardInfo describes a running forward.
+type ForwardInfo struct {
+	Forward
+	Address string // the address that the forward is listening on
+	Active  uint64 // open connections, or UDP flows
+}
+
+// Forwarder runs the forwards.
+type Forwarder struct {
+	core     *core.Core
+	network  Network
+	log      core.Logger
+	mutex    sync.Mutex
+	forwards map[Forward]*forwarding
+	config   struct {
+		forwards []Forward
+	}
+}
+
+// New starts the forwards given as options. If any of them can't be started
+// then none are. The network can be nil if there is no way to reach the mesh,
+// in which case adding a forward fails.
+func New(c *core.Core, network Network, log core.Logger, opts ...SetupOption) (*Forwarder, error) {
+	f := &Forwarder{
+		core:     c,
+		network:  network,
+		log:      log,
+		forwards: map[Forward]*forwarding{},
+	}
+	for _, opt := range opts {
+		f._applyOption(opt)
+	}
+	for _, fw := range f.config.forwards {
+		if err := f.AddForward(fw); err != nil {
+			_ = f.Stop()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// AddForward starts forwarding a port. An empty protocol means TCP.
+func (f *Forwarder) AddForward(fw Forward) error {
+	fw, err := f.normalise(fw)
+	if err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, ok := f.forwards[fw]; ok {
+		return fmt.Errorf("%s is already forwarded", fw)
+	}
+	r, err := f.start(fw)
+	if err != nil {
+		return fmt.Errorf("failed to forward %s: %w", fw, err)
+	}
+	f.forwards[fw] = r
+	f.log.Infoln("Forwarding", fw, "on", r.addr)
+	return nil
+}
+
+// RemoveForward stops forwarding a port, closing any connections through it.
+func (f *Forwarder) RemoveForward(fw Forward) error {
+	fw, err := f.normalise(fw)
+	if err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	r, ok := f.forwards[fw]
+	delete(f.forwards, fw)
+	f.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("%s is not forwarded", fw)
+	}
+	r.stop()
+	f.log.Infoln("Stopped forwarding", fw)
+	return nil
+}
+
+// SetForwards starts and stops forwards so that only the given ones are
+// running, leaving those that are already running alone. Nothing changes if
+// any of them are invalid.
+func (f *Forwarder) SetForwards(forwards []Forward) (changed bool, err error) {
+	wanted := map[Forward]struct{}{}
+	for _, fw := range forwards {
+		if fw, err = f.normalise(fw); err != nil {
+			return false, err
+		}
+		wanted[fw] = struct{}{}
+	}
+	f.mutex.Lock()
+	var remove []Forward
+	for fw := range f.forwards {
+		if _, ok := wanted[fw]; ok {
+			delete(wanted, fw)
+		} else {
+			remove = append(remove, fw)
+		}
+	}
+	f.mutex.Unlock()
+	var errs []error
+	for _, fw := range remove {
+		errs = append(errs, f.RemoveForward(fw))
+	}
+	for fw := range wanted {
+		errs = append(errs, f.AddForward(fw))
+	}
+	return len(remove) > 0 || len(wanted) > 0, errors.Join(errs...)
+}
+
+// GetForwards returns the forwards that are running.
+func (f *Forwarder) GetForwards() []ForwardInfo {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	forwards := make([]ForwardInfo, 0, len(f.forwards))
+	for fw, r := range f.forwards {
+		forwards = append(forwards, ForwardInfo{
+			Forward: fw,
+			Address: r.addr.String(),
+			Active:  r.active.Load(),
+		})
+	}
+	sort.Slice(forwards, func(i, j int) bool {
+		return forwards[i].String() < forwards[j].String()
+	})
+	return forwards
+}
+
+// Stop stops all of the forwards.
+func (f *Forwarder) Stop() error {
+	f.mutex.Lock()
+	forwards := f.forwards
+	f.forwards = map[Forward]*forwarding{}
+	f.mutex.Unlock()
+	for _, r := range forwards {
+		r.stop()
+	}
+	return nil
+}
+
+// normalise checks a forward, filling in the default protocol, and writing
+// the addresses the same way each time so that they can be compared.
+func (f *Forwarder) normalise(fw Forward) (Forward, error) {
+	switch fw.Protocol {
+	case "":
+		fw.Protocol = "tcp"
+	case "tcp", "udp":
+	default:
+		return fw, fmt.Errorf("unsupported protocol %q", fw.Protocol)
+	}
+	if _, _, err := net.SplitHostPort(fw.Local); err != nil {
+		return fw, fmt.Errorf("invalid local address: %w", err)
+	}
+	ip, port, err := f.meshAddress(fw.Mesh)
+	if err != nil {
+		return fw, err
+	}
+	if fw.Reverse {
+		ours := f.core.Address()
+		if ip != nil && !ip.IsUnspecified() && !ip.Equal(ours) {
+			return fw, fmt.Errorf("%s is not this node's address", ip)
+		}
+		ip = ours
+	} else if ip == nil {
+		return fw, errors.New("missing mesh address")
+	}
+	fw.Mesh = net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+	return fw, nil
+}
+
+func (f *Forwarder) meshAddress(hostport string) (net.IP, uint16, error) {
+	host, portstr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid mesh address: %w", err)
+	}
+	port, err := strconv.ParseUint(portstr, 10, 16)
+	if err != nil || port == 0 {
+		return nil, 0, fmt.Errorf("invalid mesh port %q", portstr)
+	}
+	if host == "" {
+		return nil, uint16(port), nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.To4() != nil {
+		return nil, 0, fmt.Errorf("%s is not an IPv6 address", host)
+	}
+	if !ip.IsUnspecified() {
+		var addr core.Address
+		var subnet core.Subnet
+		copy(addr[:], ip)
+		copy(subnet[:], ip)
+		if !f.core.IsValidAddress(addr) && !f.core.IsValidSubnet(subnet) {
+			return nil, 0, fmt.Errorf("%s is not a mesh address", host)
+		}
+	}
+	return ip, uint16(port), nil
+}
+
+// start opens the listening side of a forward that has been normalised.
+func (f *Forwarder) start(fw Forward) (*forwarding, error) {
+	if f.network == nil {
+		return nil, errors.New("there is no TUN interface or userspace stack to reach the mesh")
+	}
+	r := &forwarding{
+		log:   f.log,
+		conns: map[io.Closer]struct{}{},
+	}
+	ip, port, _ := f.meshAddress(fw.Mesh)
+	switch {
+	case fw.Protocol == "tcp" && !fw.Reverse:
+		listener, err := net.Listen("tcp", fw.Local)
+		if err != nil {
+			return nil, err
+		}
+		raddr := &net.TCPAddr{IP: ip, Port: int(port)}
+		r.serveTCP(listener, func(ctx context.Context) (net.Conn, error) {
+			return f.network.DialTCP(ctx, raddr)
+		})
+	case fw.Protocol == "tcp":
+		listener, err := f.network.ListenTCP(port)
+		if err != nil {
+			return nil, err
+		}
+		r.serveTCP(listener, func(ctx context.Context) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "tcp", fw.Local)
+		})
+	case !fw.Reverse:
+		conn, err := net.ListenPacket("udp", fw.Local)
+		if err != nil {
+			return nil, err
+		}
+		raddr := &net.UDPAddr{IP: ip, Port: int(port)}
+		r.serveUDP(conn, func() (net.Conn, error) {
+			return f.network.DialUDP(raddr)
+		})
+	default:
+		conn, err := f.network.ListenUDP(port)
+		if err != nil {
+			return nil, err
+		}
+		r.serveUDP(conn, func() (net.Conn, error) {
+			return net.Dial("udp", fw.Local)
+		})
+	}
+	return r, nil
+}
+
+// forwarding is a forward that is running.
+type forwarding struct {
+	log      core.Logger
+	addr     net.Addr
+	listener io.Closer
+	ctx      context.Context // cancelled by stop
+	cancel   context.CancelFunc
+	active   atomic.Uint64
+	mutex    sync.Mutex
+	conns    map[io.Closer]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+func (r *forwarding) stop() {
+	r.cancel()
+	r.mutex.Lock()
+	r.closed = true
+	_ = r.listener.Close()
+	for conn := range r.conns {
+		_ = conn.Close()
+	}
+	r.mutex.Unlock()
+	r.wg.Wait()
+}
+
+// track remembers a connection so that stop can close it. It returns false
+// if the forward has already been stopped.
+func (r *forwarding) track(conn io.Closer) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return false
+	}
+	r.conns[conn] = struct{}{}
+	return true
+}
+
+func (r *forwarding) untrack(conn io.Closer) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.conns, conn)
+}
+
+func (r *forwarding) serveTCP(listener net.Listener, dial func(ctx context.Context) (net.Conn, error)) {
+	r.addr, r.listener = listener.Addr(), listener
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if !r.track(conn) {
+				_ = conn.Close()
+				return
+			}
+			r.wg.Add(1)
+			go func() {
+				defer r.wg.Done()
+				defer r.untrack(conn)
+				defer conn.Close()
+				ctx, cancel := context.WithTimeout(r.ctx, dialTimeout)
+				remote, err := dial(ctx)
+				cancel()
+				if err != nil {
+					r.log.Debugln("Forward failed to connect:", err)
+					return
+				}
+				if !r.track(remote) {
+					_ = remote.Close()
+					return
+				}
+				defer r.untrack(remote)
+				r.active.Add(1)
+				defer r.active.Add(^uint64(0))
+				splice(conn, remote)
+			}()
+		}
+	}()
+}
+
+// splice copies between two connections until both sides are done, or
+// either fails, and then closes them both.
+func splice(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	cp := func(dst, src net.Conn) {
+		_, _ = io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite()
+		} else {
+			_ = dst.Close()
+		}
+		done <- struct{}{}
+	}
+	go cp(a, b)
+	go cp(b, a)
+	<-done
+	<-done
+	_ = a.Close()
+	_ = b.Close()
+}
+
+// A UDP flow is the datagrams from one source address, which are sent on
+// through their own connection so that the replies can be told apart.
+type udpFlow struct {
+	conn net.Conn
+	last atomic.Int64 // unix nanoseconds of the last datagram either way
+}
+
+func (r *forwarding) serveUDP(pc net.PacketConn, dial func() (net.Conn, error)) {
+	r.addr, r.listener = pc.LocalAddr(), pc
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	var mutex sync.Mutex
+	flows := map[string]*udpFlow{}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			mutex.Lock()
+			flow := flows[from.String()]
+			mutex.Unlock()
+			if flow == nil {
+				conn, err := dial()
+				if err != nil {
+					r.log.Debugln("Forward failed to connect:", err)
+					continue
+				}
+				if !r.track(conn) {
+					_ = conn.Close()
+					return
+				}
+				flow = &udpFlow{conn: conn}
+				flow.last.Store(time.Now().UnixNano())
+				mutex.Lock()
+				flows[from.String()] = flow
+				mutex.Unlock()
+				r.active.Add(1)
+				r.wg.Add(1)
+				go func(from net.Addr) {
+					defer r.wg.Done()
+					defer r.active.Add(^uint64(0))
+					defer r.untrack(flow.conn)
+					defer flow.conn.Close()
+					defer func() {
+						mutex.Lock()
+						delete(flows, from.String())
+						mutex.Unlock()
+					}()
+					r.replies(pc, from, flow)
+				}(from)
+			}
+			flow.last.Store(time.Now().UnixNano())
+			if _, err = flow.conn.Write(buf[:n]); err != nil {
+				r.log.Debugln("Forward failed to send:", err)
+			}
+		}
+	}()
+}
+
+// replies sends the datagrams coming back on a flow to where the flow came
+// from, until it has been idle for long enough.
+func (r *forwarding) replies(pc net.PacketConn, to net.Addr, flow *udpFlow) {
+	buf := make([]byte, 65535)
+	for {
+		idle := time.Since(time.Unix(0, flow.last.Load()))
+		if idle >= udpTimeout {
+			return
+		}
+		_ = flow.conn.SetReadDeadline(time.Now().Add(udpTimeout - idle))
+		n, err := flow.conn.Read(buf)
+		if err != nil {
+			// Timeouts are checked against the time of the last datagram in
+			// either direction. Anything else, usually an ICMP error from the
+			// other side, closes the flow, and the next datagram opens a new
+			// one.
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return
+		}
+		flow.last.Store(time.Now().UnixNano())
+		if _, err = pc.WriteTo(buf[:n], to); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package forward
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gologme/log"
+
+	"github.com/RiV-chain/RiV-mesh/src/core"
+)
+
+// testNetwork stands in for the mesh using loopback sockets. Each port on
+// the mesh is a loopback port, whatever the address is.
+type testNetwork struct {
+	mutex sync.Mutex
+	tcp   map[int]string
+	udp   map[int]string
+}
+
+func (n *testNetwork) DialTCP(ctx context.Context, raddr *net.TCPAddr) (net.Conn, error) {
+	n.mutex.Lock()
+	addr := n.tcp[raddr.Port]
+	n.mutex.Unlock()
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", addr)
+}
+
+func (n *testNetwork) ListenTCP(port uint16) (net.Listener, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err == nil {
+		n.mutex.Lock()
+		n.tcp[int(port)] = listener.Addr().String()
+		n.mutex.Unlock()
+	}
+	return listener, err
+}
+
+func (n *testNetwork) DialUDP(raddr *net.UDPAddr) (net.Conn, error) {
+	n.mutex.Lock()
+	addr := n.udp[raddr.Port]
+	n.mutex.Unlock()
+	return net.Dial("udp", addr)
+}
+
+func (n *testNetwork) ListenUDP(port uint16) (net.PacketConn, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err == nil {
+		n.mutex.Lock()
+		n.udp[int(port)] = conn.LocalAddr().String()
+		n.mutex.Unlock()
+	}
+	return conn, err
+}
+
+func echoTCP(t *testing.T, listener net.Listener) {
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+}
+
+func echoUDP(t *testing.T, conn net.PacketConn) {
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(buf[:n], from)
+		}
+	}()
+}
+
+func checkEcho(t *testing.T, network, addr string) {
+	t.Helper()
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	msg := []byte("hello " + network)
+	if _, err = conn.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, msg) {
+		t.Fatalf("got %q, expected %q", buf, msg)
+	}
+}
+
+func TestForwarder(t *testing.T) {
+	_, sk, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := log.New(os.Stderr, "", log.Flags())
+	logger.SetCallDepth(2)
+	c, err := core.New(sk, logger, core.NetworkDomain{Prefix: "fc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	network := &testNetwork{tcp: map[int]string{}, udp: map[int]string{}}
+
+	// Services in the mesh.
+	listener, err := network.ListenTCP(22)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echoTCP(t, listener)
+	conn, err := network.ListenUDP(53)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echoUDP(t, conn)
+	// Services on this machine.
+	if listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	echoTCP(t, listener)
+	if conn, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	echoUDP(t, conn)
+
+	pub, _, _ := ed25519.GenerateKey(nil)
+	remote := net.IP(c.AddrForKey(pub)[:]).String()
+	forwards := []Forward{
+		{Local: "127.0.0.1:0", Mesh: net.JoinHostPort(remote, "22")},
+		{Protocol: "udp", Local: "127.0.0.1:0", Mesh: net.JoinHostPort(remote, "53")},
+		{Protocol: "tcp", Local: listener.Addr().String(), Mesh: ":80", Reverse: true},
+		{Protocol: "udp", Local: conn.LocalAddr().String(), Mesh: "[::]:5353", Reverse: true},
+	}
+	f, err := New(c, network, logger, forwards[0], forwards[1], forwards[2], forwards[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Stop()
+
+	infos := f.GetForwards()
+	if len(infos) != len(forwards) {
+		t.Fatalf("expected %d forwards, got %d", len(forwards), len(infos))
+	}
+	ours := c.Address().String()
+	for _, info := range infos {
+		network := info.Protocol
+		addr := info.Address
+		if info.Reverse {
+			// Connect to the node's mesh address, as someone else would.
+			host, port, _ := net.SplitHostPort(info.Mesh)
+			if host != ours {
+				t.Fatalf("reverse forward on %s, expected our address %s", host, ours)
+			}
+			p, _ := strconv.Atoi(port)
+			addr = meshPort(f, network, p)
+		}
+		checkEcho(t, network, addr)
+	}
+
+	if err = f.AddForward(forwards[2]); err == nil {
+		t.Fatal("expected an error adding a forward twice")
+	}
+	if err = f.AddForward(Forward{Local: "127.0.0.1:0", Mesh: "[2001:db8::1]:22"}); err == nil {
+		t.Fatal("expected an error forwarding to an address outside of the mesh")
+	}
+	if err = f.AddForward(Forward{Local: "127.0.0.1:0", Mesh: net.JoinHostPort(remote, "22"), Reverse: true}); err == nil {
+		t.Fatal("expected an error for a reverse forward on another node's address")
+	}
+	// Forwards are found again however their addresses were written.
+	if err = f.RemoveForward(Forward{Protocol: "tcp", Local: listener.Addr().String(), Mesh: "[" + ours + "]:80", Reverse: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.GetForwards()) != len(forwards)-1 {
+		t.Fatal("forward was not removed")
+	}
+}
+
+// meshPort returns the loopback address that the test network gave to a
+// port on the node's mesh address.
+func meshPort(f *Forwarder, network string, port int) string {
+	n := f.network.(*testNetwork)
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if network == "tcp" {
+		return n.tcp[port]
+	}
+	return n.udp[port]
+}
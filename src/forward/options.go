@@ -0,0 +1,14 @@
+package forward
+
+func (f *Forwarder) _applyOption(opt SetupOption) {
+	switch v := opt.(type) {
+	case Forward:
+		f.config.forwards = append(f.config.forwards, v)
+	}
+}
+
+type SetupOption interface {
+	isSetupOption()
+}
+
+func (a Forward) isSetupOption() {}
@@ -321,6 +321,30 @@ func (k *keyStore) MTU() uint64 {
 	return mtu
 }
 
+// KeyForAddress returns the public key of the node that an address, or an
+// address in a subnet, belongs to. Only an address's partial key can be
+// worked out from it, so this is nil unless there has been traffic to or
+// from the node recently.
+func (k *keyStore) KeyForAddress(ip net.IP) ed25519.PublicKey {
+	if len(ip) != net.IPv6len {
+		return nil
+	}
+	var addr core.Address
+	var subnet core.Subnet
+	copy(addr[:], ip)
+	copy(subnet[:], ip)
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	info := k.addrToInfo[addr]
+	if info == nil {
+		info = k.subnetToInfo[subnet]
+	}
+	if info == nil {
+		return nil
+	}
+	return append(ed25519.PublicKey(nil), info.key[:]...)
+}
+
 type ReadWriteCloser struct {
 	keyStore
 }
@@ -112,7 +112,7 @@ func (m *Multicast) _start() error {
 	}
 
 	m._isOpen = true
-	go m.listen()
+	go m.listen(m.sock)
 	m.Act(nil, m._multicastStarted)
 	m.Act(nil, m._announce)
 
@@ -141,12 +141,42 @@ func (m *Multicast) Stop() error {
 func (m *Multicast) _stop() error {
 	m.log.Infoln("Stopping multicast module")
 	m._isOpen = false
+	if m._timer != nil {
+		m._timer.Stop()
+	}
 	if m.sock != nil {
 		m.sock.Close()
 	}
 	return nil
 }
 
+// SetInterfaces replaces the multicast interface configuration, starting or
+// stopping the module if that changes whether any interface is enabled.
+// Multicast listeners are restarted, in case their port has changed, but
+// links that were already made through them are kept up.
+func (m *Multicast) SetInterfaces(interfaces []MulticastInterface) error {
+	var err error
+	phony.Block(m, func() {
+		m.config._interfaces = map[MulticastInterface]struct{}{}
+		var anyEnabled bool
+		for _, intf := range interfaces {
+			m.config._interfaces[intf] = struct{}{}
+			anyEnabled = anyEnabled || intf.Beacon || intf.Listen
+		}
+		for index, info := range m._listeners {
+			info.listener.Close()
+			delete(m._listeners, index)
+		}
+		switch {
+		case anyEnabled && !m._isOpen:
+			err = m._start()
+		case !anyEnabled && m._isOpen:
+			err = m._stop()
+		}
+	})
+	return err
+}
+
 func (m *Multicast) _updateInterfaces() {
 	interfaces := m._getAllowedInterfaces()
 	for name, info := range interfaces {
@@ -351,16 +381,20 @@ func (m *Multicast) _announce() {
 	})
 }
 
-func (m *Multicast) listen() {
+func (m *Multicast) listen(sock *ipv6.PacketConn) {
 	groupAddr, err := net.ResolveUDPAddr("udp6", string(m.config._groupAddr))
 	if err != nil {
 		panic(err)
 	}
 	bs := make([]byte, 2048)
 	for {
-		nBytes, rcm, fromAddr, err := m.sock.ReadFrom(bs)
+		nBytes, rcm, fromAddr, err := sock.ReadFrom(bs)
 		if err != nil {
-			if !m.IsStarted() {
+			var current bool
+			phony.Block(m, func() {
+				current = m._isOpen && m.sock == sock
+			})
+			if !current {
 				return
 			}
 			panic(err)
@@ -0,0 +1,205 @@
+package netstack
+
+// This runs a userspace TCP/IP stack on top of the node, for applications
+// that can't or don't want to use a TUN adapter
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/icmp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+
+	"github.com/RiV-chain/RiV-mesh/src/core"
+	"github.com/RiV-chain/RiV-mesh/src/ipv6rwc"
+)
+
+// The stack only has the one interface, which is the node.
+const nicID tcpip.NICID = 1
+
+// How many outgoing packets can be waiting to be sent to the node.
+const queueSize = 512
+
+// Netstack lets programs in the same process make and accept TCP connections
+// and send UDP datagrams on the node's mesh address, without a TUN adapter,
+// e.g. in an unprivileged container or a mobile app. It reads all of the
+// IPv6 traffic sent to the node, so it should be used instead of the TUN
+// adapter rather than alongside it.
+type Netstack struct {
+	core   *core.Core
+	rwc    *ipv6rwc.ReadWriteCloser
+	log    core.Logger
+	stack  *stack.Stack
+	ep     *channel.Endpoint
+	addr   tcpip.Address
+	cancel context.CancelFunc
+	config struct {
+		mtu MTU
+	}
+}
+
+// New starts a userspace TCP/IP stack with the node's mesh address.
+func New(c *core.Core, log core.Logger, opts ...SetupOption) (*Netstack, error) {
+	s := &Netstack{
+		core: c,
+		rwc:  ipv6rwc.NewReadWriteCloser(c),
+		log:  log,
+	}
+	for _, opt := range opts {
+		s._applyOption(opt)
+	}
+	mtu := uint64(s.config.mtu)
+	if mtu == 0 {
+		mtu = s.rwc.MaxMTU()
+	}
+	s.rwc.SetMTU(mtu)
+	s.stack = stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv6.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol, icmp.NewProtocol6},
+		HandleLocal:        true,
+	})
+	s.ep = channel.New(queueSize, uint32(s.rwc.MTU()), "")
+	if err := s.stack.CreateNIC(nicID, s.ep); err != nil {
+		s.stack.Close()
+		return nil, fmt.Errorf("failed to create interface: %s", err)
+	}
+	address := s.rwc.Address()
+	s.addr = tcpip.AddrFromSlice(address[:])
+	protocolAddr := tcpip.ProtocolAddress{
+		Protocol:          ipv6.ProtocolNumber,
+		AddressWithPrefix: s.addr.WithPrefix(),
+	}
+	if err := s.stack.AddProtocolAddress(nicID, protocolAddr, stack.AddressProperties{}); err != nil {
+		s.stack.Close()
+		return nil, fmt.Errorf("failed to add address: %s", err)
+	}
+	// Everything goes to the node, which drops anything that isn't for a
+	// valid mesh address.
+	s.stack.SetRouteTable([]tcpip.Route{{Destination: header.IPv6EmptySubnet, NIC: nicID}})
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	go s.read()
+	go s.write(ctx)
+	return s, nil
+}
+
+// Stop closes the stack and all of its connections. The node keeps running,
+// but anything sent to it is dropped until it is stopped too.
+func (s *Netstack) Stop() error {
+	s.cancel()
+	s.stack.Close()
+	s.ep.Close()
+	return nil
+}
+
+// Address returns the mesh address that the stack uses.
+func (s *Netstack) Address() net.IP {
+	return net.IP(s.addr.AsSlice())
+}
+
+// KeyForAddress returns the public key that a mesh address belongs to, if
+// there has been traffic to or from it recently, or nil.
+func (s *Netstack) KeyForAddress(ip net.IP) ed25519.PublicKey {
+	return s.rwc.KeyForAddress(ip)
+}
+
+// DialTCP opens a TCP connection to a mesh address.
+func (s *Netstack) DialTCP(ctx context.Context, raddr *net.TCPAddr) (net.Conn, error) {
+	addr, err := s.fullAddress(raddr.IP, raddr.Port)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := gonet.DialContextTCP(ctx, s.stack, addr, ipv6.ProtocolNumber)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// ListenTCP accepts TCP connections to the given port on our mesh address.
+func (s *Netstack) ListenTCP(port uint16) (net.Listener, error) {
+	addr := tcpip.FullAddress{NIC: nicID, Addr: s.addr, Port: port}
+	listener, err := gonet.ListenTCP(s.stack, addr, ipv6.ProtocolNumber)
+	if err != nil {
+		return nil, err
+	}
+	return listener, nil
+}
+
+// DialUDP returns a connection that sends UDP datagrams to a mesh address,
+// and receives the replies, from a port that is chosen by the stack.
+func (s *Netstack) DialUDP(raddr *net.UDPAddr) (net.Conn, error) {
+	addr, err := s.fullAddress(raddr.IP, raddr.Port)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := gonet.DialUDP(s.stack, nil, &addr, ipv6.ProtocolNumber)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// ListenUDP receives UDP datagrams sent to the given port on our mesh
+// address, and can reply to them.
+func (s *Netstack) ListenUDP(port uint16) (net.PacketConn, error) {
+	addr := tcpip.FullAddress{NIC: nicID, Addr: s.addr, Port: port}
+	conn, err := gonet.DialUDP(s.stack, &addr, nil, ipv6.ProtocolNumber)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (s *Netstack) fullAddress(ip net.IP, port int) (tcpip.FullAddress, error) {
+	if ip.To4() != nil || len(ip) != net.IPv6len {
+		return tcpip.FullAddress{}, errors.New("not an IPv6 address")
+	}
+	if port <= 0 || port > 65535 {
+		return tcpip.FullAddress{}, fmt.Errorf("invalid port %d", port)
+	}
+	return tcpip.FullAddress{NIC: nicID, Addr: tcpip.AddrFromSlice(ip), Port: uint16(port)}, nil
+}
+
+// read passes packets from the node to the stack.
+func (s *Netstack) read() {
+	buf := make([]byte, s.rwc.MaxMTU())
+	for {
+		n, err := s.rwc.Read(buf)
+		if err != nil {
+			return
+		}
+		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Payload: buffer.MakeWithData(buf[:n]),
+		})
+		s.ep.InjectInbound(ipv6.ProtocolNumber, pkt)
+		pkt.DecRef()
+	}
+}
+
+// write passes packets from the stack to the node.
+func (s *Netstack) write(ctx context.Context) {
+	for {
+		pkt := s.ep.ReadContext(ctx)
+		if pkt == nil {
+			return
+		}
+		view := pkt.ToView()
+		pkt.DecRef()
+		if _, err := s.rwc.Write(view.AsSlice()); err != nil {
+			s.log.Debugln("Unable to send packet:", err)
+		}
+		view.Release()
+	}
+}
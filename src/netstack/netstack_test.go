@@ -0,0 +1,140 @@
+package netstack
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gologme/log"
+
+	"github.com/RiV-chain/RiV-mesh/src/core"
+)
+
+func createAndConnectTwo(t *testing.T) (nodeA, nodeB *core.Core) {
+	logger := log.New(os.Stderr, "", log.Flags())
+	logger.SetCallDepth(2)
+	nodes := make([]*core.Core, 2)
+	for i := range nodes {
+		_, sk, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nodes[i], err = core.New(sk, logger, core.ListenAddress("tcp://127.0.0.1:0"), core.NetworkDomain{Prefix: "fc"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	u, err := url.Parse("tcp://" + nodes[0].GetListeners()[0].Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = nodes[1].CallPeer(u, ""); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		if len(nodes[0].GetPeers()) == 1 && len(nodes[1].GetPeers()) == 1 {
+			return nodes[0], nodes[1]
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("nodes did not connect")
+	return nil, nil
+}
+
+func TestNetstack(t *testing.T) {
+	nodeA, nodeB := createAndConnectTwo(t)
+	defer nodeA.Stop()
+	defer nodeB.Stop()
+	logger := log.New(io.Discard, "", 0)
+	stackA, err := New(nodeA, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stackA.Stop()
+	stackB, err := New(nodeB, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stackB.Stop()
+	if !stackA.Address().Equal(net.IP(nodeA.Address())) {
+		t.Fatalf("stack address %s is not the node's", stackA.Address())
+	}
+
+	t.Run("TCP", func(t *testing.T) {
+		listener, err := stackA.ListenTCP(80)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, _ = io.Copy(conn, conn)
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		conn, err := stackB.DialTCP(ctx, &net.TCPAddr{IP: stackA.Address(), Port: 80})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+		msg := bytes.Repeat([]byte("mesh"), 4096)
+		go func() {
+			_, _ = conn.Write(msg)
+		}()
+		buf := make([]byte, len(msg))
+		if _, err = io.ReadFull(conn, buf); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, msg) {
+			t.Fatal("echoed data does not match")
+		}
+	})
+
+	t.Run("UDP", func(t *testing.T) {
+		server, err := stackA.ListenUDP(53)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+		client, err := stackB.DialUDP(&net.UDPAddr{IP: stackA.Address(), Port: 53})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		msg := []byte("hello")
+		if _, err = client.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 64)
+		_ = server.SetReadDeadline(time.Now().Add(10 * time.Second))
+		n, from, err := server.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], msg) {
+			t.Fatalf("got %q, expected %q", buf[:n], msg)
+		}
+		if !from.(*net.UDPAddr).IP.Equal(stackB.Address()) {
+			t.Fatalf("unexpected source address %s", from)
+		}
+		if _, err = server.WriteTo(msg, from); err != nil {
+			t.Fatal(err)
+		}
+		_ = client.SetReadDeadline(time.Now().Add(10 * time.Second))
+		if n, err = client.Read(buf); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(buf[:n], msg) {
+			t.Fatalf("got reply %q, expected %q", buf[:n], msg)
+		}
+	})
+}
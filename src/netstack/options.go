@@ -0,0 +1,18 @@
+package netstack
+
+func (s *Netstack) _applyOption(opt SetupOption) {
+	switch v := opt.(type) {
+	case MTU:
+		s.config.mtu = v
+	}
+}
+
+type SetupOption interface {
+	isSetupOption()
+}
+
+// MTU sets the largest IPv6 packet that the stack sends, which is limited to
+// what the node supports. The default is the largest that the node supports.
+type MTU uint64
+
+func (a MTU) isSetupOption() {}
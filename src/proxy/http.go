@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"time"
+)
+
+// How long a client has to send the request headers.
+const httpHeaderTimeout = 30 * time.Second
+
+// serveHTTP runs an HTTP proxy, which tunnels CONNECT requests and forwards
+// requests for absolute http:// URLs.
+func (p *Proxy) serveHTTP(listener net.Listener) {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return p.dial(ctx, addr)
+		},
+		MaxIdleConns:    16,
+		IdleConnTimeout: 90 * time.Second,
+	}
+	defer transport.CloseIdleConnections()
+	forward := &httputil.ReverseProxy{
+		// The request already has an absolute URL, so it is sent on as it is.
+		// A nil X-Forwarded-For stops the client's address being added.
+		Director: func(r *http.Request) {
+			r.Header["X-Forwarded-For"] = nil
+		},
+		Transport: transport,
+		ErrorLog:  log.New(&logWriter{p}, "", 0),
+	}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodConnect:
+				p.handleConnect(w, r)
+			case r.URL.IsAbs() && r.URL.Scheme == "http":
+				if _, err := p.resolve(r.URL.Hostname()); err != nil {
+					http.Error(w, err.Error(), http.StatusForbidden)
+					return
+				}
+				forward.ServeHTTP(w, r)
+			default:
+				http.Error(w, "Only proxy requests are accepted", http.StatusBadRequest)
+			}
+		}),
+		ReadHeaderTimeout: httpHeaderTimeout,
+		ErrorLog:          log.New(&logWriter{p}, "", 0),
+	}
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return
+	}
+	p.servers = append(p.servers, server)
+	p.mutex.Unlock()
+	_ = server.Serve(listener)
+}
+
+func (p *Proxy) handleConnect(w http.ResponseWriter, r *http.Request) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Connection can't be tunnelled", http.StatusInternalServerError)
+		return
+	}
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err = p.resolve(host); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	remote, err := p.dial(r.Context(), r.Host)
+	if err != nil {
+		p.log.Debugln("HTTP proxy failed to connect:", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		_ = remote.Close()
+		return
+	}
+	// The server no longer knows about the connection, so Stop has to.
+	if !p.track(conn) {
+		_ = conn.Close()
+		_ = remote.Close()
+		return
+	}
+	defer p.untrack(conn)
+	if _, err = rw.WriteString("HTTP/1.1 200 Connection established\r\n\r\n"); err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		_ = conn.Close()
+		_ = remote.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+	client := conn
+	if rw.Reader.Buffered() > 0 {
+		client = &bufferedConn{conn, rw.Reader}
+	}
+	p.splice(client, remote)
+}
+
+// logWriter passes the errors logged by the HTTP server and reverse proxy
+// on to the node's logger at debug level, as they are mostly about clients.
+type logWriter struct {
+	p *Proxy
+}
+
+func (w *logWriter) Write(b []byte) (int, error) {
+	w.p.log.Debugln("HTTP proxy:", strings.TrimSuffix(string(b), "\n"))
+	return len(b), nil
+}
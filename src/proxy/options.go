@@ -0,0 +1,25 @@
+package proxy
+
+func (p *Proxy) _applyOption(opt SetupOption) {
+	switch v := opt.(type) {
+	case ListenAddress:
+		p.config.listen = append(p.config.listen, v)
+	case Domain:
+		p.config.domain = v
+	}
+}
+
+type SetupOption interface {
+	isSetupOption()
+}
+
+// ListenAddress is where the proxy accepts connections, as a URI of the form
+// socks5://127.0.0.1:1080 or http://127.0.0.1:8080.
+type ListenAddress string
+
+// Domain is the domain of names of the form <hex public key>.<domain>, which
+// are resolved to the node's address. The default is "mesh".
+type Domain string
+
+func (a ListenAddress) isSetupOption() {}
+func (a Domain) isSetupOption()        {}
@@ -0,0 +1,264 @@
+package proxy
+
+// This lets local applications reach the mesh through a SOCKS5 or HTTP proxy,
+// for nodes that don't have a TUN adapter
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RiV-chain/RiV-mesh/src/core"
+)
+
+// How long to wait for a connection to a mesh node to open.
+const dialTimeout = 30 * time.Second
+
+// Dialer opens TCP connections to mesh addresses. It is satisfied by the
+// userspace stack in the netstack package.
+type Dialer interface {
+	DialTCP(ctx context.Context, raddr *net.TCPAddr) (net.Conn, error)
+}
+
+// Proxy accepts SOCKS5 and HTTP proxy connections from local applications and
+// forwards them to mesh addresses through a Dialer. Destinations outside the
+// mesh are refused, so it can't be used to reach the internet.
+type Proxy struct {
+	core      *core.Core
+	dialer    Dialer
+	log       core.Logger
+	ctx       context.Context // cancelled by Stop
+	cancel    context.CancelFunc
+	mutex     sync.Mutex
+	listeners []net.Listener
+	servers   []*http.Server
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+	config    struct {
+		listen []ListenAddress
+		domain Domain
+	}
+}
+
+// New starts the proxy on each of the listen addresses. If any of them
+// can't be used then nothing is started.
+func New(c *core.Core, dialer Dialer, log core.Logger, opts ...SetupOption) (*Proxy, error) {
+	p := &Proxy{
+		core:   c,
+		dialer: dialer,
+		log:    log,
+		conns:  map[net.Conn]struct{}{},
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	p.config.domain = "mesh"
+	for _, opt := range opts {
+		p._applyOption(opt)
+	}
+	type server struct {
+		scheme   string
+		listener net.Listener
+		serve    func(net.Listener)
+	}
+	var servers []server
+	for _, addr := range p.config.listen {
+		u, err := url.Parse(string(addr))
+		if err != nil {
+			_ = p.Stop()
+			return nil, fmt.Errorf("invalid proxy address %q: %w", addr, err)
+		}
+		var serve func(net.Listener)
+		switch u.Scheme {
+		case "socks", "socks5":
+			serve = p.serveSOCKS
+		case "http":
+			serve = p.serveHTTP
+		default:
+			_ = p.Stop()
+			return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+		}
+		listener, err := net.Listen("tcp", u.Host)
+		if err != nil {
+			_ = p.Stop()
+			return nil, fmt.Errorf("failed to start proxy on %s: %w", u.Host, err)
+		}
+		p.listeners = append(p.listeners, listener)
+		servers = append(servers, server{u.Scheme, listener, serve})
+	}
+	for _, s := range servers {
+		p.log.Infof("Proxy started on %s://%s", s.scheme, s.listener.Addr())
+		p.wg.Add(1)
+		go func(s server) {
+			defer p.wg.Done()
+			s.serve(s.listener)
+		}(s)
+	}
+	return p, nil
+}
+
+// Addrs returns the addresses that the proxy is listening on.
+func (p *Proxy) Addrs() []net.Addr {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	addrs := make([]net.Addr, 0, len(p.listeners))
+	for _, listener := range p.listeners {
+		addrs = append(addrs, listener.Addr())
+	}
+	return addrs
+}
+
+// Stop closes the listeners and any connections that are still open.
+func (p *Proxy) Stop() error {
+	p.cancel()
+	p.mutex.Lock()
+	p.closed = true
+	for conn := range p.conns {
+		_ = conn.Close()
+	}
+	for _, server := range p.servers {
+		_ = server.Close()
+	}
+	for _, listener := range p.listeners {
+		_ = listener.Close()
+	}
+	p.mutex.Unlock()
+	p.wg.Wait()
+	return nil
+}
+
+// track remembers a connection so that Stop can close it. It returns false if
+// the proxy has already been stopped.
+func (p *Proxy) track(conn net.Conn) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return false
+	}
+	p.conns[conn] = struct{}{}
+	return true
+}
+
+func (p *Proxy) untrack(conn net.Conn) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.conns, conn)
+}
+
+// resolve turns a host, either a mesh IPv6 address or a <key>.<domain> name,
+// into an address in the mesh. The key can be split across labels, as the
+// DNS server does, since a whole key is too long for a single one.
+func (p *Proxy) resolve(host string) (net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if !p.isMeshIP(ip) {
+			return nil, fmt.Errorf("%s is not a mesh address", host)
+		}
+		return ip, nil
+	}
+	name := strings.TrimSuffix(strings.ToLower(host), ".")
+	label, ok := strings.CutSuffix(name, "."+strings.ToLower(string(p.config.domain)))
+	if !ok {
+		return nil, fmt.Errorf("%s is not a mesh name", host)
+	}
+	key, err := hex.DecodeString(strings.ReplaceAll(label, ".", ""))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%s does not contain a valid public key", host)
+	}
+	addr := p.core.AddrForKey(key)
+	return net.IP(addr[:]), nil
+}
+
+func (p *Proxy) isMeshIP(ip net.IP) bool {
+	if ip.To4() != nil {
+		return false
+	}
+	var addr core.Address
+	var subnet core.Subnet
+	copy(addr[:], ip)
+	copy(subnet[:], ip)
+	return p.core.IsValidAddress(addr) || p.core.IsValidSubnet(subnet)
+}
+
+// dial opens a connection to a host:port in the mesh.
+func (p *Proxy) dial(ctx context.Context, hostport string) (net.Conn, error) {
+	host, portstr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portstr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", portstr)
+	}
+	ip, err := p.resolve(host)
+	if err != nil {
+		return nil, err
+	}
+	return p.dialTCP(ctx, ip, uint16(port))
+}
+
+func (p *Proxy) dialTCP(ctx context.Context, ip net.IP, port uint16) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	return p.dialer.DialTCP(ctx, &net.TCPAddr{IP: ip, Port: int(port)})
+}
+
+// splice copies between the client and the mesh connection until both sides
+// are done, or either fails, and then closes them both.
+func (p *Proxy) splice(client, remote net.Conn) {
+	if !p.track(remote) {
+		_ = client.Close()
+		_ = remote.Close()
+		return
+	}
+	defer p.untrack(remote)
+	done := make(chan struct{}, 2)
+	cp := func(dst, src net.Conn) {
+		_, _ = io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			_ = cw.CloseWrite()
+		} else {
+			_ = dst.Close()
+		}
+		done <- struct{}{}
+	}
+	go cp(remote, client)
+	go cp(client, remote)
+	<-done
+	<-done
+	_ = client.Close()
+	_ = remote.Close()
+}
+
+// serve accepts connections until the listener is closed, handing each to
+// handle in its own goroutine.
+func (p *Proxy) serve(listener net.Listener, handle func(net.Conn)) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				p.log.Errorln("Proxy failed to accept connection:", err)
+			}
+			return
+		}
+		if !p.track(conn) {
+			_ = conn.Close()
+			return
+		}
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			defer p.untrack(conn)
+			defer conn.Close()
+			handle(conn)
+		}()
+	}
+}
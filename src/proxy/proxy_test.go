@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gologme/log"
+	xproxy "golang.org/x/net/proxy"
+
+	"github.com/RiV-chain/RiV-mesh/src/core"
+)
+
+// testDialer sends every connection to the same local address, remembering
+// where in the mesh it was asked to connect to.
+type testDialer struct {
+	target string
+	mutex  sync.Mutex
+	dialed []*net.TCPAddr
+}
+
+func (d *testDialer) DialTCP(ctx context.Context, raddr *net.TCPAddr) (net.Conn, error) {
+	d.mutex.Lock()
+	d.dialed = append(d.dialed, raddr)
+	d.mutex.Unlock()
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", d.target)
+}
+
+func (d *testDialer) last() *net.TCPAddr {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if len(d.dialed) == 0 {
+		return nil
+	}
+	return d.dialed[len(d.dialed)-1]
+}
+
+func newTestProxy(t *testing.T, target string) (*core.Core, *testDialer, *Proxy) {
+	_, sk, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := log.New(os.Stderr, "", log.Flags())
+	logger.SetCallDepth(2)
+	c, err := core.New(sk, logger, core.NetworkDomain{Prefix: "fc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Stop)
+	dialer := &testDialer{target: target}
+	p, err := New(c, dialer, logger, ListenAddress("socks5://127.0.0.1:0"), ListenAddress("http://127.0.0.1:0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = p.Stop() })
+	return c, dialer, p
+}
+
+func TestProxy_SOCKS(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	c, dialer, p := newTestProxy(t, echo.Addr().String())
+	socks, err := xproxy.SOCKS5("tcp", p.Addrs()[0].String(), nil, xproxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub, _, _ := ed25519.GenerateKey(nil)
+	addr := net.IP(c.AddrForKey(pub)[:])
+	for _, host := range []string{
+		hex.EncodeToString(pub) + ".mesh",
+		strings.ToUpper(hex.EncodeToString(pub)) + ".MESH.",
+		hex.EncodeToString(pub[:16]) + "." + hex.EncodeToString(pub[16:]) + ".mesh",
+		addr.String(),
+	} {
+		conn, err := socks.Dial("tcp", net.JoinHostPort(host, "22"))
+		if err != nil {
+			t.Fatalf("%s: %s", host, err)
+		}
+		if got := dialer.last(); !got.IP.Equal(addr) || got.Port != 22 {
+			t.Fatalf("%s: dialled %s, expected [%s]:22", host, got, addr)
+		}
+		if _, err = conn.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 5)
+		if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+			t.Fatalf("%s: echo returned %q, %v", host, buf, err)
+		}
+		conn.Close()
+	}
+
+	// Anything outside of the mesh is refused without being dialled.
+	before := dialer.last()
+	for _, host := range []string{"192.0.2.1", "2001:db8::1", "example.com", "abcd.mesh"} {
+		if conn, err := socks.Dial("tcp", net.JoinHostPort(host, "80")); err == nil {
+			conn.Close()
+			t.Fatalf("%s: expected to be refused", host)
+		}
+	}
+	if dialer.last() != before {
+		t.Fatal("dialled a destination outside of the mesh")
+	}
+}
+
+func TestProxy_HTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Host, r.URL.Path)
+	}))
+	defer server.Close()
+	c, dialer, p := newTestProxy(t, server.Listener.Addr().String())
+	proxyURL := &url.URL{Scheme: "http", Host: p.Addrs()[1].String()}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	pub, _, _ := ed25519.GenerateKey(nil)
+	addr := net.IP(c.AddrForKey(pub)[:])
+	host := hex.EncodeToString(pub) + ".mesh"
+	resp, err := client.Get("http://" + host + "/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != host+" /index.html" {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode, body)
+	}
+	if got := dialer.last(); !got.IP.Equal(addr) || got.Port != 80 {
+		t.Fatalf("dialled %s, expected [%s]:80", got, addr)
+	}
+
+	resp, err = client.Get("http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected a destination outside of the mesh to be refused, got %d", resp.StatusCode)
+	}
+
+	// CONNECT tunnels the connection as it is.
+	conn, err := net.Dial("tcp", proxyURL.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	target := net.JoinHostPort(addr.String(), "443")
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	r := bufio.NewReader(conn)
+	if resp, err = http.ReadResponse(r, nil); err != nil {
+		t.Fatal(err)
+	} else if resp.StatusCode != http.StatusOK {
+		t.Fatalf("CONNECT failed with %d", resp.StatusCode)
+	}
+	fmt.Fprintf(conn, "GET /tunnel HTTP/1.1\r\nHost: mesh\r\n\r\n")
+	if resp, err = http.ReadResponse(r, nil); err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	if string(body) != "mesh /tunnel" {
+		t.Fatalf("unexpected response through the tunnel %q", body)
+	}
+	if got := dialer.last(); !got.IP.Equal(addr) || got.Port != 443 {
+		t.Fatalf("dialled %s, expected %s", got, target)
+	}
+}
@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+)
+
+// See RFC 1928. Only CONNECT without authentication is supported, which is
+// all that's needed for a proxy that only listens locally.
+const (
+	socksVersion         = 5
+	socksNoAuth          = 0x00
+	socksNoAcceptable    = 0xff
+	socksConnect         = 0x01
+	socksAddrIPv4        = 0x01
+	socksAddrDomain      = 0x03
+	socksAddrIPv6        = 0x04
+	socksSucceeded       = 0x00
+	socksNotAllowed      = 0x02
+	socksHostUnreachable = 0x04
+	socksCmdUnsupported  = 0x07
+	socksAddrUnsupported = 0x08
+)
+
+// How long a client has to finish the SOCKS handshake.
+const socksHandshakeTimeout = 30 * time.Second
+
+func (p *Proxy) serveSOCKS(listener net.Listener) {
+	p.serve(listener, p.handleSOCKS)
+}
+
+func (p *Proxy) handleSOCKS(conn net.Conn) {
+	_ = conn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+	r := bufio.NewReader(conn)
+	// Version and authentication methods.
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil || hdr[0] != socksVersion {
+		return
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return
+	}
+	method := byte(socksNoAcceptable)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil || method != socksNoAuth {
+		return
+	}
+	// The request.
+	var req [4]byte
+	if _, err := io.ReadFull(r, req[:]); err != nil || req[0] != socksVersion {
+		return
+	}
+	var host string
+	switch req[3] {
+	case socksAddrIPv4:
+		var ip [net.IPv4len]byte
+		if _, err := io.ReadFull(r, ip[:]); err != nil {
+			return
+		}
+		host = net.IP(ip[:]).String()
+	case socksAddrIPv6:
+		var ip [net.IPv6len]byte
+		if _, err := io.ReadFull(r, ip[:]); err != nil {
+			return
+		}
+		host = net.IP(ip[:]).String()
+	case socksAddrDomain:
+		n, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		name := make([]byte, n)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		_ = p.socksReply(conn, socksAddrUnsupported, nil)
+		return
+	}
+	var port uint16
+	if err := binary.Read(r, binary.BigEndian, &port); err != nil {
+		return
+	}
+	if req[1] != socksConnect {
+		_ = p.socksReply(conn, socksCmdUnsupported, nil)
+		return
+	}
+	ip, err := p.resolve(host)
+	if err != nil {
+		p.log.Debugln("SOCKS proxy refused connection:", err)
+		_ = p.socksReply(conn, socksNotAllowed, nil)
+		return
+	}
+	remote, err := p.dialTCP(p.ctx, ip, port)
+	if err != nil {
+		p.log.Debugln("SOCKS proxy failed to connect:", err)
+		_ = p.socksReply(conn, socksHostUnreachable, nil)
+		return
+	}
+	if err := p.socksReply(conn, socksSucceeded, remote.LocalAddr()); err != nil {
+		_ = remote.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+	client := net.Conn(conn)
+	if r.Buffered() > 0 {
+		// The client sent some data without waiting for the reply.
+		client = &bufferedConn{conn, r}
+	}
+	p.splice(client, remote)
+}
+
+// socksReply tells the client the result of its request, along with the
+// address that the connection to the mesh is bound to, if there is one.
+func (p *Proxy) socksReply(conn net.Conn, code byte, bound net.Addr) error {
+	reply := []byte{socksVersion, code, 0, socksAddrIPv6}
+	ip, port := net.IPv6unspecified, 0
+	if addr, ok := bound.(*net.TCPAddr); ok && addr.IP.To4() == nil {
+		ip, port = addr.IP, addr.Port
+	}
+	reply = append(reply, ip.To16()...)
+	reply = binary.BigEndian.AppendUint16(reply, uint16(port))
+	_, err := conn.Write(reply)
+	return err
+}
+
+// bufferedConn is a net.Conn that returns what's left in a bufio.Reader
+// before reading anything more from the connection.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *bufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
@@ -3,6 +3,7 @@ package restapi
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
 	"embed"
 	"encoding/hex"
 	"encoding/json"
@@ -18,6 +19,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"archive/zip"
 	"time"
@@ -27,7 +29,9 @@ import (
 
 	"github.com/RiV-chain/RiV-mesh/src/config"
 	"github.com/RiV-chain/RiV-mesh/src/core"
+	"github.com/RiV-chain/RiV-mesh/src/crawler"
 	"github.com/RiV-chain/RiV-mesh/src/defaults"
+	"github.com/RiV-chain/RiV-mesh/src/forward"
 	"github.com/RiV-chain/RiV-mesh/src/multicast"
 	"github.com/RiV-chain/RiV-mesh/src/version"
 	"github.com/ip2location/ip2location-go/v9"
@@ -70,6 +74,10 @@ type ApiHandler struct {
 	Handler func(w http.ResponseWriter, r *http.Request) // First is input map, second is output
 }
 
+// ReloadFunc re-reads the configuration and applies it to the running node,
+// returning the settings that were changed and those that need a restart.
+type ReloadFunc func() (applied, restart []string, err error)
+
 type RestServerCfg struct {
 	Core          *core.Core
 	Multicast     *multicast.Multicast
@@ -77,6 +85,8 @@ type RestServerCfg struct {
 	ListenAddress string
 	WwwRoot       string
 	ConfigFn      string
+	Reload        ReloadFunc         // nil if the configuration can't be reloaded
+	Forwarder     *forward.Forwarder // nil if ports can't be forwarded
 	handlers      []ApiHandler
 	Domain        string
 	Features      []string
@@ -91,6 +101,9 @@ type RestServer struct {
 	updateTimer       *time.Timer
 	docFsType         string
 	ip2locatinoDb     *ip2location.DB
+	unsubscribe       func()
+	crawlMutex        sync.Mutex
+	crawlJob          *crawler.Job // the latest crawl, nil until one is started
 }
 
 func NewRestServer(cfg RestServerCfg) (*RestServer, error) {
@@ -151,13 +164,52 @@ Request body [{ "uri":"tcp://xxx.xxx.xxx.xxx:yyyy", "interface":"eth0" }, ...],
 Request header "Riv-Save-Config: true" persists changes`, Handler: a.putApiPeersHandler})
 	a.AddHandler(ApiHandler{Method: "DELETE", Pattern: "/api/peers", Desc: `Remove all peers from this node
 Request header "Riv-Save-Config: true" persists changes`, Handler: a.deleteApiPeersHandler})
+	a.AddHandler(ApiHandler{Method: "GET", Pattern: "/api/configuredpeers", Desc: "Show the state of configured peers, including those that are not connected", Handler: a.getApiConfiguredPeersHandler})
+	a.AddHandler(ApiHandler{Method: "GET", Pattern: "/api/listeners", Desc: "Show listeners and the addresses they are bound to", Handler: a.getApiListenersHandler})
+	a.AddHandler(ApiHandler{Method: "POST", Pattern: "/api/listeners", Desc: `Start new listeners.
+Request body [{ "uri":"tls://0.0.0.0:0" }, ...]
+Request header "Riv-Save-Config: true" persists changes`, Handler: a.postApiListenersHandler})
+	a.AddHandler(ApiHandler{Method: "DELETE", Pattern: "/api/listeners", Desc: `Stop listeners, links already accepted are kept up.
+Request body [{ "uri":"tls://0.0.0.0:0" }, ...]
+Request header "Riv-Save-Config: true" persists changes`, Handler: a.deleteApiListenersHandler})
+	a.AddHandler(ApiHandler{Method: "GET", Pattern: "/api/forwards", Desc: "Show ports forwarded between this machine and the mesh", Handler: a.getApiForwardsHandler})
+	a.AddHandler(ApiHandler{Method: "POST", Pattern: "/api/forwards", Desc: `Forward ports between this machine and the mesh.
+Request body [{ "protocol":"tcp", "local":"127.0.0.1:2222", "mesh":"[fc00::1]:22", "reverse":false }, ...]
+Request header "Riv-Save-Config: true" persists changes`, Handler: a.postApiForwardsHandler})
+	a.AddHandler(ApiHandler{Method: "DELETE", Pattern: "/api/forwards", Desc: `Stop forwarding ports, closing connections through them.
+Request body [{ "protocol":"tcp", "local":"127.0.0.1:2222", "mesh":"[fc00::1]:22", "reverse":false }, ...]
+Request header "Riv-Save-Config: true" persists changes`, Handler: a.deleteApiForwardsHandler})
+	a.AddHandler(ApiHandler{Method: "GET", Pattern: "/api/allowedkeys", Desc: "Show public keys that incoming peerings are allowed from, all keys are allowed if empty", Handler: a.getApiAllowedKeysHandler})
+	a.AddHandler(ApiHandler{Method: "POST", Pattern: "/api/allowedkeys", Desc: `Allow incoming peerings from public keys.
+Request body [{ "key":"<hex public key>" }, ...]
+Request header "Riv-Save-Config: true" persists changes`, Handler: a.postApiAllowedKeysHandler})
+	a.AddHandler(ApiHandler{Method: "DELETE", Pattern: "/api/allowedkeys", Desc: `Stop allowing incoming peerings from public keys.
+Request body [{ "key":"<hex public key>" }, ...]
+Add ?disconnect=true to also close inbound links from the removed keys.
+Request header "Riv-Save-Config: true" persists changes`, Handler: a.deleteApiAllowedKeysHandler})
+	a.AddHandler(ApiHandler{Method: "GET", Pattern: "/api/denylist", Desc: "Show denied public keys and networks and how many inbound connections each has refused", Handler: a.getApiDenylistHandler})
+	a.AddHandler(ApiHandler{Method: "POST", Pattern: "/api/denylist", Desc: `Deny inbound connections from public keys or networks, closing existing links from them.
+Request body [{ "key":"<hex public key>" }, { "network":"192.0.2.0/24" }, ...]
+Request header "Riv-Save-Config: true" persists changes`, Handler: a.postApiDenylistHandler})
+	a.AddHandler(ApiHandler{Method: "DELETE", Pattern: "/api/denylist", Desc: `Stop denying inbound connections from public keys or networks.
+Request body [{ "key":"<hex public key>" }, { "network":"192.0.2.0/24" }, ...]
+Request header "Riv-Save-Config: true" persists changes`, Handler: a.deleteApiDenylistHandler})
+	a.AddHandler(ApiHandler{Method: "POST", Pattern: "/api/reload", Desc: "Reload the configuration file, applying what can be changed without a restart and listing the settings that can't", Handler: a.postApiReloadHandler})
 	a.AddHandler(ApiHandler{Method: "GET", Pattern: "/api/publicpeers", Desc: "Show public peers loaded from URL which configured in mesh.conf file", Handler: a.getApiPublicPeersHandler})
 	a.AddHandler(ApiHandler{Method: "GET", Pattern: "/api/paths", Desc: "Show established paths through this node", Handler: a.getApiPathsHandler})
 	a.AddHandler(ApiHandler{Method: "POST", Pattern: "/api/health", Desc: "Run peers health check task", Handler: a.postApiHealthHandler})
-	a.AddHandler(ApiHandler{Method: "GET", Pattern: "/api/sse", Desc: "Return server side events", Handler: a.getApiSseHandler})
+	a.AddHandler(ApiHandler{Method: "GET", Pattern: "/api/sse", Desc: "Return server side events: peers, link, tree, rxtx, coord and health", Handler: a.getApiSseHandler})
 	a.AddHandler(ApiHandler{Method: "GET", Pattern: "/api/dht", Desc: "Show known DHT entries", Handler: a.getApiDhtHandler})
 	a.AddHandler(ApiHandler{Method: "GET", Pattern: "/api/sessions", Desc: "Show established traffic sessions with remote nodes", Handler: a.getApiSessionsHandler})
 	a.AddHandler(ApiHandler{Method: "GET", Pattern: "/api/multicastinterfaces", Desc: "Show which interfaces multicast is enabled on", Handler: a.getApiMulticastinterfacesHandler})
+	a.AddHandler(ApiHandler{Method: "POST", Pattern: "/api/crawl", Desc: `Start mapping the whole reachable mesh, one crawl at a time.
+Request body { "concurrency":16, "rate":30, "depth":0 }, all optional, depth 0 is no limit`, Handler: a.postApiCrawlHandler})
+	a.AddHandler(ApiHandler{Method: "GET", Pattern: "/api/crawl", Desc: `Show the progress of the latest crawl, or its map once it has finished.
+Add ?fmt=dot or ?fmt=gexf for the map as a graph instead of json`, Handler: a.getApiCrawlHandler})
+	a.AddHandler(ApiHandler{Method: "DELETE", Pattern: "/api/crawl", Desc: "Cancel the crawl that is running, keeping what it found until then", Handler: a.deleteApiCrawlHandler})
+	a.AddHandler(ApiHandler{Method: "GET", Pattern: "/api/crawl/events", Desc: "Return server side events for the progress of the latest crawl: progress and done", Handler: a.getApiCrawlEventsHandler})
+	a.AddHandler(ApiHandler{Method: "GET", Pattern: "/api/ping/{key}", Desc: `Ping a remote node through the mesh by its public key, or by its address if the key is known.
+Add ?count=4&size=56 for how many probes to send, a second apart, and how many bytes each is`, Handler: a.getApiPingHandler})
 	a.AddHandler(ApiHandler{Method: "GET", Pattern: "/api/remote/nodeinfo/{key}", Desc: "Request nodeinfo from a remote node by its public key", Handler: a.getApiRemoteNodeinfoHandler})
 	a.AddHandler(ApiHandler{Method: "GET", Pattern: "/api/remote/self/{key}", Desc: "Request self from a remote node by its public key", Handler: a.getApiRemoteSelfHandler})
 	a.AddHandler(ApiHandler{Method: "GET", Pattern: "/api/remote/peers/{key}", Desc: "Request peers from a remote node by its public key", Handler: a.getApiRemotePeersHandler})
@@ -176,6 +228,10 @@ Request header "Riv-Save-Config: true" persists changes`, Handler: a.deleteApiPe
 		}
 	})
 
+	var events <-chan core.Event
+	events, a.unsubscribe = a.Core.Subscribe(16)
+	go a.forwardCoreEvents(events)
+
 	a.ip2locatinoDb, err = ip2location.OpenDBWithReader(nopCloser{bytes.NewReader(IP2LOCATION)})
 	if err != nil {
 		a.Log.Errorf("load ip2location DB failed: %w", err)
@@ -216,6 +272,12 @@ func (a *RestServer) Serve() error {
 
 // Shutdown http server
 func (a *RestServer) Shutdown() error {
+	a.unsubscribe()
+	a.crawlMutex.Lock()
+	if a.crawlJob != nil {
+		a.crawlJob.Cancel()
+	}
+	a.crawlMutex.Unlock()
 	err := a.server.Shutdown(context.Background())
 	a.Log.Infof("Stop REST service")
 	return err
@@ -537,19 +599,29 @@ func (a *RestServer) getApiMulticastinterfacesHandler(w http.ResponseWriter, r *
 }
 
 type Peer struct {
-	Address       string   `json:"address"`
-	Key           string   `json:"key"`
-	Port          uint64   `json:"port"`
-	Priority      uint64   `json:"priority"`
-	Coords        []uint64 `json:"coords"`
-	Remote        string   `json:"remote"`
-	Remote_ip     string   `json:"remote_ip"`
-	Bytes_recvd   uint64   `json:"bytes_recvd"`
-	Bytes_sent    uint64   `json:"bytes_sent"`
-	Uptime        float64  `json:"uptime"`
-	Multicast     bool     `json:"multicast"`
-	Country_short string   `json:"country_short"`
-	Country_long  string   `json:"country_long"`
+	Address          string   `json:"address"`
+	Key              string   `json:"key"`
+	Port             uint64   `json:"port"`
+	Priority         uint64   `json:"priority"`
+	Coords           []uint64 `json:"coords"`
+	Remote           string   `json:"remote"`
+	Remote_ip        string   `json:"remote_ip"`
+	Bytes_recvd      uint64   `json:"bytes_recvd"`
+	Bytes_sent       uint64   `json:"bytes_sent"`
+	Wire_bytes_recvd uint64   `json:"wire_bytes_recvd"`
+	Wire_bytes_sent  uint64   `json:"wire_bytes_sent"`
+	Compression      string   `json:"compression,omitempty"`
+	Rate_recvd       uint64   `json:"rate_recvd"`
+	Rate_sent        uint64   `json:"rate_sent"`
+	Throttled        float64  `json:"throttled"`
+	Rtt              float64  `json:"rtt"`
+	Rtt_min          float64  `json:"rtt_min"`
+	Rtt_avg          float64  `json:"rtt_avg"`
+	Last_seen        string   `json:"last_seen"`
+	Uptime           float64  `json:"uptime"`
+	Multicast        bool     `json:"multicast"`
+	Country_short    string   `json:"country_short"`
+	Country_long     string   `json:"country_long"`
 }
 
 func (a *RestServer) prepareGetPeers() []Peer {
@@ -567,6 +639,16 @@ func (a *RestServer) prepareGetPeers() []Peer {
 			p.RemoteIp,
 			p.RXBytes,
 			p.TXBytes,
+			p.RXWireBytes,
+			p.TXWireBytes,
+			p.Compression,
+			p.RXRate,
+			p.TXRate,
+			p.Throttled.Seconds(),
+			float64(p.RTT.Microseconds()) / 1000,
+			float64(p.RTTMin.Microseconds()) / 1000,
+			float64(p.RTTAvg.Microseconds()) / 1000,
+			p.LastSeen.Format(time.RFC3339),
 			p.Uptime.Seconds(),
 			strings.Contains(p.Remote, "[fe80::"),
 			"",
@@ -594,7 +676,7 @@ func (a *RestServer) prepareGetPeers() []Peer {
 	return response
 }
 
-// @Summary		Get current peers list. The output contains following fields: address, public key, port, priority, coordinates, remote URL, remote IP, bytes received, bytes sent, uptime, multicast flag, country code, country.
+// @Summary		Get current peers list. The output contains following fields: address, public key, port, priority, coordinates, remote URL, remote IP, bytes received, bytes sent, wire bytes received, wire bytes sent, compression, bytes per second received, bytes per second sent, seconds throttled, round trip time in milliseconds (current, minimum, average), last seen, uptime, multicast flag, country code, country.
 // @Produce		json
 // @Success		200		{string}	string		"ok"
 // @Failure		401		{error}		error		"Authentication failed"
@@ -604,6 +686,38 @@ func (a *RestServer) getApiPeersHandler(w http.ResponseWriter, r *http.Request)
 	WriteJson(w, r, a.prepareGetPeers())
 }
 
+// @Summary		Show the state of configured peers. The output contains following fields: uri, interface, state, last error, last attempt, attempts, next retry.
+// @Produce		json
+// @Success		200		{string}	string		"ok"
+// @Failure		401		{error}		error		"Authentication failed"
+// @Router		/configuredpeers [get]
+func (a *RestServer) getApiConfiguredPeersHandler(w http.ResponseWriter, r *http.Request) {
+	peers := a.Core.GetConfiguredPeers()
+	result := make([]map[string]any, 0, len(peers))
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+	for _, p := range peers {
+		entry := map[string]any{
+			"uri":          p.URI,
+			"interface":    p.SourceInterface,
+			"state":        p.State,
+			"last_error":   p.LastError,
+			"last_attempt": formatTime(p.LastAttempt),
+			"attempts":     p.Attempts,
+			"next_retry":   formatTime(p.NextRetry),
+		}
+		result = append(result, entry)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return strings.Compare(result[i]["uri"].(string), result[j]["uri"].(string)) < 0
+	})
+	WriteJson(w, r, result)
+}
+
 // @Summary		Add new peers.
 // @Produce		json
 // @Success		200		{string}	string		"ok"
@@ -684,6 +798,387 @@ func (a *RestServer) savePeers(peers []map[string]string, r *http.Request) {
 	}, r)
 }
 
+// @Summary		Show listeners. The output contains following fields: uri, scheme, bound address, inbound links, handshakes in progress, connections rejected by the listener's limits.
+// @Produce		json
+// @Success		200		{string}	string		"ok"
+// @Failure		401		{error}		error		"Authentication failed"
+// @Router		/listeners [get]
+func (a *RestServer) getApiListenersHandler(w http.ResponseWriter, r *http.Request) {
+	listeners := a.Core.GetListeners()
+	result := make([]map[string]any, 0, len(listeners))
+	for _, l := range listeners {
+		entry := map[string]any{
+			"uri":        l.URI,
+			"scheme":     l.Scheme,
+			"address":    l.Address,
+			"links":      l.Links,
+			"handshakes": l.Handshakes,
+			"rejected":   l.Rejected,
+		}
+		result = append(result, entry)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return strings.Compare(result[i]["uri"].(string), result[j]["uri"].(string)) < 0
+	})
+	WriteJson(w, r, result)
+}
+
+// @Summary		Start new listeners.
+// @Produce		json
+// @Success		204		{string}	string		"No content"
+// @Failure		400		{error}		error		"Bad request"
+// @Failure		401		{error}		error		"Authentication failed"
+// @Router		/listeners [post]
+func (a *RestServer) postApiListenersHandler(w http.ResponseWriter, r *http.Request) {
+	if a.doListeners(w, r, a.Core.AddListener) == nil {
+		a.saveListeners(r)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary		Stop listeners.
+// @Produce		json
+// @Success		204		{string}	string		"No content"
+// @Failure		400		{error}		error		"Bad request"
+// @Failure		401		{error}		error		"Authentication failed"
+// @Router		/listeners [delete]
+func (a *RestServer) deleteApiListenersHandler(w http.ResponseWriter, r *http.Request) {
+	if a.doListeners(w, r, a.Core.RemoveListener) == nil {
+		a.saveListeners(r)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (a *RestServer) doListeners(w http.ResponseWriter, r *http.Request, fn func(uri string) error) error {
+	var listeners []map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&listeners); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+	for _, listener := range listeners {
+		if err := fn(listener["uri"]); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
+	}
+	return nil
+}
+
+// saveListeners persists the listeners that are configured now, rather than
+// those in the request, so that the config matches the running node.
+func (a *RestServer) saveListeners(r *http.Request) {
+	a.saveConfig(func(cfg *config.NodeConfig) {
+		cfg.Listen = []string{}
+		for _, l := range a.Core.GetListeners() {
+			cfg.Listen = append(cfg.Listen, l.URI)
+		}
+		sort.Strings(cfg.Listen)
+	}, r)
+}
+
+// @Summary		Show forwarded ports. The output contains following fields: protocol, local address, mesh address, reverse, listening address, open connections.
+// @Produce		json
+// @Success		200		{string}	string		"ok"
+// @Failure		401		{error}		error		"Authentication failed"
+// @Failure		501		{error}		error		"Not implemented"
+// @Router		/forwards [get]
+func (a *RestServer) getApiForwardsHandler(w http.ResponseWriter, r *http.Request) {
+	if a.Forwarder == nil {
+		http.Error(w, "Port forwarding isn't supported", http.StatusNotImplemented)
+		return
+	}
+	forwards := a.Forwarder.GetForwards()
+	result := make([]map[string]any, 0, len(forwards))
+	for _, fw := range forwards {
+		entry := map[string]any{
+			"protocol": fw.Protocol,
+			"local":    fw.Local,
+			"mesh":     fw.Mesh,
+			"reverse":  fw.Reverse,
+			"address":  fw.Address,
+			"active":   fw.Active,
+		}
+		result = append(result, entry)
+	}
+	WriteJson(w, r, result)
+}
+
+// @Summary		Forward ports between this machine and the mesh.
+// @Produce		json
+// @Success		204		{string}	string		"No content"
+// @Failure		400		{error}		error		"Bad request"
+// @Failure		401		{error}		error		"Authentication failed"
+// @Failure		501		{error}		error		"Not implemented"
+// @Router		/forwards [post]
+func (a *RestServer) postApiForwardsHandler(w http.ResponseWriter, r *http.Request) {
+	if a.doForwards(w, r, func(fw forward.Forward) error {
+		return a.Forwarder.AddForward(fw)
+	}) == nil {
+		a.saveForwards(r)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary		Stop forwarding ports.
+// @Produce		json
+// @Success		204		{string}	string		"No content"
+// @Failure		400		{error}		error		"Bad request"
+// @Failure		401		{error}		error		"Authentication failed"
+// @Failure		501		{error}		error		"Not implemented"
+// @Router		/forwards [delete]
+func (a *RestServer) deleteApiForwardsHandler(w http.ResponseWriter, r *http.Request) {
+	if a.doForwards(w, r, func(fw forward.Forward) error {
+		return a.Forwarder.RemoveForward(fw)
+	}) == nil {
+		a.saveForwards(r)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (a *RestServer) doForwards(w http.ResponseWriter, r *http.Request, fn func(fw forward.Forward) error) error {
+	if a.Forwarder == nil {
+		http.Error(w, "Port forwarding isn't supported", http.StatusNotImplemented)
+		return errors.New("port forwarding isn't supported")
+	}
+	var forwards []forward.Forward
+	if err := json.NewDecoder(r.Body).Decode(&forwards); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+	for _, fw := range forwards {
+		if err := fn(fw); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
+	}
+	return nil
+}
+
+// saveForwards persists the forwards that are running now, like
+// saveListeners.
+func (a *RestServer) saveForwards(r *http.Request) {
+	a.saveConfig(func(cfg *config.NodeConfig) {
+		cfg.Forwards = []config.ForwardConfig{}
+		for _, fw := range a.Forwarder.GetForwards() {
+			cfg.Forwards = append(cfg.Forwards, config.ForwardConfig{
+				Protocol: fw.Protocol,
+				Local:    fw.Local,
+				Mesh:     fw.Mesh,
+				Reverse:  fw.Reverse,
+			})
+		}
+	}, r)
+}
+
+// @Summary		Show public keys that incoming peerings are allowed from. The output contains following fields: key.
+// @Produce		json
+// @Success		200		{string}	string		"ok"
+// @Failure		401		{error}		error		"Authentication failed"
+// @Router		/allowedkeys [get]
+func (a *RestServer) getApiAllowedKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys := a.Core.GetAllowedPublicKeys()
+	result := make([]map[string]any, 0, len(keys))
+	for _, key := range keys {
+		entry := map[string]any{
+			"key": hex.EncodeToString(key),
+		}
+		result = append(result, entry)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return strings.Compare(result[i]["key"].(string), result[j]["key"].(string)) < 0
+	})
+	WriteJson(w, r, result)
+}
+
+// @Summary		Allow incoming peerings from public keys.
+// @Produce		json
+// @Success		204		{string}	string		"No content"
+// @Failure		400		{error}		error		"Bad request"
+// @Failure		401		{error}		error		"Authentication failed"
+// @Router		/allowedkeys [post]
+func (a *RestServer) postApiAllowedKeysHandler(w http.ResponseWriter, r *http.Request) {
+	if a.doAllowedKeys(w, r, a.Core.AddAllowedPublicKey) == nil {
+		a.saveAllowedKeys(r)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary		Stop allowing incoming peerings from public keys.
+// @Produce		json
+// @Param		disconnect	query		bool		false	"Close inbound links from the removed keys"
+// @Success		204		{string}	string		"No content"
+// @Failure		400		{error}		error		"Bad request"
+// @Failure		401		{error}		error		"Authentication failed"
+// @Router		/allowedkeys [delete]
+func (a *RestServer) deleteApiAllowedKeysHandler(w http.ResponseWriter, r *http.Request) {
+	disconnect := r.URL.Query().Get("disconnect") == "true"
+	if a.doAllowedKeys(w, r, func(key ed25519.PublicKey) error {
+		return a.Core.RemoveAllowedPublicKey(key, disconnect)
+	}) == nil {
+		a.saveAllowedKeys(r)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (a *RestServer) doAllowedKeys(w http.ResponseWriter, r *http.Request, fn func(key ed25519.PublicKey) error) error {
+	var keys []map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+	for _, entry := range keys {
+		key, err := hex.DecodeString(entry["key"])
+		if err == nil {
+			err = fn(key)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
+	}
+	return nil
+}
+
+// saveAllowedKeys persists the keys that are allowed now, rather than those in
+// the request, so that the config matches the running node.
+func (a *RestServer) saveAllowedKeys(r *http.Request) {
+	a.saveConfig(func(cfg *config.NodeConfig) {
+		cfg.AllowedPublicKeys = []string{}
+		for _, key := range a.Core.GetAllowedPublicKeys() {
+			cfg.AllowedPublicKeys = append(cfg.AllowedPublicKeys, hex.EncodeToString(key))
+		}
+		sort.Strings(cfg.AllowedPublicKeys)
+	}, r)
+}
+
+// @Summary		Show the denylist. The output contains following fields: type (key or network), value, hits.
+// @Produce		json
+// @Success		200		{string}	string		"ok"
+// @Failure		401		{error}		error		"Authentication failed"
+// @Router		/denylist [get]
+func (a *RestServer) getApiDenylistHandler(w http.ResponseWriter, r *http.Request) {
+	denylist := a.Core.GetDenylist()
+	result := make([]map[string]any, 0, len(denylist))
+	for _, d := range denylist {
+		entry := map[string]any{
+			"type":  "network",
+			"value": d.Network,
+			"hits":  d.Hits,
+		}
+		if d.Key != nil {
+			entry["type"] = "key"
+			entry["value"] = hex.EncodeToString(d.Key)
+		}
+		result = append(result, entry)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i]["type"] != result[j]["type"] {
+			return result[i]["type"] == "key"
+		}
+		return strings.Compare(result[i]["value"].(string), result[j]["value"].(string)) < 0
+	})
+	WriteJson(w, r, result)
+}
+
+// @Summary		Deny public keys or networks.
+// @Produce		json
+// @Success		204		{string}	string		"No content"
+// @Failure		400		{error}		error		"Bad request"
+// @Failure		401		{error}		error		"Authentication failed"
+// @Router		/denylist [post]
+func (a *RestServer) postApiDenylistHandler(w http.ResponseWriter, r *http.Request) {
+	if a.doDenylist(w, r, a.Core.AddDeniedPublicKey, a.Core.AddDeniedNetwork) == nil {
+		a.saveDenylist(r)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary		Remove public keys or networks from the denylist.
+// @Produce		json
+// @Success		204		{string}	string		"No content"
+// @Failure		400		{error}		error		"Bad request"
+// @Failure		401		{error}		error		"Authentication failed"
+// @Router		/denylist [delete]
+func (a *RestServer) deleteApiDenylistHandler(w http.ResponseWriter, r *http.Request) {
+	if a.doDenylist(w, r, a.Core.RemoveDeniedPublicKey, a.Core.RemoveDeniedNetwork) == nil {
+		a.saveDenylist(r)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (a *RestServer) doDenylist(w http.ResponseWriter, r *http.Request, keyFn func(key ed25519.PublicKey) error, networkFn func(network string) error) error {
+	var entries []map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&entries); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return err
+	}
+	for _, entry := range entries {
+		var err error
+		switch {
+		case entry["key"] != "":
+			var key []byte
+			if key, err = hex.DecodeString(entry["key"]); err == nil {
+				err = keyFn(key)
+			}
+		case entry["network"] != "":
+			err = networkFn(entry["network"])
+		default:
+			err = errors.New("each entry needs a key or a network")
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return err
+		}
+	}
+	return nil
+}
+
+// saveDenylist persists the denylist as it is now, rather than the entries in
+// the request, so that the config matches the running node.
+func (a *RestServer) saveDenylist(r *http.Request) {
+	a.saveConfig(func(cfg *config.NodeConfig) {
+		cfg.DeniedPublicKeys = []string{}
+		cfg.DeniedNetworks = []string{}
+		for _, d := range a.Core.GetDenylist() {
+			if d.Key != nil {
+				cfg.DeniedPublicKeys = append(cfg.DeniedPublicKeys, hex.EncodeToString(d.Key))
+			} else {
+				cfg.DeniedNetworks = append(cfg.DeniedNetworks, d.Network)
+			}
+		}
+		sort.Strings(cfg.DeniedPublicKeys)
+		sort.Strings(cfg.DeniedNetworks)
+	}, r)
+}
+
+// @Summary		Reload the configuration file. The output contains following fields: applied settings, settings that need a restart to change.
+// @Produce		json
+// @Success		200		{string}	string		"ok"
+// @Failure		401		{error}		error		"Authentication failed"
+// @Failure		500		{error}		error		"Internal error"
+// @Router		/reload [post]
+func (a *RestServer) postApiReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if a.Reload == nil {
+		http.Error(w, "Configuration reload isn't supported", http.StatusNotImplemented)
+		return
+	}
+	applied, restart, err := a.Reload()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if applied == nil {
+		applied = []string{}
+	}
+	if restart == nil {
+		restart = []string{}
+	}
+	WriteJson(w, r, map[string]any{
+		"applied":          applied,
+		"restart_required": restart,
+	})
+}
+
 func (a *RestServer) saveConfig(setConfigFields func(*config.NodeConfig), r *http.Request) {
 	if len(a.ConfigFn) > 0 {
 		saveHeaders := r.Header["Riv-Save-Config"]
@@ -768,6 +1263,253 @@ func (a *RestServer) getApiRemoteDHTHandler(w http.ResponseWriter, r *http.Reque
 	applyKeyParameterized(w, r, a.Core.RemoteGetDHT)
 }
 
+// @Summary		Start mapping the whole reachable mesh.
+// @Produce		json
+// @Success		202		{string}	string		"Accepted"
+// @Failure		400		{error}		error		"Bad request"
+// @Failure		401		{error}		error		"Authentication failed"
+// @Failure		409		{error}		error		"Conflict"
+// @Router		/crawl [post]
+func (a *RestServer) postApiCrawlHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Concurrency int `json:"concurrency"`
+		Rate        int `json:"rate"`
+		Depth       int `json:"depth"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Concurrency < 0 || req.Rate < 0 || req.Depth < 0 {
+		http.Error(w, "Crawl options can't be negative", http.StatusBadRequest)
+		return
+	}
+	a.crawlMutex.Lock()
+	defer a.crawlMutex.Unlock()
+	if a.crawlJob != nil && a.crawlJob.Result() == nil {
+		http.Error(w, "A crawl is already running", http.StatusConflict)
+		return
+	}
+	a.crawlJob = crawler.New(a.Core, a.Log,
+		crawler.Concurrency(req.Concurrency),
+		crawler.Rate(req.Rate),
+		crawler.MaxDepth(req.Depth),
+	).Start()
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	WriteJson(w, r, a.crawlJob.Progress())
+}
+
+// @Summary		Show the progress of the latest crawl, or its map once it has finished. The map contains following fields: started, finished, complete, nodes, edges.
+// @Produce		json
+// @Param		fmt	query			string				false	"json, dot or gexf"
+// @Success		200		{string}	string		"ok"
+// @Success		202		{string}	string		"Accepted"
+// @Failure		400		{error}		error		"Bad request"
+// @Failure		401		{error}		error		"Authentication failed"
+// @Failure		404		{error}		error		"Not found"
+// @Router		/crawl [get]
+func (a *RestServer) getApiCrawlHandler(w http.ResponseWriter, r *http.Request) {
+	job := a.getCrawlJob(w)
+	if job == nil {
+		return
+	}
+	m := job.Result()
+	if m == nil {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusAccepted)
+		WriteJson(w, r, job.Progress())
+		return
+	}
+	format := r.URL.Query().Get("fmt")
+	var contentType string
+	switch format {
+	case "", "table", crawler.FormatJSON:
+		WriteJson(w, r, m)
+		return
+	case crawler.FormatDOT:
+		contentType = "text/vnd.graphviz; charset=utf-8"
+	case crawler.FormatGEXF:
+		contentType = "application/xml; charset=utf-8"
+	default:
+		http.Error(w, "Unknown format "+format, http.StatusBadRequest)
+		return
+	}
+	w.Header().Add("Content-Type", contentType)
+	if err := m.Write(w, format); err != nil {
+		a.Log.Errorln("Failed to write crawl map:", err)
+	}
+}
+
+// @Summary		Cancel the crawl that is running.
+// @Produce		json
+// @Success		204		{string}	string		"No content"
+// @Failure		401		{error}		error		"Authentication failed"
+// @Failure		404		{error}		error		"Not found"
+// @Router		/crawl [delete]
+func (a *RestServer) deleteApiCrawlHandler(w http.ResponseWriter, r *http.Request) {
+	job := a.getCrawlJob(w)
+	if job == nil {
+		return
+	}
+	job.Cancel()
+	<-job.Done()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary		Return server side events for the progress of the latest crawl. A progress event is sent as each node is asked about itself, and a done event when the crawl finishes, which ends the stream.
+// @Produce		text/event-stream
+// @Success		200		{string}	string		"ok"
+// @Failure		401		{error}		error		"Authentication failed"
+// @Failure		404		{error}		error		"Not found"
+// @Router		/crawl/events [get]
+func (a *RestServer) getApiCrawlEventsHandler(w http.ResponseWriter, r *http.Request) {
+	job := a.getCrawlJob(w)
+	if job == nil {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+	updates, stop := job.Watch()
+	defer stop()
+	w.Header().Add("Content-Type", "text/event-stream")
+	w.Header().Add("Cache-Control", "no-cache")
+	id := 0
+	send := func(event string, p crawler.Progress) {
+		data, _ := json.Marshal(p)
+		fmt.Fprintln(w, "id:", id)
+		fmt.Fprintln(w, "event:", event)
+		fmt.Fprintln(w, "data:", string(data))
+		fmt.Fprintln(w) //end of event
+		flusher.Flush()
+		id++
+	}
+	send("progress", job.Progress())
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case p, ok := <-updates:
+			if !ok {
+				// The last update may have been dropped, but the job has it.
+				send("done", job.Progress())
+				return
+			}
+			send("progress", p)
+		}
+	}
+}
+
+// getCrawlJob returns the latest crawl, or writes an error if there hasn't
+// been one.
+func (a *RestServer) getCrawlJob(w http.ResponseWriter) *crawler.Job {
+	a.crawlMutex.Lock()
+	defer a.crawlMutex.Unlock()
+	if a.crawlJob == nil {
+		http.Error(w, "No crawl has been started", http.StatusNotFound)
+	}
+	return a.crawlJob
+}
+
+// @Summary		Ping a remote node through the mesh. The output contains following fields: key, address, size, sent, received, loss, rtt_min, rtt_avg, rtt_max, probes.
+// @Produce		json
+// @Param		key	path			string				true	"Public key string or address"
+// @Param		count	query		int					false	"Probes to send, 4 by default and 100 at most"
+// @Param		size	query		int					false	"Bytes in each probe, 56 by default"
+// @Success		200		{string}	string		"ok"
+// @Failure		400		{error}		error		"Bad request"
+// @Failure		401		{error}		error		"Authentication failed"
+// @Failure		404		{error}		error		"Not found"
+// @Router		/ping/{key} [get]
+func (a *RestServer) getApiPingHandler(w http.ResponseWriter, r *http.Request) {
+	cnt := strings.Split(r.URL.Path, "/")
+	if len(cnt) != 4 || cnt[3] == "" {
+		http.Error(w, "No remote public key supplied", http.StatusBadRequest)
+		return
+	}
+	key, err := a.keyForPing(cnt[3])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	count, size := 4, 56
+	for name, value := range map[string]*int{"count": &count, "size": &size} {
+		if v := r.URL.Query().Get(name); v != "" {
+			if *value, err = strconv.Atoi(v); err != nil {
+				http.Error(w, "Invalid "+name, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	result, err := a.Core.Ping(key, count, size)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ms := func(d time.Duration) float64 {
+		return float64(d.Microseconds()) / 1000
+	}
+	probes := make([]map[string]any, 0, len(result.Probes))
+	for _, probe := range result.Probes {
+		probes = append(probes, map[string]any{
+			"seq":  probe.Seq,
+			"rtt":  ms(probe.RTT),
+			"lost": probe.Lost,
+		})
+	}
+	WriteJson(w, r, map[string]any{
+		"key":      hex.EncodeToString(result.Key),
+		"address":  net.IP(a.Core.AddrForKey(result.Key)[:]).String(),
+		"size":     result.Size,
+		"sent":     result.Sent,
+		"received": result.Received,
+		"loss":     result.Loss * 100,
+		"rtt_min":  ms(result.RTTMin),
+		"rtt_avg":  ms(result.RTTAvg),
+		"rtt_max":  ms(result.RTTMax),
+		"probes":   probes,
+	})
+}
+
+// keyForPing returns the key in a hex string, or of a mesh address. Only
+// the leading bits of a key can be worked out from an address, so it has to
+// belong to a node that this one has a peering, path, session or DHT entry
+// with.
+func (a *RestServer) keyForPing(s string) (ed25519.PublicKey, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		key, err := hex.DecodeString(s)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid public key or address")
+		}
+		return key, nil
+	}
+	var addr core.Address
+	copy(addr[:], ip.To16())
+	var keys []ed25519.PublicKey
+	for _, peer := range a.Core.GetPeers() {
+		keys = append(keys, peer.Key)
+	}
+	for _, path := range a.Core.GetPaths() {
+		keys = append(keys, path.Key)
+	}
+	for _, session := range a.Core.GetSessions() {
+		keys = append(keys, session.Key)
+	}
+	for _, entry := range a.Core.GetDHT() {
+		keys = append(keys, entry.Key)
+	}
+	for _, key := range keys {
+		if *a.Core.AddrForKey(key) == addr {
+			return key, nil
+		}
+	}
+	return nil, errors.New("the public key for " + s + " isn't known, use the key instead")
+}
+
 func (a *RestServer) postApiHealthHandler(w http.ResponseWriter, r *http.Request) {
 	peer_list := []string{}
 
@@ -814,6 +1556,47 @@ Loop:
 	}
 }
 
+// forwardCoreEvents sends the events from the core as server side events:
+// link events as "link", so that the reason a link went down or was rejected
+// can be seen without the logs, and spanning tree events as "tree".
+func (a *RestServer) forwardCoreEvents(events <-chan core.Event) {
+	for e := range events {
+		event, entry := "link", map[string]any{}
+		var key ed25519.PublicKey
+		var err error
+		switch e := e.(type) {
+		case core.LinkConnecting:
+			entry["event"], entry["uri"], entry["link_type"], entry["direction"] = "connecting", e.URI, e.LinkType, e.Direction
+		case core.LinkConnected:
+			entry["event"], entry["uri"], entry["link_type"], entry["direction"] = "connected", e.URI, e.LinkType, e.Direction
+			key = e.Key
+		case core.LinkDisconnected:
+			entry["event"], entry["uri"], entry["link_type"], entry["direction"] = "disconnected", e.URI, e.LinkType, e.Direction
+			key, err = e.Key, e.Err
+		case core.HandshakeRejected:
+			entry["event"], entry["uri"], entry["link_type"], entry["direction"] = "rejected", e.URI, e.LinkType, e.Direction
+			key, err = e.Key, e.Err
+		case core.RootChanged:
+			event, entry["event"], entry["root"] = "tree", "root", hex.EncodeToString(e.Root)
+		case core.CoordsChanged:
+			event, entry["event"], entry["coords"] = "tree", "coords", e.Coords
+		default:
+			continue
+		}
+		if key != nil {
+			entry["key"] = hex.EncodeToString(key)
+		}
+		if err != nil {
+			entry["error"] = err.Error()
+		}
+		data, _ := json.Marshal(entry)
+		select {
+		case a.serverEvents <- ServerEvent{Event: event, Data: data}:
+		default:
+		}
+	}
+}
+
 func (a *RestServer) sendSseUpdate() {
 	rx, tx := a.getPeersRxTxBytes()
 	a.serverEvents <- ServerEvent{Event: "rxtx", Data: []byte(fmt.Sprintf(`[{"bytes_recvd":%d,"bytes_sent":%d}]`, rx, tx))}
@@ -6,6 +6,7 @@ package tun
 // TODO: Don't block in reader on writes that are pending searches
 
 import (
+	"crypto/ed25519"
 	"errors"
 	"fmt"
 	"net"
@@ -145,6 +146,12 @@ func (tun *TunAdapter) IsStarted() bool {
 	return isOpen
 }
 
+// KeyForAddress returns the public key that a mesh address belongs to, if
+// there has been traffic to or from it recently, or nil.
+func (tun *TunAdapter) KeyForAddress(ip net.IP) ed25519.PublicKey {
+	return tun.rwc.KeyForAddress(ip)
+}
+
 func (tun *TunAdapter) Stop() error {
 	var err error
 	phony.Block(tun, func() {